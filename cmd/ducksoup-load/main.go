@@ -0,0 +1,230 @@
+// Command ducksoup-load opens many sessions on a running DuckSoup server, following the
+// signaling of ducksoup.js, publishes synthetic or file-based audio and video, and
+// reports join latency, time-to-first-frame, bitrates and failures
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type options struct {
+	url           string
+	origin        string
+	interactions  int
+	size          int
+	duration      int // in seconds
+	namespace     string
+	recordingMode string
+	videoFormat   string
+	audioOnly     bool
+	audioFile     string
+	videoFile     string
+	videoKbps     int
+	ramp          time.Duration // between two interactions
+	margin        time.Duration // waited for after duration before timing out
+	noICEServers  bool
+	json          bool
+}
+
+func parseOptions(args []string) (o options, err error) {
+	fs := flag.NewFlagSet("ducksoup-load", flag.ContinueOnError)
+	fs.StringVar(&o.url, "url", "ws://localhost:8100/ws", "websocket endpoint")
+	fs.StringVar(&o.origin, "origin", "http://localhost:8100", "Origin header, must be allowed by DUCKSOUP_ALLOWED_WS_ORIGINS")
+	fs.IntVar(&o.interactions, "interactions", 1, "number of interactions")
+	fs.IntVar(&o.size, "size", 2, "participants per interaction")
+	fs.IntVar(&o.duration, "duration", 30, "interaction duration (in seconds)")
+	fs.StringVar(&o.namespace, "namespace", "load", "namespace of the interactions")
+	fs.StringVar(&o.recordingMode, "mode", "bypass", "recording mode")
+	fs.StringVar(&o.videoFormat, "format", "VP8", "video format (VP8 or H264)")
+	fs.BoolVar(&o.audioOnly, "audio-only", false, "publishes audio only")
+	fs.StringVar(&o.audioFile, "audio", "", "Ogg/Opus file (defaults to silence)")
+	fs.StringVar(&o.videoFile, "video", "", "IVF (VP8) or Annex-B H264 file (defaults to synthetic frames)")
+	fs.IntVar(&o.videoKbps, "video-kbps", 1000, "bitrate of synthetic video")
+	fs.DurationVar(&o.ramp, "ramp", 200*time.Millisecond, "delay between the start of two interactions")
+	fs.DurationVar(&o.margin, "margin", 60*time.Second, "time waited for after duration before a session is considered timed out")
+	fs.BoolVar(&o.noICEServers, "no-ice-servers", false, "ignores the ICE servers sent by the server (host candidates only)")
+	fs.BoolVar(&o.json, "json", false, "prints the report as JSON")
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+	if o.size < 1 || o.interactions < 1 || o.duration < 1 {
+		err = fmt.Errorf("interactions, size and duration must be positive")
+	} else if o.videoFormat != "VP8" && o.videoFormat != "H264" {
+		err = fmt.Errorf("invalid video format: %v", o.videoFormat)
+	} else if o.recordingMode != "bypass" && !o.audioOnly && len(o.videoFile) == 0 {
+		// synthetic frames can't be decoded by processing pipelines
+		err = fmt.Errorf("mode %v requires a -video file (or -audio-only)", o.recordingMode)
+	}
+	return
+}
+
+func main() {
+	o, err := parseOptions(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	runId := strconv.FormatInt(time.Now().Unix(), 10)
+	peers := []*peer{}
+	var wg sync.WaitGroup
+	startedAt := time.Now()
+	for i := 0; i < o.interactions; i++ {
+		interactionName := fmt.Sprintf("load-%v-%v", runId, i)
+		for u := 0; u < o.size; u++ {
+			p := newPeer(o, interactionName, fmt.Sprintf("user-%v", u))
+			peers = append(peers, p)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.run()
+			}()
+		}
+		time.Sleep(o.ramp)
+	}
+	wg.Wait()
+
+	r := newReport(peers, time.Since(startedAt))
+	if o.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(r)
+	} else {
+		r.print()
+	}
+	if r.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// report
+
+type distribution struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+	Max    float64 `json:"max"`
+}
+
+func newDistribution(values []float64) (d distribution) {
+	d.Count = len(values)
+	if d.Count == 0 {
+		return
+	}
+	sort.Float64s(values)
+	d.Min = values[0]
+	d.Median = values[d.Count/2]
+	d.P95 = values[min(d.Count-1, d.Count*95/100)]
+	d.Max = values[d.Count-1]
+	return
+}
+
+type report struct {
+	Sessions      int                     `json:"sessions"`
+	Succeeded     int                     `json:"succeeded"`
+	Failed        int                     `json:"failed"`
+	Failures      map[string]int          `json:"failures"` // by cause
+	ElapsedS      float64                 `json:"elapsedS"`
+	JoinLatencyMs distribution            `json:"joinLatencyMs"` // from ws dial to joined
+	ConnectMs     distribution            `json:"connectMs"`     // from ws dial to pc connected
+	FirstFrameMs  map[string]distribution `json:"firstFrameMs"`  // from ws dial to first received packet, per kind
+	InKbps        map[string]distribution `json:"inKbps"`        // per received track, by kind
+	OutKbps       map[string]distribution `json:"outKbps"`       // per sent track, by kind
+}
+
+func newReport(peers []*peer, elapsed time.Duration) (r report) {
+	r.Sessions = len(peers)
+	r.Failures = make(map[string]int)
+	r.ElapsedS = elapsed.Seconds()
+	joins, connects := []float64{}, []float64{}
+	firstFrames := map[string][]float64{}
+	in, out := map[string][]float64{}, map[string][]float64{}
+
+	for _, p := range peers {
+		p.Lock()
+		failure := p.failure
+		// tracks only count once a packet has been received on them
+		receivedCount := 0
+		for _, f := range p.received {
+			if !f.firstAt.IsZero() {
+				receivedCount++
+			}
+		}
+		if len(failure) == 0 && receivedCount < p.expectedTrackCount() {
+			failure = "missing_tracks"
+		}
+		if len(failure) == 0 {
+			r.Succeeded++
+		} else {
+			r.Failed++
+			r.Failures[failure]++
+		}
+		if !p.joinedAt.IsZero() {
+			joins = append(joins, ms(p.joinedAt.Sub(p.startedAt)))
+		}
+		if !p.connectedAt.IsZero() {
+			connects = append(connects, ms(p.connectedAt.Sub(p.startedAt)))
+		}
+		for _, f := range p.received {
+			if !f.firstAt.IsZero() {
+				firstFrames[f.kind] = append(firstFrames[f.kind], ms(f.firstAt.Sub(p.startedAt)))
+				in[f.kind] = append(in[f.kind], f.kbps())
+			}
+		}
+		for _, f := range p.sent {
+			out[f.kind] = append(out[f.kind], f.kbps())
+		}
+		p.Unlock()
+	}
+
+	r.JoinLatencyMs = newDistribution(joins)
+	r.ConnectMs = newDistribution(connects)
+	r.FirstFrameMs = distributionsByKind(firstFrames)
+	r.InKbps = distributionsByKind(in)
+	r.OutKbps = distributionsByKind(out)
+	return
+}
+
+func distributionsByKind(values map[string][]float64) map[string]distribution {
+	ds := make(map[string]distribution)
+	for kind, v := range values {
+		ds[kind] = newDistribution(v)
+	}
+	return ds
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func (r report) print() {
+	fmt.Printf("sessions: %v (succeeded: %v, failed: %v) in %.1fs\n", r.Sessions, r.Succeeded, r.Failed, r.ElapsedS)
+	for cause, count := range r.Failures {
+		fmt.Printf("  failure %v: %v\n", cause, count)
+	}
+	printDistribution("join latency (ms)", r.JoinLatencyMs)
+	printDistribution("connection (ms)", r.ConnectMs)
+	for _, kind := range []string{"audio", "video"} {
+		if d, ok := r.FirstFrameMs[kind]; ok {
+			printDistribution(kind+" first frame (ms)", d)
+		}
+		if d, ok := r.InKbps[kind]; ok {
+			printDistribution(kind+" in (kbps)", d)
+		}
+		if d, ok := r.OutKbps[kind]; ok {
+			printDistribution(kind+" out (kbps)", d)
+		}
+	}
+}
+
+func printDistribution(label string, d distribution) {
+	fmt.Printf("%-24v n=%-5v min=%-9.1f median=%-9.1f p95=%-9.1f max=%.1f\n", label, d.Count, d.Min, d.Median, d.P95, d.Max)
+}
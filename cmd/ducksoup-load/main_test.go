@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	o, err := parseOptions([]string{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.recordingMode != "bypass" || o.videoFormat != "VP8" || o.size != 2 || o.ramp != 200*time.Millisecond {
+		t.Errorf("unexpected defaults: %+v", o)
+	}
+
+	o, err = parseOptions([]string{"-interactions", "3", "-size", "1", "-format", "H264", "-audio-only", "-json"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.interactions != 3 || o.size != 1 || o.videoFormat != "H264" || !o.audioOnly || !o.json {
+		t.Errorf("unexpected options: %+v", o)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"zero size", []string{"-size", "0"}, true},
+		{"negative duration", []string{"-duration", "-1"}, true},
+		{"invalid format", []string{"-format", "VP9"}, true},
+		{"unknown flag", []string{"-unknown"}, true},
+		{"processing without video file", []string{"-mode", "split"}, true},
+		{"processing with video file", []string{"-mode", "split", "-video", "in.ivf"}, false},
+		{"processing audio only", []string{"-mode", "split", "-audio-only"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseOptions(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewDistribution(t *testing.T) {
+	if d := newDistribution(nil); d != (distribution{}) {
+		t.Errorf("expected empty distribution, got %+v", d)
+	}
+	if d := newDistribution([]float64{7}); d != (distribution{Count: 1, Min: 7, Median: 7, P95: 7, Max: 7}) {
+		t.Errorf("unexpected distribution: %+v", d)
+	}
+
+	values := []float64{}
+	for v := 100; v > 0; v-- {
+		values = append(values, float64(v))
+	}
+	want := distribution{Count: 100, Min: 1, Median: 51, P95: 96, Max: 100}
+	if d := newDistribution(values); d != want {
+		t.Errorf("got %+v, want %+v", d, want)
+	}
+}
+
+func TestNewReport(t *testing.T) {
+	o := options{size: 2}
+	startedAt := time.Now()
+	complete := &peer{o: o, startedAt: startedAt, received: map[string]*flow{
+		"a": {kind: "audio"},
+		"v": {kind: "video"},
+	}}
+	complete.received["a"].add(100)
+	complete.received["v"].add(1000)
+	// the video track has been announced but no packet has been received
+	silent := &peer{o: o, startedAt: startedAt, received: map[string]*flow{
+		"a": {kind: "audio"},
+		"v": {kind: "video"},
+	}}
+	silent.received["a"].add(100)
+
+	r := newReport([]*peer{complete, silent}, time.Second)
+	if r.Succeeded != 1 || r.Failed != 1 || r.Failures["missing_tracks"] != 1 {
+		t.Errorf("unexpected report: %+v", r)
+	}
+	if r.FirstFrameMs["video"].Count != 1 || r.FirstFrameMs["audio"].Count != 2 {
+		t.Errorf("unexpected first frames: %+v", r.FirstFrameMs)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/h264reader"
+	"github.com/pion/webrtc/v3/pkg/media/ivfreader"
+	"github.com/pion/webrtc/v3/pkg/media/oggreader"
+)
+
+const (
+	opusFrameDuration  = 20 * time.Millisecond
+	syntheticFramerate = 30
+	// sent every syntheticKeyFrameInterval frames
+	syntheticKeyFrameInterval = 90
+)
+
+// an Opus frame (CELT, 20 ms) decoded as silence
+var opusSilence = []byte{0xf8, 0xff, 0xfe}
+
+// mediaSource produces samples endlessly (file sources rewind at the end of file)
+type mediaSource interface {
+	next() (media.Sample, error)
+	close()
+}
+
+// synthetic audio
+
+type silenceSource struct{}
+
+func (s *silenceSource) next() (media.Sample, error) {
+	return media.Sample{Data: opusSilence, Duration: opusFrameDuration}, nil
+}
+
+func (s *silenceSource) close() {}
+
+// synthetic video: random payloads with the size of frames encoded at the given
+// bitrate. They go through the SFU but can't be decoded, see README
+type noiseSource struct {
+	rand      *rand.Rand
+	frameSize int
+	count     int
+}
+
+func newNoiseSource(kbps int) *noiseSource {
+	return &noiseSource{
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		frameSize: kbps * 1000 / 8 / syntheticFramerate,
+	}
+}
+
+func (s *noiseSource) next() (media.Sample, error) {
+	keyFrame := s.count%syntheticKeyFrameInterval == 0
+	s.count++
+	size := s.frameSize
+	if keyFrame {
+		size *= 4
+	}
+	data := make([]byte, max(size, 1))
+	s.rand.Read(data)
+	// the first bit of the VP8 frame tag is 0 for key frames
+	if keyFrame {
+		data[0] &= 0xfe
+	} else {
+		data[0] |= 0x01
+	}
+	return media.Sample{Data: data, Duration: time.Second / syntheticFramerate}, nil
+}
+
+func (s *noiseSource) close() {}
+
+// file sources
+
+type fileSource struct {
+	file  string
+	f     *os.File
+	open  func(f *os.File) error
+	parse func() (media.Sample, error)
+}
+
+func (s *fileSource) reopen() error {
+	if s.f != nil {
+		s.f.Close()
+	}
+	f, err := os.Open(s.file)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	return s.open(f)
+}
+
+func (s *fileSource) next() (media.Sample, error) {
+	sample, err := s.parse()
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		if err = s.reopen(); err != nil {
+			return sample, err
+		}
+		sample, err = s.parse()
+	}
+	return sample, err
+}
+
+func (s *fileSource) close() {
+	if s.f != nil {
+		s.f.Close()
+	}
+}
+
+// Ogg files with one Opus packet per page
+func newOggSource(file string) (mediaSource, error) {
+	s := &fileSource{file: file}
+	var reader *oggreader.OggReader
+	var lastGranule uint64
+	s.open = func(f *os.File) (err error) {
+		reader, _, err = oggreader.NewWith(f)
+		lastGranule = 0
+		return
+	}
+	s.parse = func() (media.Sample, error) {
+		for {
+			data, header, err := reader.ParseNextPage()
+			if err != nil {
+				return media.Sample{}, err
+			}
+			// skip header pages (OpusHead and OpusTags)
+			if header.GranulePosition == 0 {
+				continue
+			}
+			samples := header.GranulePosition - lastGranule
+			lastGranule = header.GranulePosition
+			return media.Sample{Data: data, Duration: time.Duration(samples) * time.Second / 48000}, nil
+		}
+	}
+	return s, s.reopen()
+}
+
+// IVF files (VP8)
+func newIVFSource(file string) (mediaSource, error) {
+	s := &fileSource{file: file}
+	var reader *ivfreader.IVFReader
+	var frameDuration time.Duration
+	s.open = func(f *os.File) error {
+		r, header, err := ivfreader.NewWith(f)
+		if err != nil {
+			return err
+		}
+		if header.TimebaseDenominator == 0 {
+			return errors.New("invalid IVF timebase")
+		}
+		reader = r
+		frameDuration = time.Duration(header.TimebaseNumerator) * time.Second / time.Duration(header.TimebaseDenominator)
+		return nil
+	}
+	s.parse = func() (media.Sample, error) {
+		data, _, err := reader.ParseNextFrame()
+		return media.Sample{Data: data, Duration: frameDuration}, err
+	}
+	return s, s.reopen()
+}
+
+// Annex-B H264 files, frames being sent at syntheticFramerate
+func newH264Source(file string) (mediaSource, error) {
+	s := &fileSource{file: file}
+	var reader *h264reader.H264Reader
+	s.open = func(f *os.File) (err error) {
+		reader, err = h264reader.NewReader(f)
+		return
+	}
+	s.parse = func() (media.Sample, error) {
+		nal, err := reader.NextNAL()
+		if err != nil {
+			return media.Sample{}, err
+		}
+		// parameter sets and other non-VCL units don't advance time
+		duration := time.Duration(0)
+		if nal.UnitType == h264reader.NalUnitTypeCodedSliceNonIdr || nal.UnitType == h264reader.NalUnitTypeCodedSliceIdr {
+			duration = time.Second / syntheticFramerate
+		}
+		return media.Sample{Data: nal.Data, Duration: duration}, nil
+	}
+	return s, s.reopen()
+}
+
+func newAudioSource(file string) (mediaSource, error) {
+	if len(file) == 0 {
+		return &silenceSource{}, nil
+	}
+	return newOggSource(file)
+}
+
+func newVideoSource(file string, kbps int) (mediaSource, error) {
+	if len(file) == 0 {
+		return newNoiseSource(kbps), nil
+	}
+	if strings.HasSuffix(file, ".ivf") {
+		return newIVFSource(file)
+	}
+	return newH264Source(file)
+}
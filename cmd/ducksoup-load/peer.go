@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+// same format as the messages exchanged by ducksoup.js
+type messageOut struct {
+	Kind    string `json:"kind"`
+	Payload string `json:"payload,omitempty"`
+}
+
+type messageIn struct {
+	Kind    string          `json:"kind"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type joinedPayload struct {
+	Context    string             `json:"context"`
+	IceServers []webrtc.ICEServer `json:"iceServers"`
+}
+
+// bytes sent or received on a track
+type flow struct {
+	kind    string
+	firstAt time.Time
+	lastAt  time.Time
+	bytes   int
+}
+
+func (f *flow) add(n int) {
+	now := time.Now()
+	if f.firstAt.IsZero() {
+		f.firstAt = now
+	}
+	f.lastAt = now
+	f.bytes += n
+}
+
+// over the time the flow has been active
+func (f *flow) kbps() float64 {
+	seconds := f.lastAt.Sub(f.firstAt).Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(f.bytes) * 8 / 1000 / seconds
+}
+
+// peer simulates one participant, following the signaling of ducksoup.js
+type peer struct {
+	sync.Mutex
+	o      options
+	jp     types.JoinPayload
+	ws     *websocket.Conn
+	wsMu   sync.Mutex
+	pc     *webrtc.PeerConnection
+	tracks []*webrtc.TrackLocalStaticSample
+	// signaling
+	answered          bool
+	pendingCandidates []webrtc.ICECandidateInit
+	// stats
+	startedAt   time.Time
+	joinedAt    time.Time
+	connectedAt time.Time
+	sent        map[string]*flow // by kind
+	received    map[string]*flow // by stream and track ids
+	failure     string
+	doneCh      chan struct{}
+}
+
+func newPeer(o options, interactionName, userId string) *peer {
+	return &peer{
+		o: o,
+		jp: types.JoinPayload{
+			InteractionName: interactionName,
+			UserId:          userId,
+			Namespace:       o.namespace,
+			Size:            o.size,
+			Duration:        o.duration,
+			RecordingMode:   o.recordingMode,
+			VideoFormat:     o.videoFormat,
+			AudioOnly:       o.audioOnly,
+		},
+		sent:     make(map[string]*flow),
+		received: make(map[string]*flow),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+func (p *peer) fail(cause string) {
+	p.Lock()
+	if len(p.failure) == 0 {
+		p.failure = cause
+	}
+	p.Unlock()
+}
+
+func (p *peer) send(kind string, payload any) error {
+	m := messageOut{Kind: kind}
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		m.Payload = string(b)
+	}
+	p.wsMu.Lock()
+	defer p.wsMu.Unlock()
+	return p.ws.WriteJSON(m)
+}
+
+// run blocks till the interaction ends, fails or times out
+func (p *peer) run() {
+	defer close(p.doneCh)
+
+	header := http.Header{}
+	header.Set("Origin", p.o.origin)
+	p.startedAt = time.Now()
+	ws, _, err := websocket.DefaultDialer.Dial(p.o.url, header)
+	if err != nil {
+		p.fail("ws_dial_failed")
+		return
+	}
+	p.ws = ws
+	defer ws.Close()
+
+	if err := p.send("join", p.jp); err != nil {
+		p.fail("ws_write_failed")
+		return
+	}
+
+	// interactions end after their duration plus a margin for the manifest, files...
+	timeout := time.AfterFunc(time.Duration(p.o.duration)*time.Second+p.o.margin, func() {
+		p.fail("timeout")
+		ws.Close()
+	})
+	defer timeout.Stop()
+
+	for {
+		m := messageIn{}
+		if err := ws.ReadJSON(&m); err != nil {
+			p.fail("ws_read_failed")
+			break
+		}
+		if done := p.handle(m); done {
+			break
+		}
+	}
+	if p.pc != nil {
+		p.pc.Close()
+	}
+}
+
+func (p *peer) handle(m messageIn) (done bool) {
+	switch m.Kind {
+	case "joined":
+		p.joinedAt = time.Now()
+		payload := joinedPayload{}
+		if err := json.Unmarshal(m.Payload, &payload); err != nil {
+			p.fail("joined_payload_invalid")
+			return true
+		}
+		if err := p.startPC(payload.IceServers); err != nil {
+			p.fail("pc_creation_failed")
+			return true
+		}
+	case "offer":
+		if err := p.answer(m.Payload); err != nil {
+			p.fail("answer_failed")
+			return true
+		}
+	case "candidate":
+		candidate := webrtc.ICECandidateInit{}
+		if err := unmarshalStringPayload(m.Payload, &candidate); err == nil && p.pc != nil {
+			p.pc.AddICECandidate(candidate)
+		}
+	case "end":
+		return true
+	default:
+		if strings.HasPrefix(m.Kind, "error") {
+			p.fail(m.Kind)
+			return true
+		}
+	}
+	return false
+}
+
+// signaling payloads are JSON encoded strings
+func unmarshalStringPayload(payload json.RawMessage, v any) error {
+	var s string
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(s), v)
+}
+
+func (p *peer) startPC(iceServers []webrtc.ICEServer) (err error) {
+	if p.o.noICEServers {
+		iceServers = nil
+	}
+	p.pc, err = webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers})
+	if err != nil {
+		return
+	}
+	// track ids must be unique in an interaction, like browser ones
+	audioTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, uuid.New().String(), p.jp.UserId)
+	if err != nil {
+		return
+	}
+	p.tracks = append(p.tracks, audioTrack)
+	if !p.o.audioOnly {
+		videoMimeType := webrtc.MimeTypeVP8
+		if p.o.videoFormat == "H264" {
+			videoMimeType = webrtc.MimeTypeH264
+		}
+		videoTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: videoMimeType, ClockRate: 90000}, uuid.New().String(), p.jp.UserId)
+		if err != nil {
+			return err
+		}
+		p.tracks = append(p.tracks, videoTrack)
+	}
+
+	p.pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+		p.Lock()
+		defer p.Unlock()
+		if p.answered {
+			p.send("client_ice_candidate", c.ToJSON())
+		} else {
+			// the server expects the answer first
+			p.pendingCandidates = append(p.pendingCandidates, c.ToJSON())
+		}
+	})
+	p.pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		switch s {
+		case webrtc.PeerConnectionStateConnected:
+			p.Lock()
+			p.connectedAt = time.Now()
+			p.Unlock()
+		case webrtc.PeerConnectionStateFailed:
+			p.fail("pc_failed")
+		}
+	})
+	p.pc.OnTrack(func(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		f := &flow{kind: remoteTrack.Kind().String()}
+		p.Lock()
+		p.received[remoteTrack.StreamID()+"/"+remoteTrack.ID()] = f
+		p.Unlock()
+		for {
+			// payloads are counted, to be compared with sent samples
+			packet, _, err := remoteTrack.ReadRTP()
+			if err != nil {
+				return
+			}
+			p.Lock()
+			f.add(len(packet.Payload))
+			p.Unlock()
+		}
+	})
+	return nil
+}
+
+func (p *peer) answer(payload json.RawMessage) error {
+	if p.pc == nil {
+		return errors.New("offer before joined")
+	}
+	offer := webrtc.SessionDescription{}
+	if err := unmarshalStringPayload(payload, &offer); err != nil {
+		return err
+	}
+	if err := p.pc.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+	p.Lock()
+	firstOffer := !p.answered
+	p.Unlock()
+	if firstOffer {
+		// reuses the transceivers created by the offer
+		for _, track := range p.tracks {
+			if _, err := p.pc.AddTrack(track); err != nil {
+				return err
+			}
+		}
+	}
+	answer, err := p.pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err = p.pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	if err = p.send("client_answer", answer); err != nil {
+		return err
+	}
+
+	p.Lock()
+	p.answered = true
+	pending := p.pendingCandidates
+	p.pendingCandidates = nil
+	p.Unlock()
+	for _, c := range pending {
+		p.send("client_ice_candidate", c)
+	}
+	if firstOffer {
+		for _, track := range p.tracks {
+			go p.publish(track)
+		}
+	}
+	return nil
+}
+
+func (p *peer) publish(track *webrtc.TrackLocalStaticSample) {
+	kind := track.Kind().String()
+	var source mediaSource
+	var err error
+	if kind == "audio" {
+		source, err = newAudioSource(p.o.audioFile)
+	} else {
+		source, err = newVideoSource(p.o.videoFile, p.o.videoKbps)
+	}
+	if err != nil {
+		p.fail(fmt.Sprintf("%v_source_failed", kind))
+		return
+	}
+	defer source.close()
+	f := &flow{kind: kind}
+	p.Lock()
+	p.sent[kind] = f
+	p.Unlock()
+
+	nextAt := time.Now()
+	for {
+		select {
+		case <-p.doneCh:
+			return
+		default:
+		}
+		sample, err := source.next()
+		if err != nil {
+			p.fail(fmt.Sprintf("%v_source_failed", kind))
+			return
+		}
+		if err := track.WriteSample(sample); err != nil {
+			return
+		}
+		p.Lock()
+		f.add(len(sample.Data))
+		p.Unlock()
+		nextAt = nextAt.Add(sample.Duration)
+		time.Sleep(time.Until(nextAt))
+	}
+}
+
+// expected tracks: from all other participants, or own tracks when size is 1 (mirror)
+func (p *peer) expectedTrackCount() int {
+	senders := p.o.size - 1
+	if p.o.size == 1 {
+		senders = 1
+	}
+	if p.o.audioOnly {
+		return senders
+	}
+	return 2 * senders
+}
@@ -17,12 +17,18 @@ type SFUConfig struct {
 	}
 	Audio SFUStream
 	Video SFUStream
+	// voice activity detection, threshold in dBFS and hangover in ms
+	VAD struct {
+		Threshold float64 `yaml:"threshold"`
+		Hangover  int     `yaml:"hangover"`
+	}
 }
 
 type SFUStream struct {
-	DefaultBitrate int `yaml:"defaultBitrate"`
-	MinBitrate     int `yaml:"minBitrate"`
-	MaxBitrate     int `yaml:"maxBitrate"`
+	DefaultBitrate   int `yaml:"defaultBitrate"`
+	MinBitrate       int `yaml:"minBitrate"`
+	MaxBitrate       int `yaml:"maxBitrate"`
+	RecordingBitrate int `yaml:"recordingBitrate"`
 }
 
 type versionConfig struct {
@@ -143,7 +143,7 @@ func init() {
 // APIs are used to create peer connections, possible codecs are set once for all (at API level)
 // but preferred codecs for a given track are set at transceiver level
 // currently NewWebRTCAPI (rather than pion default one) prevents a freeze/lag observed after ~20 seconds
-func NewWebRTCAPI(estimatorCh chan cc.BandwidthEstimator, logger zerolog.Logger) (*webrtc.API, error) {
+func NewWebRTCAPI(estimatorCh chan cc.BandwidthEstimator, impairer *Impairer, logger zerolog.Logger) (*webrtc.API, error) {
 	s := webrtc.SettingEngine{}
 	s.SetSRTPReplayProtectionWindow(512)
 	s.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
@@ -173,7 +173,7 @@ func NewWebRTCAPI(estimatorCh chan cc.BandwidthEstimator, logger zerolog.Logger)
 	i := &interceptor.Registry{}
 
 	// enhance them
-	if err := configureAPIOptions(m, i, estimatorCh, logger); err != nil {
+	if err := configureAPIOptions(m, i, estimatorCh, impairer, logger); err != nil {
 		logger.Error().Err(err).Str("context", "peer").Msg("configure_api_failed")
 	}
 
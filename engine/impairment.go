@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+const impairedQueueSize = 1024
+
+// Impairer is an interceptor adding delay, jitter and loss to the streams sent on a
+// peer connection (one Impairer per API, thus per peer connection), depending on
+// the user who sent them in the first place
+type Impairer struct {
+	interceptor.NoOp
+	mu          sync.Mutex
+	fromIndex   map[uint32]string           // per local SSRC, sender user id
+	impairments map[string]types.Impairment // per sender user id
+	streams     map[uint32]*impairedStream
+}
+
+type impairedPacket struct {
+	sendAt     time.Time
+	order      uint64 // packets with the same send time are written in push order
+	header     rtp.Header
+	payload    []byte
+	attributes interceptor.Attributes
+}
+
+// packets sorted by send time, see container/heap
+type impairedQueue []impairedPacket
+
+func (q impairedQueue) Len() int { return len(q) }
+func (q impairedQueue) Less(i, j int) bool {
+	if q[i].sendAt.Equal(q[j].sendAt) {
+		return q[i].order < q[j].order
+	}
+	return q[i].sendAt.Before(q[j].sendAt)
+}
+func (q impairedQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *impairedQueue) Push(x any)   { *q = append(*q, x.(impairedPacket)) }
+func (q *impairedQueue) Pop() any {
+	old := *q
+	p := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return p
+}
+
+func NewImpairer() *Impairer {
+	return &Impairer{
+		fromIndex:   make(map[uint32]string),
+		impairments: make(map[string]types.Impairment),
+		streams:     make(map[uint32]*impairedStream),
+	}
+}
+
+// NewInterceptor makes Impairer an interceptor.Factory
+func (im *Impairer) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return im, nil
+}
+
+// Bind declares the user who sent the stream written with ssrc
+func (im *Impairer) Bind(ssrc uint32, fromUserId string) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.fromIndex[ssrc] = fromUserId
+}
+
+// Set updates the impairment of streams sent by imp.From
+func (im *Impairer) Set(imp types.Impairment) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.impairments[imp.From] = imp
+}
+
+func (im *Impairer) impairmentOf(ssrc uint32) (imp types.Impairment, ok bool) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	from, ok := im.fromIndex[ssrc]
+	if !ok {
+		return
+	}
+	imp, ok = im.impairments[from]
+	return
+}
+
+// written packets of a local stream
+type impairedStream struct {
+	sync.Mutex // writes may come from several goroutines (retransmissions)
+	queue      impairedQueue
+	pushCount  uint64
+	wakeCh     chan struct{} // signals the loop that a packet has been queued
+	doneCh     chan struct{}
+	random     *rand.Rand
+	lastSendAt time.Time // latest send time of queued packets
+	// jitter is drawn once per frame (RTP timestamp)
+	frameTimestamp uint32
+	frameJitter    time.Duration
+	hasFrame       bool
+}
+
+func newImpairedStream(seed int64) *impairedStream {
+	return &impairedStream{
+		wakeCh: make(chan struct{}, 1),
+		doneCh: make(chan struct{}),
+		random: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// packets are written once their send time has come, in send time order
+func (s *impairedStream) loop(writer interceptor.RTPWriter) {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		s.Lock()
+		if len(s.queue) > 0 && !time.Now().Before(s.queue[0].sendAt) {
+			p := heap.Pop(&s.queue).(impairedPacket)
+			s.Unlock()
+			writer.Write(&p.header, p.payload, p.attributes)
+			continue
+		}
+		var timerCh <-chan time.Time
+		if len(s.queue) > 0 {
+			timer.Reset(time.Until(s.queue[0].sendAt))
+			timerCh = timer.C
+		}
+		s.Unlock()
+
+		select {
+		case <-s.doneCh:
+			return
+		case <-s.wakeCh:
+		case <-timerCh:
+		}
+		if timerCh != nil && !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// header and payload buffers are reused by the caller, so they are copied. Should be
+// called with the stream locked
+func (s *impairedStream) push(sendAt time.Time, header *rtp.Header, payload []byte, attributes interceptor.Attributes) {
+	if len(s.queue) >= impairedQueueSize {
+		// queue is full, packet is lost
+		return
+	}
+	s.pushCount++
+	heap.Push(&s.queue, impairedPacket{sendAt, s.pushCount, header.Clone(), append([]byte{}, payload...), attributes})
+	if sendAt.After(s.lastSendAt) {
+		s.lastSendAt = sendAt
+	}
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// send time of a packet written at now, drop being true if the packet is lost. The
+// delay is uniformly distributed in [Delay - Jitter, Delay + Jitter] (and not below
+// 0), drawn once per frame so that packets of a frame are not reordered, while
+// frames may be. Should be called with the stream locked
+func (s *impairedStream) sendTime(now time.Time, imp types.Impairment, header *rtp.Header) (sendAt time.Time, drop bool) {
+	if imp.Loss > 0 && s.random.Float64() < imp.Loss {
+		return now, true
+	}
+	delay := time.Duration(imp.Delay) * time.Millisecond
+	if imp.Jitter > 0 {
+		if !s.hasFrame || header.Timestamp != s.frameTimestamp {
+			s.frameTimestamp, s.hasFrame = header.Timestamp, true
+			s.frameJitter = time.Duration(s.random.Int63n(int64(2*imp.Jitter+1))-int64(imp.Jitter)) * time.Millisecond
+		}
+		delay += s.frameJitter
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return now.Add(delay), false
+}
+
+func (im *Impairer) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	ssrc := info.SSRC
+	s := newImpairedStream(int64(ssrc))
+	im.mu.Lock()
+	im.streams[ssrc] = s
+	im.mu.Unlock()
+	go s.loop(writer)
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		size := header.MarshalSize() + len(payload)
+		imp, ok := im.impairmentOf(ssrc)
+
+		s.Lock()
+		defer s.Unlock()
+		now := time.Now()
+		if !ok || imp.IsZero() {
+			if s.lastSendAt.After(now) {
+				// previously delayed packets are still queued, don't overtake them
+				s.push(s.lastSendAt, header, payload, attributes)
+				return size, nil
+			}
+			return writer.Write(header, payload, attributes)
+		}
+		if sendAt, drop := s.sendTime(now, imp, header); !drop {
+			s.push(sendAt, header, payload, attributes)
+		}
+		return size, nil
+	})
+}
+
+func (im *Impairer) UnbindLocalStream(info *interceptor.StreamInfo) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if s, ok := im.streams[info.SSRC]; ok {
+		close(s.doneCh)
+		delete(im.streams, info.SSRC)
+	}
+	delete(im.fromIndex, info.SSRC)
+}
+
+func (im *Impairer) Close() error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for ssrc, s := range im.streams {
+		close(s.doneCh)
+		delete(im.streams, ssrc)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+type writtenPacket struct {
+	sequenceNumber uint16
+	at             time.Time
+}
+
+// records written packets
+type fakeRTPWriter struct {
+	sync.Mutex
+	packets []writtenPacket
+}
+
+func (w *fakeRTPWriter) Write(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	w.packets = append(w.packets, writtenPacket{header.SequenceNumber, time.Now()})
+	return header.MarshalSize() + len(payload), nil
+}
+
+func (w *fakeRTPWriter) written() []writtenPacket {
+	w.Lock()
+	defer w.Unlock()
+	return append([]writtenPacket{}, w.packets...)
+}
+
+// waits till count packets have been written, or timeout
+func (w *fakeRTPWriter) waitFor(t *testing.T, count int, timeout time.Duration) []writtenPacket {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if packets := w.written(); len(packets) >= count {
+			return packets
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%v packets written, want %v", len(w.written()), count)
+	return nil
+}
+
+func newImpairedWriter(imp types.Impairment) (*Impairer, interceptor.RTPWriter, *fakeRTPWriter) {
+	im := NewImpairer()
+	im.Bind(1, imp.From)
+	im.Set(imp)
+	fw := &fakeRTPWriter{}
+	return im, im.BindLocalStream(&interceptor.StreamInfo{SSRC: 1}, fw), fw
+}
+
+func writePackets(w interceptor.RTPWriter, count int, interval time.Duration) (sentAt []time.Time) {
+	for index := 0; index < count; index++ {
+		sentAt = append(sentAt, time.Now())
+		w.Write(&rtp.Header{SequenceNumber: uint16(index), Timestamp: uint32(index * 3000)}, []byte{0}, nil)
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+	return
+}
+
+func TestImpairerDelay(t *testing.T) {
+	im, w, fw := newImpairedWriter(types.Impairment{From: "a", To: "b", Delay: 100})
+	defer im.Close()
+
+	sentAt := writePackets(w, 5, 10*time.Millisecond)
+	packets := fw.waitFor(t, 5, time.Second)
+	for index, p := range packets {
+		if p.sequenceNumber != uint16(index) {
+			t.Errorf("packet %v written at position %v", p.sequenceNumber, index)
+		}
+		if delay := p.at.Sub(sentAt[index]); delay < 100*time.Millisecond || delay > 150*time.Millisecond {
+			t.Errorf("packet %v delayed by %v", index, delay)
+		}
+	}
+}
+
+func TestImpairerWithoutImpairment(t *testing.T) {
+	im, w, fw := newImpairedWriter(types.Impairment{From: "a", To: "b", Delay: 100})
+	defer im.Close()
+
+	writePackets(w, 3, 0)
+	// removing the impairment doesn't let new packets overtake delayed ones
+	im.Set(types.Impairment{From: "a", To: "b"})
+	w.Write(&rtp.Header{SequenceNumber: 3}, []byte{0}, nil)
+	packets := fw.waitFor(t, 4, time.Second)
+	for index, p := range packets {
+		if p.sequenceNumber != uint16(index) {
+			t.Errorf("packet %v written at position %v", p.sequenceNumber, index)
+		}
+	}
+
+	// then packets are written at once
+	w.Write(&rtp.Header{SequenceNumber: 4}, []byte{0}, nil)
+	if count := len(fw.written()); count != 5 {
+		t.Errorf("packet not written synchronously")
+	}
+}
+
+func TestImpairerLoss(t *testing.T) {
+	im, w, fw := newImpairedWriter(types.Impairment{From: "a", To: "b", Loss: 0.3})
+	defer im.Close()
+
+	// below impairedQueueSize
+	count := 1000
+	writePackets(w, count, 0)
+	time.Sleep(100 * time.Millisecond)
+	if ratio := 1 - float64(len(fw.written()))/float64(count); ratio < 0.25 || ratio > 0.35 {
+		t.Errorf("loss ratio %v, want 0.3", ratio)
+	}
+}
+
+func TestImpairerJitter(t *testing.T) {
+	t.Run("Send times are uniformly distributed", func(t *testing.T) {
+		s := newImpairedStream(1)
+		imp := types.Impairment{Delay: 100, Jitter: 50}
+		now := time.Now()
+		count := 10000
+		var sum time.Duration
+		buckets := make([]int, 4) // per 25 ms from 50 ms
+		for index := 0; index < count; index++ {
+			sendAt, drop := s.sendTime(now, imp, &rtp.Header{Timestamp: uint32(index)})
+			delay := sendAt.Sub(now)
+			if drop || delay < 50*time.Millisecond || delay > 150*time.Millisecond {
+				t.Fatalf("unexpected delay %v (drop=%v)", delay, drop)
+			}
+			sum += delay
+			buckets[min(3, int((delay-50*time.Millisecond)/(25*time.Millisecond)))]++
+		}
+		if mean := sum / time.Duration(count); mean < 98*time.Millisecond || mean > 102*time.Millisecond {
+			t.Errorf("mean delay %v, want 100ms", mean)
+		}
+		for index, bucket := range buckets {
+			if bucket < count/4-count/20 || bucket > count/4+count/20 {
+				t.Errorf("%v delays in bucket %v, want about %v", bucket, index, count/4)
+			}
+		}
+	})
+
+	t.Run("Drawn once per frame", func(t *testing.T) {
+		s := newImpairedStream(1)
+		imp := types.Impairment{Delay: 100, Jitter: 50}
+		now := time.Now()
+		first, _ := s.sendTime(now, imp, &rtp.Header{Timestamp: 1})
+		for index := 0; index < 10; index++ {
+			if sendAt, _ := s.sendTime(now, imp, &rtp.Header{Timestamp: 1}); !sendAt.Equal(first) {
+				t.Fatal("packets of a frame have different delays")
+			}
+		}
+	})
+
+	t.Run("Not below zero", func(t *testing.T) {
+		s := newImpairedStream(1)
+		imp := types.Impairment{Jitter: 50}
+		now := time.Now()
+		for index := 0; index < 100; index++ {
+			if sendAt, _ := s.sendTime(now, imp, &rtp.Header{Timestamp: uint32(index)}); sendAt.Before(now) {
+				t.Fatal("packet sent in the past")
+			}
+		}
+	})
+
+	t.Run("Frames may be reordered", func(t *testing.T) {
+		im, w, fw := newImpairedWriter(types.Impairment{From: "a", To: "b", Delay: 60, Jitter: 50})
+		defer im.Close()
+
+		sentAt := writePackets(w, 50, 2*time.Millisecond)
+		packets := fw.waitFor(t, 50, time.Second)
+		reordered := false
+		var minDelay, maxDelay time.Duration = time.Hour, 0
+		for index, p := range packets {
+			if p.sequenceNumber != uint16(index) {
+				reordered = true
+			}
+			delay := p.at.Sub(sentAt[p.sequenceNumber])
+			minDelay, maxDelay = min(minDelay, delay), max(maxDelay, delay)
+		}
+		if !reordered {
+			t.Error("no packet reordered")
+		}
+		// jitter is not collapsed to delay + jitter
+		if maxDelay-minDelay < 50*time.Millisecond || minDelay < 10*time.Millisecond || maxDelay > 150*time.Millisecond {
+			t.Errorf("delays from %v to %v", minDelay, maxDelay)
+		}
+	})
+}
@@ -16,8 +16,11 @@ import (
 )
 
 // adapted from https://github.com/pion/webrtc/blob/v3.2.8/interceptor.go
-func configureAPIOptions(m *webrtc.MediaEngine, r *interceptor.Registry, estimatorCh chan cc.BandwidthEstimator, logger zerolog.Logger) error {
+func configureAPIOptions(m *webrtc.MediaEngine, r *interceptor.Registry, estimatorCh chan cc.BandwidthEstimator, impairer *Impairer, logger zerolog.Logger) error {
 	// order matters!
+	// first one is the closest to the network (impairments then apply to retransmissions too)
+	r.Add(impairer)
+
 	if env.LogLevel == 4 {
 		if err := configurePacketDump(r, logger); err != nil {
 			return err
@@ -60,6 +63,10 @@ func configureAPIOptions(m *webrtc.MediaEngine, r *interceptor.Registry, estimat
 		return err
 	}
 
+	if err := configureAudioLevelHeaderExtension(m); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -120,6 +127,13 @@ func configureAbsSendTimeHeaderExtension(m *webrtc.MediaEngine) error {
 	return nil
 }
 
+// For voice activity detection (RFC 6464)
+func configureAudioLevelHeaderExtension(m *webrtc.MediaEngine) error {
+	return m.RegisterHeaderExtension(
+		webrtc.RTPHeaderExtensionCapability{URI: sdp.AudioLevelURI}, webrtc.RTPCodecTypeAudio,
+	)
+}
+
 func configureSDESHeaderExtension(m *webrtc.MediaEngine) error {
 
 	if err := m.RegisterHeaderExtension(
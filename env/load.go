@@ -13,11 +13,14 @@ import (
 
 const (
 	TimeFormat = "20060102-150405.000"
+	// DataRoot is the folder (relative to the working directory) where interaction data,
+	// recordings and jobs are written
+	DataRoot = "data"
 )
 
 var ExplicitHostCandidate, ForceOverlay, GCC, GSTTracking, GeneratePlots, GenerateTWCC, InterceptGSTLogs, LogStdout, NoRecording, NVCodec, NVCuda bool
-var JitterBuffer, LogLevel int
-var LogFile, Mode, Port, PublicIP, TestLogin, TestPassword, TurnAddress, TurnPort, WebPrefix string
+var JitterBuffer, JobMaxLivePipelines, JobWorkers, LogLevel, MinFreeDiskMB int
+var LogFile, MediaFolder, Mode, Port, PublicIP, TestLogin, TestPassword, TurnAddress, TurnPort, WebPrefix string
 var AllowedWSOrigins, STUNServerURLS []string
 
 func getenvOr(key, fallback string) string {
@@ -88,6 +91,23 @@ func init() {
 		LogLevel = 150
 	}
 
+	JobWorkers, err = strconv.Atoi(os.Getenv("DUCKSOUP_JOB_WORKERS"))
+	if err != nil || JobWorkers < 1 {
+		JobWorkers = 1
+	}
+
+	// negative: jobs are not deferred
+	JobMaxLivePipelines, err = strconv.Atoi(os.Getenv("DUCKSOUP_JOB_MAX_LIVE_PIPELINES"))
+	if err != nil {
+		JobMaxLivePipelines = -1
+	}
+
+	// below this free space on the data folder disk, the server is not ready (see /readyz)
+	MinFreeDiskMB, err = strconv.Atoi(os.Getenv("DUCKSOUP_MIN_FREE_DISK_MB"))
+	if err != nil || MinFreeDiskMB < 0 {
+		MinFreeDiskMB = 1024
+	}
+
 	LogLevel, err = strconv.Atoi(os.Getenv("DUCKSOUP_LOG_LEVEL"))
 
 	if err != nil {
@@ -96,6 +116,8 @@ func init() {
 
 	// strings
 	LogFile = os.Getenv("DUCKSOUP_LOG_FILE")
+	// files played by confederates
+	MediaFolder = getenvOr("DUCKSOUP_MEDIA_FOLDER", "media")
 	Port = os.Getenv("DUCKSOUP_PORT")
 	if len(Port) < 2 {
 		Port = "8100"
@@ -80,10 +80,21 @@ const parseJoinPayload = (peerOptions) => {
     height,
     audioFx,
     videoFx,
+    receiverFx,
     framerate,
     namespace,
     videoFormat,
     recordingMode,
+    losslessAudio,
+    losslessVideo,
+    composite,
+    features,
+    speakingEvents,
+    couplings,
+    impairments,
+    confederates,
+    avOffset,
+    mirrorDelay,
     gpu,
     overlay,
   } = peerOptions;
@@ -96,6 +107,16 @@ const parseJoinPayload = (peerOptions) => {
   if (isNaN(framerate)) framerate = null;
   if (!gpu) gpu = null;
   if (!overlay) overlay = null;
+  if (!losslessAudio) losslessAudio = null;
+  if (!losslessVideo) losslessVideo = null;
+  if (!["dry", "wet"].includes(composite)) composite = null;
+  if (!features) features = null;
+  if (!speakingEvents) speakingEvents = null;
+  if (!Array.isArray(couplings)) couplings = null;
+  if (!Array.isArray(impairments)) impairments = null;
+  if (!Array.isArray(confederates)) confederates = null;
+  if (isNaN(avOffset)) avOffset = null;
+  if (isNaN(mirrorDelay)) mirrorDelay = null;
 
   return clean({
     interactionName,
@@ -107,10 +128,21 @@ const parseJoinPayload = (peerOptions) => {
     height,
     audioFx,
     videoFx,
+    receiverFx,
     framerate,
     namespace,
     videoFormat,
     recordingMode,
+    losslessAudio,
+    losslessVideo,
+    composite,
+    features,
+    speakingEvents,
+    couplings,
+    impairments,
+    confederates,
+    avOffset,
+    mirrorDelay,
     gpu,
     overlay,
   });
@@ -324,7 +356,7 @@ class DuckSoup {
     this.#stopped = true;
   }
 
-  controlFx(name, property, value, duration, userId) {
+  controlFx(name, property, value, duration, userId, receiverId) {
     if (!this.#checkControl(name, property, value, duration, userId)) return;
     this.#serverSend("client_control", {
       name,
@@ -332,13 +364,53 @@ class DuckSoup {
       value,
       ...(duration && { duration }),
       ...(userId && { userId }),
+      ...(receiverId && { receiverId }),
     });
   }
 
-  polyControlFx(name, property, kind, value) {
+  polyControlFx(name, property, kind, value, receiverId) {
     if (!this.#checkControl(name, property, value)) return;
     const strValue = value.toString();
-    this.#serverSend("client_polycontrol", { name, property, kind, value: strValue });
+    this.#serverSend("client_polycontrol", {
+      name,
+      property,
+      kind,
+      value: strValue,
+      ...(receiverId && { receiverId }),
+    });
+  }
+
+  // in ms, positive when audio is late
+  setAVOffset(offset, duration, userId) {
+    this.#serverSend("client_av_offset", {
+      offset,
+      ...(duration && { duration }),
+      ...(userId && { userId }),
+    });
+  }
+
+  // in ms, only when size is 1
+  setMirrorDelay(delay, duration) {
+    this.#serverSend("client_mirror_delay", {
+      delay,
+      ...(duration && { duration }),
+    });
+  }
+
+  // delay and jitter in ms, loss probability from 0 to 1
+  impair(from, to, delay, jitter, loss) {
+    this.#serverSend("client_impair", {
+      from,
+      to,
+      delay: delay || 0,
+      jitter: jitter || 0,
+      loss: loss || 0,
+    });
+  }
+
+  // action is "play", "pause" or "rewind"
+  controlConfederate(userId, action) {
+    this.#serverSend("client_confederate", { userId, action });
   }
 
   // add prefix to differentiate from ducksoup.js logs
@@ -476,7 +548,7 @@ class DuckSoup {
       } else if (kind.startsWith("error")) {
         this.#forward(message);
         this.stop(4000);
-      } else if (["other_joined", "other_left", "ending", "files", "end"].includes(kind)) {
+      } else if (["other_joined", "other_left", "speaking", "ending", "manifest", "files", "end"].includes(kind)) {
         // just forward
         this.#forward(message);
       }
@@ -387,7 +387,7 @@ const ducksoupListener = (options) => (message) => {
   } else if (kind === "files") {
     if (payload && payload[state.userId]) {
       let html = "The following files have been recorded:<br/><br/>";
-      html += payload[state.userId].join("<br/>") + "<br/>";
+      html += payload[state.userId].map(({ file, valid }) => valid ? file : `${file} (invalid)`).join("<br/>") + "<br/>";
       replaceMessage(html);
     } else {
       console.log(kind, payload);
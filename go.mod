@@ -13,6 +13,7 @@ require (
 	github.com/pion/ice/v2 v2.3.14
 	github.com/pion/interceptor v0.1.25
 	github.com/pion/rtcp v1.2.14
+	github.com/pion/rtp v1.8.3
 	github.com/pion/sdp/v3 v3.0.8
 	github.com/pion/turn/v2 v2.1.5
 	github.com/pion/webrtc/v3 v3.2.29
@@ -40,7 +41,6 @@ require (
 	github.com/pion/logging v0.2.2 // indirect
 	github.com/pion/mdns v0.0.12 // indirect
 	github.com/pion/randutil v0.1.0 // indirect
-	github.com/pion/rtp v1.8.3 // indirect
 	github.com/pion/sctp v1.8.12 // indirect
 	github.com/pion/srtp/v2 v2.0.18 // indirect
 	github.com/pion/stun v0.6.1 // indirect
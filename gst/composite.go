@@ -0,0 +1,113 @@
+package gst
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+const compositeAudioCaps = "audio/x-raw,rate=48000,channels=1"
+
+func gridSize(count int) (columns, rows int) {
+	columns = int(math.Ceil(math.Sqrt(float64(count))))
+	rows = (count + columns - 1) / columns
+	return
+}
+
+// writes a delayed audio branch of input index to the given sink (for instance "mixer."),
+// decoder is the name of an existing decodebin, if empty a new one is added
+func writeCompositeAudio(b *strings.Builder, index int, in types.CompositeInput, decoder, sink string) {
+	b.WriteString(fmt.Sprintf("concat name=audio_concat_%v ! queue ! %v\n", index, sink))
+	if in.AudioDelay >= time.Millisecond {
+		// 1ms buffers
+		b.WriteString(fmt.Sprintf("audiotestsrc wave=silence samplesperbuffer=48 num-buffers=%v ! %v ! queue ! audio_concat_%v.\n", in.AudioDelay.Milliseconds(), compositeAudioCaps, index))
+	}
+	if len(decoder) == 0 {
+		decoder = fmt.Sprintf("audio_decoder_%v", index)
+		b.WriteString(fmt.Sprintf("filesrc location=%v ! decodebin name=%v\n", quoteLocation(in.AudioFile), decoder))
+	}
+	b.WriteString(fmt.Sprintf("%v. ! audioconvert ! audioresample ! %v ! queue ! audio_concat_%v.\n", decoder, compositeAudioCaps, index))
+}
+
+// CompositeExtension is the extension of the video written by RenderComposite
+func CompositeExtension() string {
+	return gstConfig.X264.Extension
+}
+
+// RenderComposite writes a video file with participants side by side (or in a grid
+// if more than two), each tile being width x height, and their mixed audio
+func RenderComposite(inputs []types.CompositeInput, output string, width, height, framerate int) error {
+	videoCount := 0
+	for _, in := range inputs {
+		if len(in.VideoFile) > 0 {
+			videoCount++
+		}
+	}
+	if videoCount == 0 {
+		return errors.New("no_video_input")
+	}
+	columns, rows := gridSize(videoCount)
+	tileCaps := fmt.Sprintf("%v,width=%v,height=%v,framerate=%v/1", gstConfig.Shared.Video.RawFormat, width, height, framerate)
+
+	var b strings.Builder
+	b.WriteString(gstConfig.X264.Muxer + " name=mux ! filesink location=" + quoteLocation(output) + "\n")
+	// tiles positions are set on compositor pads, requested in the order of the description
+	b.WriteString("compositor name=comp background=black")
+	for index := 0; index < videoCount; index++ {
+		b.WriteString(fmt.Sprintf(" sink_%v::xpos=%v sink_%v::ypos=%v", index, (index%columns)*width, index, (index/columns)*height))
+	}
+	b.WriteString(fmt.Sprintf(" ! video/x-raw,width=%v,height=%v ! videoconvert ! %v ! queue ! mux.\n", columns*width, rows*height, gstConfig.X264.RecordingEncodeWith("video_encoder")))
+	b.WriteString("audiomixer name=mixer ! audioconvert ! " + gstConfig.Opus.EncodeWith("audio_encoder") + " ! queue ! mux.\n")
+
+	for index, in := range inputs {
+		if len(in.VideoFile) > 0 {
+			b.WriteString(fmt.Sprintf("concat name=video_concat_%v ! queue ! comp.\n", index))
+			if frames := in.VideoDelay.Milliseconds() * int64(framerate) / 1000; frames > 0 {
+				b.WriteString(fmt.Sprintf("videotestsrc pattern=black num-buffers=%v ! %v ! queue ! video_concat_%v.\n", frames, tileCaps, index))
+			}
+			b.WriteString(fmt.Sprintf("filesrc location=%v ! decodebin name=video_decoder_%v\n", quoteLocation(in.VideoFile), index))
+			b.WriteString(fmt.Sprintf("video_decoder_%v. ! videoconvert ! videoscale ! videorate ! %v ! queue ! video_concat_%v.\n", index, tileCaps, index))
+		}
+		if len(in.AudioFile) > 0 {
+			decoder := ""
+			if in.AudioFile == in.VideoFile {
+				decoder = fmt.Sprintf("video_decoder_%v", index)
+			}
+			writeCompositeAudio(&b, index, in, decoder, "mixer.")
+		}
+	}
+
+	if err := runPipeline(b.String(), 30*time.Minute); err != nil {
+		os.Remove(output)
+		return err
+	}
+	return nil
+}
+
+// RenderMultichannelWav writes a WAV file with one channel per input having audio,
+// in the order of inputs
+func RenderMultichannelWav(inputs []types.CompositeInput, output string) error {
+	var b strings.Builder
+	b.WriteString("interleave name=interleave ! audioconvert ! wavenc ! filesink location=" + quoteLocation(output) + "\n")
+	count := 0
+	for index, in := range inputs {
+		if len(in.AudioFile) > 0 {
+			writeCompositeAudio(&b, index, in, "", "interleave.")
+			count++
+		}
+	}
+	if count == 0 {
+		return errors.New("no_audio_input")
+	}
+
+	if err := runPipeline(b.String(), 30*time.Minute); err != nil {
+		os.Remove(output)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package gst
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+type concatEncoders struct {
+	audio string
+	video string
+	muxer string // empty if audio encoder outputs a file format (flac, wav)
+}
+
+// chooses encoders matching the kind (and extension) of the file to be written
+func newConcatEncoders(file string) (e concatEncoders, err error) {
+	base := filepath.Base(file)
+	extension := strings.TrimPrefix(filepath.Ext(base), ".")
+	e.audio = gstConfig.Opus.EncodeWith("audio_encoder")
+
+	switch {
+	case strings.Contains(base, "-lossless-audio-"):
+		e.audio = gstConfig.Lossless.Audio.Encoder
+	case strings.Contains(base, "-lossless-video-"):
+		e.video = gstConfig.Lossless.Video.Encoder
+		e.muxer = gstConfig.Lossless.Video.Muxer
+	case extension == gstConfig.Opus.Extension:
+		e.muxer = gstConfig.Opus.Muxer
+	case extension == gstConfig.X264.Extension:
+		e.video = gstConfig.X264.RecordingEncodeWith("video_encoder")
+		e.muxer = gstConfig.X264.Muxer
+	case extension == gstConfig.VP8.Extension:
+		e.video = gstConfig.VP8.RecordingEncodeWith("video_encoder")
+		e.muxer = gstConfig.VP8.Muxer
+	default:
+		err = errors.New("unhandled_extension")
+	}
+	return
+}
+
+// ConcatSegments decodes segments and encodes them to a single file, filling gaps
+// with silence and black frames (scaled to width x height at the given framerate).
+// All segments are expected to contain the same kinds of streams
+func ConcatSegments(segments []types.Segment, output string, width, height, framerate int) error {
+	if len(segments) == 0 {
+		return errors.New("no_segment")
+	}
+	encoders, err := newConcatEncoders(output)
+	if err != nil {
+		return err
+	}
+	hasAudio, hasVideo := segments[0].HasAudio, segments[0].HasVideo && len(encoders.video) > 0
+	audioCaps := "audio/x-raw,rate=48000,channels=1"
+	videoCaps := fmt.Sprintf("%v,width=%v,height=%v,framerate=%v/1", gstConfig.Shared.Video.RawFormat, width, height, framerate)
+
+	var b strings.Builder
+	sink := "filesink location=" + quoteLocation(output)
+	if len(encoders.muxer) > 0 {
+		b.WriteString(encoders.muxer + " name=mux ! " + sink + "\n")
+		sink = "queue ! mux."
+	}
+	if hasAudio {
+		b.WriteString("concat name=audio_concat ! audioconvert ! " + encoders.audio + " ! " + sink + "\n")
+	}
+	if hasVideo {
+		b.WriteString("concat name=video_concat ! videoconvert ! " + encoders.video + " ! " + sink + "\n")
+	}
+	// concat pads are requested in the order of the description
+	for index, s := range segments {
+		b.WriteString(fmt.Sprintf("filesrc location=%v ! decodebin name=decoder_%v\n", quoteLocation(s.File), index))
+		if hasAudio {
+			b.WriteString(fmt.Sprintf("decoder_%v. ! audioconvert ! audioresample ! %v ! queue ! audio_concat.\n", index, audioCaps))
+		}
+		if hasVideo {
+			b.WriteString(fmt.Sprintf("decoder_%v. ! videoconvert ! videoscale ! videorate ! %v ! queue ! video_concat.\n", index, videoCaps))
+		}
+		if s.Gap > 0 && index < len(segments)-1 {
+			if hasAudio {
+				// 10ms buffers
+				b.WriteString(fmt.Sprintf("audiotestsrc wave=silence samplesperbuffer=480 num-buffers=%v ! %v ! queue ! audio_concat.\n", s.Gap.Milliseconds()/10, audioCaps))
+			}
+			if hasVideo {
+				b.WriteString(fmt.Sprintf("videotestsrc pattern=black num-buffers=%v ! %v ! queue ! video_concat.\n", s.Gap.Milliseconds()*int64(framerate)/1000, videoCaps))
+			}
+		}
+	}
+
+	if err := runPipeline(b.String(), 30*time.Minute); err != nil {
+		os.Remove(output)
+		return err
+	}
+	return nil
+}
+
+// Transcode decodes file and encodes it to output, encoders depending on the output
+// extension (see ConcatSegments). If width or height is 0, the video size is kept
+func Transcode(file, output string, width, height, framerate int) error {
+	r := VerifyFile(file)
+	if !r.Valid {
+		return errors.New(r.Error)
+	}
+	s := types.Segment{File: file}
+	for _, stream := range r.Streams {
+		if strings.HasPrefix(stream.Codec, "audio/") {
+			s.HasAudio = true
+		} else if strings.HasPrefix(stream.Codec, "video/") {
+			s.HasVideo = true
+			if width == 0 || height == 0 {
+				width, height = stream.Width, stream.Height
+			}
+		}
+	}
+	return ConcatSegments([]types.Segment{s}, output, width, height, framerate)
+}
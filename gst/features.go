@@ -0,0 +1,382 @@
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0
+#include "gst.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/ducksouplab/ducksoup/vad"
+)
+
+const (
+	featuresInterval = 10 * time.Millisecond
+	featuresRate     = 48000
+	spectrumBands    = 128
+	spectrumFloor    = -80 // dB, spectrum threshold
+	levelFloor       = -120
+	// a frame interval above this ratio of the nominal interval means frames are missing
+	droppedFrameRatio = 1.5
+	// pitch is tracked on a downsampled branch, every featuresInterval over pitchWindow
+	pitchRate      = 16000
+	pitchMinHz     = 60
+	pitchMaxHz     = 500
+	pitchThreshold = 0.15 // YIN absolute threshold
+)
+
+// a window covers two periods of the lowest pitch
+var pitchWindow = 2 * pitchRate / pitchMinHz
+
+type levelSample struct {
+	at        time.Duration
+	rms, peak float64
+}
+
+// centroids and pitches are sorted by time
+type timedValue struct {
+	at    time.Duration
+	value float64
+}
+
+type videoFrame struct {
+	at            time.Duration
+	width, height int
+}
+
+// collects data forwarded from C during gstRunAnalysis
+type analysis struct {
+	sync.Mutex
+	levels        []levelSample
+	centroids     []timedValue
+	pitches       []timedValue
+	pitchBuffer   []float32 // samples not yet tracked, starting at pitchAt
+	pitchAt       time.Duration
+	frames        []videoFrame
+	width, height int
+}
+
+var (
+	analysisCount int64
+	analysisMu    sync.Mutex
+	analysisIndex = make(map[string]*analysis)
+)
+
+func findAnalysis(id string) (a *analysis, ok bool) {
+	analysisMu.Lock()
+	defer analysisMu.Unlock()
+
+	a, ok = analysisIndex[id]
+	return
+}
+
+// C exports
+
+//export goAnalysisLevel
+func goAnalysisLevel(cId *C.char, timestamp C.guint64, rms, peak C.double) {
+	if a, ok := findAnalysis(C.GoString(cId)); ok {
+		a.Lock()
+		defer a.Unlock()
+		a.levels = append(a.levels, levelSample{
+			at:   time.Duration(timestamp),
+			rms:  math.Max(float64(rms), levelFloor),
+			peak: math.Max(float64(peak), levelFloor),
+		})
+	}
+}
+
+//export goAnalysisSpectrum
+func goAnalysisSpectrum(cId *C.char, timestamp C.guint64, cMagnitudes *C.float, size C.guint) {
+	if a, ok := findAnalysis(C.GoString(cId)); ok {
+		magnitudes := unsafe.Slice((*float32)(unsafe.Pointer(cMagnitudes)), int(size))
+		a.Lock()
+		defer a.Unlock()
+		a.centroids = append(a.centroids, timedValue{time.Duration(timestamp), spectralCentroid(magnitudes)})
+	}
+}
+
+//export goAnalysisSamples
+func goAnalysisSamples(cId *C.char, timestamp C.guint64, cSamples *C.float, size C.guint) {
+	if a, ok := findAnalysis(C.GoString(cId)); ok {
+		samples := unsafe.Slice((*float32)(unsafe.Pointer(cSamples)), int(size))
+		a.Lock()
+		defer a.Unlock()
+		a.trackPitch(time.Duration(timestamp), samples)
+	}
+}
+
+//export goAnalysisFrame
+func goAnalysisFrame(cId *C.char, timestamp C.guint64) {
+	if a, ok := findAnalysis(C.GoString(cId)); ok {
+		a.Lock()
+		defer a.Unlock()
+		a.frames = append(a.frames, videoFrame{time.Duration(timestamp), a.width, a.height})
+	}
+}
+
+//export goAnalysisResolution
+func goAnalysisResolution(cId *C.char, width, height C.gint) {
+	if a, ok := findAnalysis(C.GoString(cId)); ok {
+		a.Lock()
+		defer a.Unlock()
+		a.width, a.height = int(width), int(height)
+	}
+}
+
+// magnitudes are in dB, band i being centered on (i + 0.5) * (rate / 2) / bands
+func spectralCentroid(magnitudes []float32) float64 {
+	bandWidth := float64(featuresRate) / 2 / float64(len(magnitudes))
+	var weighted, total float64
+	for i, m := range magnitudes {
+		if m <= spectrumFloor {
+			continue
+		}
+		power := math.Pow(10, float64(m)/10)
+		weighted += power * (float64(i) + 0.5) * bandWidth
+		total += power
+	}
+	if total == 0 {
+		return 0
+	}
+	return weighted / total
+}
+
+// estimates the fundamental frequency of samples with the YIN algorithm (difference
+// function, cumulative mean normalization, absolute threshold and parabolic
+// interpolation), returns 0 if no period is found between pitchMinHz and pitchMaxHz
+func estimatePitch(samples []float32, rate int) float64 {
+	minTau, maxTau := rate/pitchMaxHz, rate/pitchMinHz
+	width := len(samples) - maxTau
+	if width <= 0 {
+		return 0
+	}
+	cmnd := make([]float64, maxTau+1)
+	cmnd[0] = 1
+	var running float64
+	for tau := 1; tau <= maxTau; tau++ {
+		var diff float64
+		for j := 0; j < width; j++ {
+			delta := float64(samples[j] - samples[j+tau])
+			diff += delta * delta
+		}
+		running += diff
+		if running == 0 {
+			cmnd[tau] = 1
+		} else {
+			cmnd[tau] = diff * float64(tau) / running
+		}
+	}
+	for tau := minTau; tau <= maxTau; tau++ {
+		if cmnd[tau] >= pitchThreshold {
+			continue
+		}
+		// goes down to the local minimum
+		for tau < maxTau && cmnd[tau+1] < cmnd[tau] {
+			tau++
+		}
+		period := float64(tau)
+		if tau < maxTau {
+			prev, next := cmnd[tau-1], cmnd[tau+1]
+			if curvature := prev - 2*cmnd[tau] + next; curvature != 0 {
+				period += (prev - next) / (2 * curvature)
+			}
+		}
+		return float64(rate) / period
+	}
+	return 0
+}
+
+// buffers samples (at pitchRate) starting at timestamp and estimates pitch over
+// pitchWindow every featuresInterval
+func (a *analysis) trackPitch(timestamp time.Duration, samples []float32) {
+	if len(a.pitchBuffer) == 0 {
+		a.pitchAt = timestamp
+	}
+	a.pitchBuffer = append(a.pitchBuffer, samples...)
+	hop := int(featuresInterval * pitchRate / time.Second)
+	consumed := 0
+	for len(a.pitchBuffer)-consumed >= pitchWindow {
+		a.pitches = append(a.pitches, timedValue{a.pitchAt, estimatePitch(a.pitchBuffer[consumed:consumed+pitchWindow], pitchRate)})
+		consumed += hop
+		a.pitchAt += featuresInterval
+	}
+	a.pitchBuffer = append(a.pitchBuffer[:0], a.pitchBuffer[consumed:]...)
+}
+
+// value of the sorted values nearest to at, 0 if none is closer than featuresInterval
+func nearestValue(values []timedValue, at time.Duration) float64 {
+	index := sort.Search(len(values), func(i int) bool { return values[i].at >= at })
+	best, found := time.Duration(0), false
+	var value float64
+	for _, i := range []int{index - 1, index} {
+		if i < 0 || i >= len(values) {
+			continue
+		}
+		distance := values[i].at - at
+		if distance < 0 {
+			distance = -distance
+		}
+		if distance < featuresInterval && (!found || distance < best) {
+			best, found, value = distance, true, values[i].value
+		}
+	}
+	return value
+}
+
+func runAnalysis(pipelineStr string, timeout time.Duration) (*analysis, error) {
+	id := "analysis-" + strconv.FormatInt(atomic.AddInt64(&analysisCount, 1), 10)
+	a := &analysis{}
+	analysisMu.Lock()
+	analysisIndex[id] = a
+	analysisMu.Unlock()
+	defer func() {
+		analysisMu.Lock()
+		delete(analysisIndex, id)
+		analysisMu.Unlock()
+	}()
+
+	cPipelineStr := C.CString(pipelineStr)
+	defer C.free(unsafe.Pointer(cPipelineStr))
+	cId := C.CString(id)
+	defer C.free(unsafe.Pointer(cId))
+
+	cErr := C.gstRunAnalysis(cPipelineStr, C.int(timeout.Seconds()), cId)
+	if cErr != nil {
+		defer C.free(unsafe.Pointer(cErr))
+		return nil, errors.New(C.GoString(cErr))
+	}
+	return a, nil
+}
+
+func formatMs(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// writes time_ms,rms_db,peak_db,spectral_centroid_hz,pitch_hz,voice lines (pitch being
+// 0 without voice activity or periodicity), returns the voice ratio
+func writeAudioFeatures(a *analysis, output string, o types.FeatureOptions) (float64, error) {
+	detector := vad.NewDetector(o.VADThreshold, o.VADHangover)
+	voiced := 0
+
+	var b strings.Builder
+	b.WriteString("time_ms,rms_db,peak_db,spectral_centroid_hz,pitch_hz,voice\n")
+	for _, l := range a.levels {
+		speaking, _ := detector.Update(l.at, l.rms)
+		voice := 0
+		var pitch float64
+		if speaking {
+			voice = 1
+			voiced++
+			pitch = nearestValue(a.pitches, l.at)
+		}
+		b.WriteString(fmt.Sprintf("%v,%.2f,%.2f,%.1f,%.1f,%v\n", formatMs(l.at), l.rms, l.peak, nearestValue(a.centroids, l.at), pitch, voice))
+	}
+	if err := os.WriteFile(output, []byte(b.String()), 0644); err != nil {
+		return 0, err
+	}
+	if len(a.levels) == 0 {
+		return 0, nil
+	}
+	return float64(voiced) / float64(len(a.levels)), nil
+}
+
+// writes frame,time_ms,interval_ms,width,height,dropped lines (dropped being the
+// number of frames estimated missing before this one) and fills the video part of r
+func writeVideoStats(a *analysis, output string, r *types.FeatureReport) error {
+	intervals := []time.Duration{}
+	for i := 1; i < len(a.frames); i++ {
+		intervals = append(intervals, a.frames[i].at-a.frames[i-1].at)
+	}
+	var nominal time.Duration
+	if len(intervals) > 0 {
+		sorted := append([]time.Duration{}, intervals...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		nominal = sorted[len(sorted)/2]
+	}
+	if nominal > 0 {
+		r.Framerate = math.Round(float64(time.Second)/float64(nominal)*100) / 100
+	}
+
+	var b strings.Builder
+	b.WriteString("frame,time_ms,interval_ms,width,height,dropped\n")
+	for i, f := range a.frames {
+		var interval time.Duration
+		dropped := 0
+		if i > 0 {
+			interval = intervals[i-1]
+			if nominal > 0 && float64(interval) > droppedFrameRatio*float64(nominal) {
+				dropped = int(math.Round(float64(interval)/float64(nominal))) - 1
+			}
+			if f.width != a.frames[i-1].width || f.height != a.frames[i-1].height {
+				r.ResolutionChanges++
+			}
+		}
+		r.DroppedFrames += dropped
+		b.WriteString(fmt.Sprintf("%v,%v,%v,%v,%v,%v\n", i, formatMs(f.at), formatMs(interval), f.width, f.height, dropped))
+	}
+	r.Frames = len(a.frames)
+	return os.WriteFile(output, []byte(b.String()), 0644)
+}
+
+// ExtractFeatures decodes a recording and writes, next to it, audio features every
+// 10ms (RMS, peak, spectral centroid, pitch and voice activity) to file.audio.csv and video
+// frame statistics (timestamps, dropped frames, resolution) to file.video.csv
+func ExtractFeatures(file string, o types.FeatureOptions) (r types.FeatureReport, err error) {
+	r.File = file
+	v := VerifyFile(file)
+	if !v.Valid {
+		return r, errors.New(v.Error)
+	}
+	hasAudio, hasVideo := false, false
+	for _, s := range v.Streams {
+		if strings.HasPrefix(s.Codec, "audio/") {
+			hasAudio = true
+		} else if strings.HasPrefix(s.Codec, "video/") {
+			hasVideo = true
+		}
+	}
+
+	interval := strconv.FormatInt(featuresInterval.Nanoseconds(), 10)
+	var b strings.Builder
+	b.WriteString("filesrc location=" + quoteLocation(file) + " ! decodebin name=decoder\n")
+	if hasAudio {
+		b.WriteString(fmt.Sprintf("decoder. ! audioconvert ! audioresample ! audio/x-raw,rate=%v,channels=1 ! tee name=audio_tee ! queue ! ", featuresRate))
+		b.WriteString("level interval=" + interval + " post-messages=true ! ")
+		b.WriteString(fmt.Sprintf("spectrum interval=%v bands=%v threshold=%v post-messages=true message-magnitude=true ! ", interval, spectrumBands, spectrumFloor))
+		b.WriteString("fakesink sync=false\n")
+		b.WriteString(fmt.Sprintf("audio_tee. ! queue ! audioconvert ! audioresample ! audio/x-raw,format=F32LE,rate=%v,channels=1 ! fakesink name=audio_sink sync=false\n", pitchRate))
+	}
+	if hasVideo {
+		b.WriteString("decoder. ! videoconvert ! queue ! fakesink name=video_sink sync=false\n")
+	}
+
+	a, err := runAnalysis(b.String(), 30*time.Minute)
+	if err != nil {
+		return r, err
+	}
+	if hasAudio {
+		r.AudioCSV = file + ".audio.csv"
+		if r.VoiceRatio, err = writeAudioFeatures(a, r.AudioCSV, o); err != nil {
+			return r, err
+		}
+	}
+	if hasVideo {
+		r.VideoCSV = file + ".video.csv"
+		if err = writeVideoStats(a, r.VideoCSV, &r); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,150 @@
+package gst
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func sine(frequency float64, rate, count int) []float32 {
+	samples := make([]float32, count)
+	for i := range samples {
+		samples[i] = float32(0.5 * math.Sin(2*math.Pi*frequency*float64(i)/float64(rate)))
+	}
+	return samples
+}
+
+func readLines(t *testing.T, path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestSpectralCentroid(t *testing.T) {
+	bandWidth := float64(featuresRate) / 2 / 4
+	tests := []struct {
+		name       string
+		magnitudes []float32
+		want       float64
+	}{
+		{"Silence", []float32{-90, -90, -90, -90}, 0},
+		{"Single band", []float32{-90, 0, -90, -90}, 1.5 * bandWidth},
+		{"Two equal bands", []float32{0, -90, -90, 0}, 2 * bandWidth},
+		// band 0 is 10 times more powerful than band 2
+		{"Weighted by power", []float32{0, -90, -10, -90}, (0.5*10 + 2.5) / 11 * bandWidth},
+	}
+	for _, tt := range tests {
+		if got := spectralCentroid(tt.magnitudes); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEstimatePitch(t *testing.T) {
+	for _, frequency := range []float64{80, 150, 220, 440} {
+		got := estimatePitch(sine(frequency, pitchRate, pitchWindow), pitchRate)
+		if math.Abs(got-frequency) > frequency/100 {
+			t.Errorf("got %v Hz for a %v Hz sine", got, frequency)
+		}
+	}
+	if got := estimatePitch(make([]float32, pitchWindow), pitchRate); got != 0 {
+		t.Errorf("got %v Hz for silence", got)
+	}
+	if got := estimatePitch(sine(200, pitchRate, 100), pitchRate); got != 0 {
+		t.Errorf("got %v Hz for a too short window", got)
+	}
+}
+
+func TestTrackPitch(t *testing.T) {
+	a := &analysis{}
+	samples := sine(200, pitchRate, pitchWindow+3*pitchRate/100)
+	// split in buffers of 7ms
+	size := 7 * pitchRate / 1000
+	for i := 0; i < len(samples); i += size {
+		end := i + size
+		if end > len(samples) {
+			end = len(samples)
+		}
+		a.trackPitch(time.Second+time.Duration(i)*time.Second/pitchRate, samples[i:end])
+	}
+	if len(a.pitches) != 4 {
+		t.Fatalf("got %v pitches, want 4", len(a.pitches))
+	}
+	for i, p := range a.pitches {
+		if p.at != time.Second+time.Duration(i)*featuresInterval || math.Abs(p.value-200) > 2 {
+			t.Errorf("unexpected pitch %+v at index %v", p, i)
+		}
+	}
+}
+
+func TestWriteAudioFeatures(t *testing.T) {
+	a := &analysis{
+		levels: []levelSample{
+			{at: 0, rms: -60, peak: -50},
+			{at: 10 * time.Millisecond, rms: -20, peak: -10},
+			{at: 20 * time.Millisecond, rms: -70, peak: -60},
+			{at: 30 * time.Millisecond, rms: -70, peak: -60},
+		},
+		// not aligned with levels
+		centroids: []timedValue{{2 * time.Millisecond, 100}, {13 * time.Millisecond, 200}, {19 * time.Millisecond, 300}},
+		pitches:   []timedValue{{0, 110}, {10 * time.Millisecond, 120}, {20 * time.Millisecond, 130}},
+	}
+	output := filepath.Join(t.TempDir(), "audio.csv")
+	ratio, err := writeAudioFeatures(a, output, types.FeatureOptions{VADThreshold: -45, VADHangover: 15 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"time_ms,rms_db,peak_db,spectral_centroid_hz,pitch_hz,voice",
+		"0.000,-60.00,-50.00,100.0,0.0,0",
+		"10.000,-20.00,-10.00,200.0,120.0,1",
+		// hangover
+		"20.000,-70.00,-60.00,300.0,130.0,1",
+		// no centroid within 10ms
+		"30.000,-70.00,-60.00,0.0,0.0,0",
+	}
+	if got := readLines(t, output); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%v\nwant:\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if ratio != 0.5 {
+		t.Errorf("got voice ratio %v, want 0.5", ratio)
+	}
+}
+
+func TestWriteVideoStats(t *testing.T) {
+	frameAt := func(i int) time.Duration { return time.Duration(i) * 40 * time.Millisecond }
+	a := &analysis{}
+	for _, i := range []int{0, 1, 2, 5, 6, 7} {
+		width := 640
+		if i >= 6 {
+			width = 320
+		}
+		a.frames = append(a.frames, videoFrame{frameAt(i), width, 480})
+	}
+	output := filepath.Join(t.TempDir(), "video.csv")
+	r := types.FeatureReport{}
+	if err := writeVideoStats(a, output, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Frames != 6 || r.DroppedFrames != 2 || r.ResolutionChanges != 1 || r.Framerate != 25 {
+		t.Errorf("unexpected report %+v", r)
+	}
+	lines := readLines(t, output)
+	if len(lines) != 7 || lines[4] != "3,200.000,120.000,640,480,2" || lines[5] != "4,240.000,40.000,320,480,0" {
+		t.Errorf("unexpected stats:\n%v", strings.Join(lines, "\n"))
+	}
+
+	t.Run("Without frames", func(t *testing.T) {
+		r := types.FeatureReport{}
+		if err := writeVideoStats(&analysis{}, output, &r); err != nil || r.Frames != 0 || r.Framerate != 0 {
+			t.Errorf("unexpected report %+v (%v)", r, err)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package gst
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+const fxNamePrefix = "client_"
+
+var (
+	// only match name property, not for instance filename or device-name
+	rawNameRegexp   = regexp.MustCompile(`(^|\s)name=`)
+	safeValueRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.:/+-]+$`)
+)
+
+func formatFxValue(value any) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		if safeValueRegexp.MatchString(v) {
+			return v
+		}
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func renderFxElement(el types.FxElement) string {
+	if len(el.Raw) > 0 {
+		return rawNameRegexp.ReplaceAllString(el.Raw, "${1}name="+fxNamePrefix)
+	}
+
+	parts := []string{el.Element}
+	if len(el.Name) > 0 {
+		parts = append(parts, "name="+fxNamePrefix+el.Name)
+	}
+	// sorted for a reproducible pipeline definition
+	props := make([]string, 0, len(el.Properties))
+	for prop := range el.Properties {
+		props = append(props, prop)
+	}
+	sort.Strings(props)
+	for _, prop := range props {
+		parts = append(parts, prop+"="+formatFxValue(el.Properties[prop]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// renders an effect chain as a GStreamer description, prefixing element names
+// (so that they don't clash with other pipeline elements) and linking consecutive
+// effects with a converter (which is passthrough when caps are already compatible)
+func renderFx(fx types.Fx, converter string) string {
+	rendered := []string{}
+	for _, el := range fx {
+		rendered = append(rendered, renderFxElement(el))
+	}
+	return strings.Join(rendered, " ! "+converter+" ! ")
+}
+
+// mozza needs to know which user it is processing (for logging purposes)
+func withMozzaUserId(fx types.Fx, userId string) types.Fx {
+	output := make(types.Fx, 0, len(fx))
+	for _, el := range fx {
+		if len(el.Raw) > 0 {
+			if strings.Contains(el.Raw, "mozza") {
+				el.Raw += " user-id=" + userId
+			}
+		} else if el.Element == "mozza" {
+			props := map[string]any{"user-id": userId}
+			for k, v := range el.Properties {
+				props[k] = v
+			}
+			el.Properties = props
+		}
+		output = append(output, el)
+	}
+	return output
+}
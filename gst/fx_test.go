@@ -0,0 +1,52 @@
+package gst
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func TestRenderFx(t *testing.T) {
+
+	assertRender := func(t testing.TB, rawJSON, converter, expected string) {
+		t.Helper()
+		var fx types.Fx
+		if err := json.Unmarshal([]byte(rawJSON), &fx); err != nil {
+			t.Fatalf("unmarshal failed: %v", err)
+		}
+		if got := renderFx(fx, converter); got != expected {
+			t.Errorf("got %q but expected %q", got, expected)
+		}
+	}
+
+	t.Run("Legacy string", func(t *testing.T) {
+		assertRender(t, `"pitch pitch=0.8 name=fx"`, "audioconvert", "pitch pitch=0.8 name=client_fx")
+	})
+
+	t.Run("Legacy string does not rename other properties", func(t *testing.T) {
+		assertRender(t, `"fx filename=a.txt name=fx"`, "audioconvert", "fx filename=a.txt name=client_fx")
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		assertRender(t, `""`, "audioconvert", "")
+		assertRender(t, `null`, "audioconvert", "")
+		assertRender(t, `[]`, "audioconvert", "")
+	})
+
+	t.Run("Chain", func(t *testing.T) {
+		assertRender(t,
+			`[{"element": "pitch", "name": "p", "properties": {"pitch": 0.8, "tempo": 1}}, {"element": "audioecho", "name": "e"}]`,
+			"audioconvert",
+			"pitch name=client_p pitch=0.8 tempo=1 ! audioconvert ! audioecho name=client_e",
+		)
+	})
+
+	t.Run("Quoted string values", func(t *testing.T) {
+		assertRender(t,
+			`[{"element": "textoverlay", "properties": {"text": "hello world", "shaded-background": true}}]`,
+			"videoconvert",
+			`textoverlay shaded-background=true text="hello world"`,
+		)
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"regexp"
 	"strconv"
+	"time"
 	"unsafe"
 
 	"github.com/ducksouplab/ducksoup/env"
@@ -86,12 +87,29 @@ func goRequestKeyFrame(cId *C.char) {
 	}
 }
 
+//export goStreamFirstBuffer
+func goStreamFirstBuffer(cId, cFilesink, cKind *C.char, pts, runningTime, baseTime, clockTime C.guint64, wallTime C.gint64) {
+	id := C.GoString(cId)
+	p, ok := pipelineStoreSingleton.find(id)
+
+	if ok {
+		p.setStreamFirstBuffer(C.GoString(cFilesink), types.StreamTiming{
+			Kind:        C.GoString(cKind),
+			PTS:         int64(pts),
+			RunningTime: int64(runningTime),
+			BaseTime:    int64(baseTime),
+			ClockTime:   int64(clockTime),
+		}, time.UnixMicro(int64(wallTime)))
+	}
+}
+
 //export goBusLog
 func goBusLog(cId, cMsg, cEl *C.char) {
 	id := C.GoString(cId)
 	msg := C.GoString(cMsg)
 	el := C.GoString(cEl)
 	p, found := pipelineStoreSingleton.find(id)
+	countError(el)
 
 	if found {
 		p.logger.Error().Err(errors.New(msg)).Str("element", el).Msg("gstreamer_error")
@@ -122,6 +140,7 @@ func goDebugLog(cLevel C.int, cFile, cFunction *C.char, line C.int, cMsg *C.char
 					if len(trackingMatch) > 0 {
 						tracking = true
 					}
+					setTracking(match[1], tracking)
 					logger.Warn().
 						Str("context", "gstreamer").
 						Int("GST_LEVEL", level).
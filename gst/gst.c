@@ -1,8 +1,11 @@
 #include <stdio.h>
+#include <string.h>
 #include <time.h>
 #include <gst/app/gstappsrc.h>
 #include <gst/app/gstappsink.h>
 #include <gst/video/video-event.h>
+#include <gst/controller/gstinterpolationcontrolsource.h>
+#include <gst/controller/gstdirectcontrolbinding.h>
 
 #include "gst.h"
 
@@ -160,6 +163,23 @@ void gstStartMainLoop(gboolean interceptLogs)
     g_main_loop_run(gstreamer_main_loop);
 }
 
+gboolean gstMainLoopIsRunning()
+{
+    return gstreamer_main_loop != NULL && g_main_loop_is_running(gstreamer_main_loop);
+}
+
+gboolean gstElementExists(char *name)
+{
+    gst_init(NULL, NULL);
+
+    GstElementFactory *factory = gst_element_factory_find(name);
+    if(factory == NULL) {
+        return FALSE;
+    }
+    gst_object_unref(factory);
+    return TRUE;
+}
+
 GstElement *gstParsePipeline(char *pipelineStr, char *id)
 {    
     gst_init(NULL, NULL);
@@ -206,6 +226,110 @@ void gstStartPipeline(GstElement *pipeline, gboolean audioOnly)
 
 }
 
+typedef struct {
+    GstElement *pipeline;
+    char *filesink;
+} StreamWatch;
+
+static void free_stream_watch(gpointer data)
+{
+    StreamWatch *watch = (StreamWatch*) data;
+    g_free(watch->filesink);
+    g_free(watch);
+}
+
+// the first buffer reaching a muxer sink pad is located on the pipeline clock: its
+// running time (from its PTS and segment), the pipeline base time, and the pipeline
+// clock time sampled together with the system wall clock
+static GstPadProbeReturn stream_first_buffer_probe(GstPad *pad, GstPadProbeInfo *info, gpointer data)
+{
+    StreamWatch *watch = (StreamWatch*) data;
+    GstBuffer *buffer = GST_PAD_PROBE_INFO_BUFFER(info);
+    GstClockTime pts = GST_BUFFER_PTS(buffer);
+    if (!GST_CLOCK_TIME_IS_VALID(pts)) {
+        // wait for a timestamped buffer
+        return GST_PAD_PROBE_OK;
+    }
+
+    GstClockTime runningTime = GST_CLOCK_TIME_NONE;
+    GstEvent *segmentEvent = gst_pad_get_sticky_event(pad, GST_EVENT_SEGMENT, 0);
+    if (segmentEvent != NULL) {
+        const GstSegment *segment;
+        gst_event_parse_segment(segmentEvent, &segment);
+        runningTime = gst_segment_to_running_time(segment, GST_FORMAT_TIME, pts);
+        gst_event_unref(segmentEvent);
+    }
+    GstClock *clock = gst_element_get_clock(watch->pipeline);
+    if (clock == NULL || !GST_CLOCK_TIME_IS_VALID(runningTime)) {
+        if (clock != NULL) gst_object_unref(clock);
+        return GST_PAD_PROBE_OK;
+    }
+    GstClockTime clockTime = gst_clock_get_time(clock);
+    gint64 wallTime = g_get_real_time();
+    gst_object_unref(clock);
+    GstClockTime baseTime = gst_element_get_base_time(watch->pipeline);
+
+    // "audio" or "video" from caps name
+    char kind[6] = "";
+    GstCaps *caps = gst_pad_get_current_caps(pad);
+    if (caps != NULL) {
+        const gchar *capsName = gst_structure_get_name(gst_caps_get_structure(caps, 0));
+        if (g_str_has_prefix(capsName, "audio/")) strcpy(kind, "audio");
+        else if (g_str_has_prefix(capsName, "video/")) strcpy(kind, "video");
+        gst_caps_unref(caps);
+    }
+
+    char *id = gst_element_get_name(watch->pipeline);
+    goStreamFirstBuffer(id, watch->filesink, kind, pts, runningTime, baseTime, clockTime, wallTime);
+    g_free(id);
+    // only the first buffer is needed
+    return GST_PAD_PROBE_REMOVE;
+}
+
+static gboolean watch_sink_pad(GstElement *el, GstPad *pad, gpointer data)
+{
+    StreamWatch *source = (StreamWatch*) data;
+    StreamWatch *watch = g_new0(StreamWatch, 1);
+    watch->pipeline = source->pipeline;
+    watch->filesink = g_strdup(source->filesink);
+    gst_pad_add_probe(pad, GST_PAD_PROBE_TYPE_BUFFER, stream_first_buffer_probe, watch, free_stream_watch);
+    return TRUE;
+}
+
+// watches the sink pads of the element (muxer or encoder) writing to the given filesink
+void gstWatchStreams(GstElement *pipeline, char *filesink)
+{
+    GstElement *el = gst_bin_get_by_name(GST_BIN(pipeline), filesink);
+    if (el == NULL) return;
+
+    GstPad *sinkPad = gst_element_get_static_pad(el, "sink");
+    GstPad *peer = gst_pad_get_peer(sinkPad);
+    if (peer != NULL) {
+        GstElement *writer = gst_pad_get_parent_element(peer);
+        if (writer != NULL) {
+            StreamWatch source = { pipeline, filesink };
+            gst_element_foreach_sink_pad(writer, watch_sink_pad, &source);
+            gst_object_unref(writer);
+        }
+        gst_object_unref(peer);
+    }
+    gst_object_unref(sinkPad);
+    gst_object_unref(el);
+}
+
+// running time of the pipeline, GST_CLOCK_TIME_NONE if it has no clock yet
+GstClockTime gstRunningTime(GstElement *pipeline)
+{
+    GstClock *clock = gst_element_get_clock(pipeline);
+    if (clock == NULL) return GST_CLOCK_TIME_NONE;
+
+    GstClockTime now = gst_clock_get_time(clock);
+    gst_object_unref(clock);
+    GstClockTime baseTime = gst_element_get_base_time(pipeline);
+    if (now < baseTime) return GST_CLOCK_TIME_NONE;
+    return now - baseTime;
+}
+
 void gstStopPipeline(GstElement *pipeline)
 {
     // query GstStateChangeReturn within 0.1s, if GST_STATE_CHANGE_ASYNC, sending an EOS will fail main loop
@@ -242,6 +366,557 @@ void gstSendPLI(GstElement *pipeline)
     gst_element_send_event(pipeline, gst_video_event_new_upstream_force_key_unit(GST_CLOCK_TIME_NONE, TRUE, 0));
 }
 
+// runs a (non live) pipeline till EOS (or error or timeout) without relying on the main loop,
+// then releases it. Returns NULL on success or an error message to be freed by the caller (g_strdup
+// relies on malloc since GLib 2.46, so it can be freed from Go)
+static char *run_pipeline_till_eos(GstElement *pipeline, int timeoutSeconds)
+{
+    gst_element_set_state(pipeline, GST_STATE_PLAYING);
+
+    GstBus *bus = gst_element_get_bus(pipeline);
+    GstMessage *msg = gst_bus_timed_pop_filtered(bus, timeoutSeconds * GST_SECOND, GST_MESSAGE_ERROR | GST_MESSAGE_EOS);
+    char *result = NULL;
+    if (msg == NULL) {
+        result = g_strdup("timeout");
+    } else {
+        if (GST_MESSAGE_TYPE(msg) == GST_MESSAGE_ERROR) {
+            GError *msgError;
+            gst_message_parse_error(msg, &msgError, NULL);
+            result = g_strdup(msgError->message);
+            g_error_free(msgError);
+        }
+        gst_message_unref(msg);
+    }
+
+    gst_object_unref(bus);
+    gst_element_set_state(pipeline, GST_STATE_NULL);
+    gst_object_unref(pipeline);
+
+    return result;
+}
+
+char *gstRunPipeline(char *pipelineStr, int timeoutSeconds)
+{
+    gst_init(NULL, NULL);
+
+    GError *error = NULL;
+    GstElement *pipeline = gst_parse_launch(pipelineStr, &error);
+    if (error != NULL) {
+        char *result = g_strdup(error->message);
+        g_error_free(error);
+        if (pipeline != NULL) {
+            gst_object_unref(pipeline);
+        }
+        return result;
+    }
+
+    return run_pipeline_till_eos(pipeline, timeoutSeconds);
+}
+
+// controlled offline pipelines: property changes are bound to stream time
+
+// returns the control source (to be unreffed) bound to prop, creating it if needed
+// with the current value of the property as a first control point
+static GstControlSource *control_source_for(GstElement *el, char *prop)
+{
+    GstControlBinding *binding = gst_object_get_control_binding(GST_OBJECT(el), prop);
+    if (binding != NULL) {
+        GstControlSource *cs = NULL;
+        g_object_get(binding, "control-source", &cs, NULL);
+        gst_object_unref(binding);
+        return cs;
+    }
+
+    GParamSpec *spec = g_object_class_find_property(G_OBJECT_GET_CLASS(el), prop);
+    if (spec == NULL) {
+        return NULL;
+    }
+    GstControlSource *cs = gst_interpolation_control_source_new();
+    g_object_set(cs, "mode", GST_INTERPOLATION_MODE_LINEAR, NULL);
+    gst_object_add_control_binding(GST_OBJECT(el), gst_direct_control_binding_new_absolute(GST_OBJECT(el), prop, cs));
+
+    GValue current = G_VALUE_INIT;
+    GValue asDouble = G_VALUE_INIT;
+    g_value_init(&current, spec->value_type);
+    g_value_init(&asDouble, G_TYPE_DOUBLE);
+    g_object_get_property(G_OBJECT(el), prop, &current);
+    if (g_value_transform(&current, &asDouble)) {
+        gst_timed_value_control_source_set(GST_TIMED_VALUE_CONTROL_SOURCE(cs), 0, g_value_get_double(&asDouble));
+    }
+    g_value_unset(&current);
+    g_value_unset(&asDouble);
+
+    return cs;
+}
+
+// events are expected in time order. Like live controls, a new event interrupts
+// a running interpolation (duration > 0) at its current value
+static void add_control_event(GstElement *pipeline, char *elName, char *prop, guint64 time, guint64 duration, gdouble value)
+{
+    GstElement *el = gst_bin_get_by_name(GST_BIN(pipeline), elName);
+    if (el == NULL) {
+        return;
+    }
+    GstControlSource *cs = control_source_for(el, prop);
+    gst_object_unref(el);
+    if (cs == NULL) {
+        return;
+    }
+    GstTimedValueControlSource *tvcs = GST_TIMED_VALUE_CONTROL_SOURCE(cs);
+
+    // instant changes are kept steep by holding the previous value till just before
+    GstClockTime hold = time;
+    if (duration == 0) {
+        hold = time >= GST_USECOND ? time - GST_USECOND : 0;
+    }
+    gdouble current;
+    if (!gst_control_source_get_value(cs, hold, &current)) {
+        current = value;
+    }
+    GList *points = gst_timed_value_control_source_get_all(tvcs);
+    for (GList *p = points; p != NULL; p = p->next) {
+        GstClockTime timestamp = ((GstTimedValue*) p->data)->timestamp;
+        if (timestamp >= hold) {
+            gst_timed_value_control_source_unset(tvcs, timestamp);
+        }
+    }
+    g_list_free(points);
+
+    gst_timed_value_control_source_set(tvcs, hold, current);
+    gst_timed_value_control_source_set(tvcs, time + duration, value);
+    gst_object_unref(cs);
+}
+
+// controls has one event per line: "element;property;time_ns;duration_ns;value"
+char *gstRunControlledPipeline(char *pipelineStr, int timeoutSeconds, char *controls)
+{
+    gst_init(NULL, NULL);
+
+    GError *error = NULL;
+    GstElement *pipeline = gst_parse_launch(pipelineStr, &error);
+    if (error != NULL) {
+        char *result = g_strdup(error->message);
+        g_error_free(error);
+        if (pipeline != NULL) {
+            gst_object_unref(pipeline);
+        }
+        return result;
+    }
+
+    char *saveptr = NULL;
+    for (char *line = strtok_r(controls, "\n", &saveptr); line != NULL; line = strtok_r(NULL, "\n", &saveptr)) {
+        char elName[128], prop[128];
+        unsigned long long time, duration;
+        double value;
+        if (sscanf(line, "%127[^;];%127[^;];%llu;%llu;%lf", elName, prop, &time, &duration, &value) == 5) {
+            add_control_event(pipeline, elName, prop, time, duration, value);
+        }
+    }
+
+    return run_pipeline_till_eos(pipeline, timeoutSeconds);
+}
+
+// file inspection (parsed but not decoded)
+
+#define INSPECT_MAX_STREAMS 8
+
+typedef struct {
+    char capsName[64];
+    gint width;
+    gint height;
+    GstClockTime first;
+    GstClockTime last;
+} InspectedStream;
+
+typedef struct {
+    GstElement *pipeline;
+    GMutex mutex;
+    int count;
+    InspectedStream streams[INSPECT_MAX_STREAMS];
+} InspectedFile;
+
+static GstPadProbeReturn inspect_buffer_probe(GstPad *pad, GstPadProbeInfo *info, gpointer data)
+{
+    InspectedStream *stream = (InspectedStream*) data;
+    GstBuffer *buffer = gst_pad_probe_info_get_buffer(info);
+    GstClockTime pts = GST_BUFFER_PTS(buffer);
+
+    if (GST_CLOCK_TIME_IS_VALID(pts)) {
+        GstClockTime end = pts;
+        if (GST_CLOCK_TIME_IS_VALID(GST_BUFFER_DURATION(buffer))) {
+            end += GST_BUFFER_DURATION(buffer);
+        }
+        if (!GST_CLOCK_TIME_IS_VALID(stream->first) || pts < stream->first) {
+            stream->first = pts;
+        }
+        if (!GST_CLOCK_TIME_IS_VALID(stream->last) || end > stream->last) {
+            stream->last = end;
+        }
+    }
+    return GST_PAD_PROBE_OK;
+}
+
+static void inspect_pad_added(GstElement *parser, GstPad *pad, gpointer data)
+{
+    InspectedFile *file = (InspectedFile*) data;
+    InspectedStream *stream = NULL;
+
+    g_mutex_lock(&file->mutex);
+    if (file->count < INSPECT_MAX_STREAMS) {
+        stream = &file->streams[file->count];
+        file->count++;
+    }
+    g_mutex_unlock(&file->mutex);
+
+    if (stream != NULL) {
+        GstCaps *caps = gst_pad_get_current_caps(pad);
+        if (caps != NULL) {
+            GstStructure *structure = gst_caps_get_structure(caps, 0);
+            g_strlcpy(stream->capsName, gst_structure_get_name(structure), sizeof(stream->capsName));
+            gst_structure_get_int(structure, "width", &stream->width);
+            gst_structure_get_int(structure, "height", &stream->height);
+            gst_caps_unref(caps);
+        }
+        gst_pad_add_probe(pad, GST_PAD_PROBE_TYPE_BUFFER, inspect_buffer_probe, stream, NULL);
+    }
+
+    // consume stream
+    GstElement *sink = gst_element_factory_make("fakesink", NULL);
+    g_object_set(sink, "sync", FALSE, NULL);
+    gst_bin_add(GST_BIN(file->pipeline), sink);
+    gst_element_sync_state_with_parent(sink);
+    GstPad *sinkPad = gst_element_get_static_pad(sink, "sink");
+    gst_pad_link(pad, sinkPad);
+    gst_object_unref(sinkPad);
+}
+
+// writes to report one "capsName;width;height;firstPTS;lastPTS" line per stream
+// (PTS being -1 if no timestamped buffer has been found). Returns NULL on success
+// or an error message to be freed by the caller
+char *gstInspectFile(char *location, int timeoutSeconds, char *report, int reportLen)
+{
+    gst_init(NULL, NULL);
+
+    GstElement *pipeline = gst_pipeline_new(NULL);
+    GstElement *src = gst_element_factory_make("filesrc", NULL);
+    GstElement *parser = gst_element_factory_make("parsebin", NULL);
+    if (src == NULL || parser == NULL) {
+        gst_object_unref(pipeline);
+        return g_strdup("missing filesrc or parsebin element");
+    }
+    g_object_set(src, "location", location, NULL);
+    gst_bin_add_many(GST_BIN(pipeline), src, parser, NULL);
+    gst_element_link(src, parser);
+
+    InspectedFile file;
+    file.pipeline = pipeline;
+    file.count = 0;
+    g_mutex_init(&file.mutex);
+    for (int i = 0; i < INSPECT_MAX_STREAMS; i++) {
+        file.streams[i].capsName[0] = '\0';
+        file.streams[i].width = 0;
+        file.streams[i].height = 0;
+        file.streams[i].first = GST_CLOCK_TIME_NONE;
+        file.streams[i].last = GST_CLOCK_TIME_NONE;
+    }
+    g_signal_connect(parser, "pad-added", G_CALLBACK(inspect_pad_added), &file);
+
+    char *result = run_pipeline_till_eos(pipeline, timeoutSeconds);
+
+    int written = 0;
+    report[0] = '\0';
+    for (int i = 0; i < file.count && written < reportLen; i++) {
+        InspectedStream *stream = &file.streams[i];
+        long long first = GST_CLOCK_TIME_IS_VALID(stream->first) ? (long long) stream->first : -1;
+        long long last = GST_CLOCK_TIME_IS_VALID(stream->last) ? (long long) stream->last : -1;
+        written += snprintf(report + written, reportLen - written, "%s;%d;%d;%lld;%lld\n",
+            stream->capsName, stream->width, stream->height, first, last);
+    }
+    g_mutex_clear(&file.mutex);
+
+    return result;
+}
+
+
+// offline feature extraction: level and spectrum messages and video frames are
+// forwarded to Go, id being the key of the analysis on the Go side
+
+static gdouble first_channel_value(const GstStructure *s, const char *field)
+{
+    const GValue *list = gst_structure_get_value(s, field);
+    if (list == NULL) {
+        return 0;
+    }
+    GValueArray *array = (GValueArray*) g_value_get_boxed(list);
+    if (array == NULL || array->n_values == 0) {
+        return 0;
+    }
+    return g_value_get_double(g_value_array_get_nth(array, 0));
+}
+
+static void analysis_element_message(char *id, GstMessage *msg)
+{
+    const GstStructure *s = gst_message_get_structure(msg);
+    if (s == NULL) {
+        return;
+    }
+    GstClockTime timestamp = GST_CLOCK_TIME_NONE;
+    if (!gst_structure_get_clock_time(s, "stream-time", &timestamp) || !GST_CLOCK_TIME_IS_VALID(timestamp)) {
+        return;
+    }
+
+    if (gst_structure_has_name(s, "level")) {
+        goAnalysisLevel(id, timestamp, first_channel_value(s, "rms"), first_channel_value(s, "peak"));
+    } else if (gst_structure_has_name(s, "spectrum")) {
+        const GValue *magnitudes = gst_structure_get_value(s, "magnitude");
+        if (magnitudes == NULL) {
+            return;
+        }
+        guint size = gst_value_list_get_size(magnitudes);
+        float *values = malloc(size * sizeof(float));
+        for (guint i = 0; i < size; i++) {
+            values[i] = g_value_get_float(gst_value_list_get_value(magnitudes, i));
+        }
+        goAnalysisSpectrum(id, timestamp, values, size);
+        free(values);
+    }
+}
+
+static GstPadProbeReturn analysis_video_probe(GstPad *pad, GstPadProbeInfo *info, gpointer data)
+{
+    char *id = (char*) data;
+
+    if (GST_PAD_PROBE_INFO_TYPE(info) & GST_PAD_PROBE_TYPE_BUFFER) {
+        GstBuffer *buffer = gst_pad_probe_info_get_buffer(info);
+        if (GST_CLOCK_TIME_IS_VALID(GST_BUFFER_PTS(buffer))) {
+            goAnalysisFrame(id, GST_BUFFER_PTS(buffer));
+        }
+    } else {
+        GstEvent *event = gst_pad_probe_info_get_event(info);
+        if (GST_EVENT_TYPE(event) == GST_EVENT_CAPS) {
+            GstCaps *caps;
+            gint width = 0, height = 0;
+            gst_event_parse_caps(event, &caps);
+            GstStructure *structure = gst_caps_get_structure(caps, 0);
+            gst_structure_get_int(structure, "width", &width);
+            gst_structure_get_int(structure, "height", &height);
+            goAnalysisResolution(id, width, height);
+        }
+    }
+    return GST_PAD_PROBE_OK;
+}
+
+// forwards F32 mono samples (see pitch tracking in features.go)
+static GstPadProbeReturn analysis_audio_probe(GstPad *pad, GstPadProbeInfo *info, gpointer data)
+{
+    char *id = (char*) data;
+    GstBuffer *buffer = gst_pad_probe_info_get_buffer(info);
+    GstMapInfo map;
+
+    if (!GST_CLOCK_TIME_IS_VALID(GST_BUFFER_PTS(buffer)) || !gst_buffer_map(buffer, &map, GST_MAP_READ)) {
+        return GST_PAD_PROBE_OK;
+    }
+    goAnalysisSamples(id, GST_BUFFER_PTS(buffer), (float*) map.data, map.size / sizeof(float));
+    gst_buffer_unmap(buffer, &map);
+    return GST_PAD_PROBE_OK;
+}
+
+// runs pipelineStr till EOS (or error or timeout), forwarding messages of level and
+// spectrum elements and probing the sink pads of the elements named audio_sink and
+// video_sink (if any).
+// Returns NULL on success or an error message to be freed by the caller
+char *gstRunAnalysis(char *pipelineStr, int timeoutSeconds, char *id)
+{
+    gst_init(NULL, NULL);
+
+    GError *error = NULL;
+    GstElement *pipeline = gst_parse_launch(pipelineStr, &error);
+    if (error != NULL) {
+        char *result = g_strdup(error->message);
+        g_error_free(error);
+        if (pipeline != NULL) {
+            gst_object_unref(pipeline);
+        }
+        return result;
+    }
+
+    GstElement *videoSink = gst_bin_get_by_name(GST_BIN(pipeline), "video_sink");
+    if (videoSink != NULL) {
+        GstPad *pad = gst_element_get_static_pad(videoSink, "sink");
+        gst_pad_add_probe(pad, GST_PAD_PROBE_TYPE_BUFFER | GST_PAD_PROBE_TYPE_EVENT_DOWNSTREAM, analysis_video_probe, id, NULL);
+        gst_object_unref(pad);
+        gst_object_unref(videoSink);
+    }
+
+    GstElement *audioSink = gst_bin_get_by_name(GST_BIN(pipeline), "audio_sink");
+    if (audioSink != NULL) {
+        GstPad *pad = gst_element_get_static_pad(audioSink, "sink");
+        gst_pad_add_probe(pad, GST_PAD_PROBE_TYPE_BUFFER, analysis_audio_probe, id, NULL);
+        gst_object_unref(pad);
+        gst_object_unref(audioSink);
+    }
+
+    gst_element_set_state(pipeline, GST_STATE_PLAYING);
+
+    GstBus *bus = gst_element_get_bus(pipeline);
+    gint64 deadline = g_get_monotonic_time() + (gint64) timeoutSeconds * G_USEC_PER_SEC;
+    char *result = NULL;
+    gboolean done = FALSE;
+    while (!done) {
+        gint64 remaining = deadline - g_get_monotonic_time();
+        GstMessage *msg = NULL;
+        if (remaining > 0) {
+            msg = gst_bus_timed_pop_filtered(bus, remaining * GST_USECOND, GST_MESSAGE_ERROR | GST_MESSAGE_EOS | GST_MESSAGE_ELEMENT);
+        }
+        if (msg == NULL) {
+            result = g_strdup("timeout");
+            break;
+        }
+        switch (GST_MESSAGE_TYPE(msg)) {
+        case GST_MESSAGE_ERROR: {
+            GError *msgError;
+            gst_message_parse_error(msg, &msgError, NULL);
+            result = g_strdup(msgError->message);
+            g_error_free(msgError);
+            done = TRUE;
+            break;
+        }
+        case GST_MESSAGE_EOS:
+            done = TRUE;
+            break;
+        default:
+            analysis_element_message(id, msg);
+            break;
+        }
+        gst_message_unref(msg);
+    }
+
+    gst_object_unref(bus);
+    gst_element_set_state(pipeline, GST_STATE_NULL);
+    gst_object_unref(pipeline);
+
+    return result;
+}
+
+// players read a media file and forward encoded samples to Go, the pipeline name
+// being used as id. They may be paused, resumed and rewound, and are only released
+// by gstStopPlayer (EOS is forwarded to Go, which may rewind)
+
+static gboolean player_bus_callback(GstBus *bus, GstMessage *msg, gpointer data)
+{
+    GstElement *pipeline = (GstElement*) data;
+    char *id = gst_element_get_name(pipeline);
+
+    switch (GST_MESSAGE_TYPE(msg))
+    {
+    case GST_MESSAGE_EOS:
+        goPlayerEOS(id);
+        break;
+    case GST_MESSAGE_ERROR:
+    {
+        GError *error;
+        gst_message_parse_error(msg, &error, NULL);
+        goPlayerError(id, error->message, GST_OBJECT_NAME(msg->src));
+        g_error_free(error);
+        break;
+    }
+    default:
+        break;
+    }
+
+    g_free(id);
+    return TRUE;
+}
+
+// preroll samples (when the player is paused) are forwarded too, so that receivers get
+// a first frame before the player starts
+static GstFlowReturn player_sink_pull(GstElement *sink, GstElement *pipeline, char *kind, gboolean preroll)
+{
+    char *id = gst_element_get_name(pipeline);
+
+    GstSample *sample = preroll ? gst_app_sink_pull_preroll((GstAppSink*) sink) : gst_app_sink_pull_sample((GstAppSink*) sink);
+    if (sample)
+    {
+        GstBuffer *buffer = gst_sample_get_buffer(sample);
+        if (buffer)
+        {
+            gint64 duration = GST_BUFFER_DURATION_IS_VALID(buffer) ? (gint64) GST_BUFFER_DURATION(buffer) : -1;
+            GstMapInfo map;
+            gst_buffer_map(buffer, &map, GST_MAP_READ);
+            goPlayerSample(id, kind, map.data, map.size, duration, preroll);
+            gst_buffer_unmap(buffer, &map);
+        }
+        gst_sample_unref(sample);
+    }
+
+    g_free(id);
+    return GST_FLOW_OK;
+}
+
+static GstFlowReturn player_audio_sample_callback(GstElement *sink, gpointer data)
+{
+    return player_sink_pull(sink, (GstElement*) data, "audio", FALSE);
+}
+
+static GstFlowReturn player_video_sample_callback(GstElement *sink, gpointer data)
+{
+    return player_sink_pull(sink, (GstElement*) data, "video", FALSE);
+}
+
+static GstFlowReturn player_audio_preroll_callback(GstElement *sink, gpointer data)
+{
+    return player_sink_pull(sink, (GstElement*) data, "audio", TRUE);
+}
+
+static GstFlowReturn player_video_preroll_callback(GstElement *sink, gpointer data)
+{
+    return player_sink_pull(sink, (GstElement*) data, "video", TRUE);
+}
+
+// pipeline is prerolled (paused) unless playing is set
+void gstStartPlayer(GstElement *pipeline, gboolean playing)
+{
+    GstBus *bus = gst_pipeline_get_bus(GST_PIPELINE(pipeline));
+    gst_bus_add_watch(bus, player_bus_callback, pipeline);
+    gst_object_unref(bus);
+
+    GstElement *audioSink = gst_bin_get_by_name(GST_BIN(pipeline), "audio_sample_sink");
+    if (audioSink != NULL) {
+        g_object_set(audioSink, "emit-signals", TRUE, NULL);
+        g_signal_connect(audioSink, "new-sample", G_CALLBACK(player_audio_sample_callback), pipeline);
+        g_signal_connect(audioSink, "new-preroll", G_CALLBACK(player_audio_preroll_callback), pipeline);
+        gst_object_unref(audioSink);
+    }
+    GstElement *videoSink = gst_bin_get_by_name(GST_BIN(pipeline), "video_sample_sink");
+    if (videoSink != NULL) {
+        g_object_set(videoSink, "emit-signals", TRUE, NULL);
+        g_signal_connect(videoSink, "new-sample", G_CALLBACK(player_video_sample_callback), pipeline);
+        g_signal_connect(videoSink, "new-preroll", G_CALLBACK(player_video_preroll_callback), pipeline);
+        gst_object_unref(videoSink);
+    }
+
+    gst_element_set_state(pipeline, playing ? GST_STATE_PLAYING : GST_STATE_PAUSED);
+}
+
+void gstSetPlaying(GstElement *pipeline, gboolean playing)
+{
+    gst_element_set_state(pipeline, playing ? GST_STATE_PLAYING : GST_STATE_PAUSED);
+}
+
+void gstRewindPlayer(GstElement *pipeline)
+{
+    gst_element_seek_simple(pipeline, GST_FORMAT_TIME, GST_SEEK_FLAG_FLUSH | GST_SEEK_FLAG_KEY_UNIT, 0);
+}
+
+void gstStopPlayer(GstElement *pipeline)
+{
+    GstBus *bus = gst_pipeline_get_bus(GST_PIPELINE(pipeline));
+    gst_bus_remove_watch(bus);
+    gst_object_unref(bus);
+
+    gst_element_set_state(pipeline, GST_STATE_NULL);
+    gst_object_unref(pipeline);
+}
 
 // float get/set
 
@@ -12,13 +12,34 @@ extern void goDeletePipeline(char *id);
 extern void goRequestKeyFrame(char *id);
 extern void goBusLog(char *id, char *msg, char *el);
 extern void goDebugLog(int level, char *file, char *function,int line, char *msg);
+extern void goStreamFirstBuffer(char *id, char *filesink, char *kind, guint64 pts, guint64 runningTime, guint64 baseTime, guint64 clockTime, gint64 wallTime);
+extern void goAnalysisLevel(char *id, guint64 timestamp, double rms, double peak);
+extern void goAnalysisSpectrum(char *id, guint64 timestamp, float *magnitudes, guint size);
+extern void goAnalysisSamples(char *id, guint64 timestamp, float *samples, guint size);
+extern void goAnalysisFrame(char *id, guint64 timestamp);
+extern void goAnalysisResolution(char *id, gint width, gint height);
+extern void goPlayerSample(char *id, char *kind, void *buffer, int bufferLen, gint64 duration, gboolean preroll);
+extern void goPlayerEOS(char *id);
+extern void goPlayerError(char *id, char *msg, char *el);
 
 void gstStartMainLoop(gboolean interceptLogs);
+gboolean gstMainLoopIsRunning();
+gboolean gstElementExists(char *name);
 GstElement *gstParsePipeline(char *pipelineStr, char *id);
 void gstStartPipeline(GstElement *pipeline, gboolean audioOnly);
 void gstStopPipeline(GstElement *pipeline);
 void gstSrcPush(GstElement *pipeline, char *src, void *buffer, int len);
 void gstSendPLI(GstElement *pipeline);
+void gstWatchStreams(GstElement *pipeline, char *filesink);
+GstClockTime gstRunningTime(GstElement *pipeline);
+char *gstRunPipeline(char *pipelineStr, int timeoutSeconds);
+char *gstRunControlledPipeline(char *pipelineStr, int timeoutSeconds, char *controls);
+char *gstInspectFile(char *location, int timeoutSeconds, char *report, int reportLen);
+char *gstRunAnalysis(char *pipelineStr, int timeoutSeconds, char *id);
+void gstStartPlayer(GstElement *pipeline, gboolean playing);
+void gstSetPlaying(GstElement *pipeline, gboolean playing);
+void gstRewindPlayer(GstElement *pipeline);
+void gstStopPlayer(GstElement *pipeline);
 
 // get/set props
 float gstGetPropFloat(GstElement *pipeline, char *elName, char *elProp);
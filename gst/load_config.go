@@ -16,6 +16,18 @@ type queueConfig struct {
 	Long  string
 }
 
+type losslessConfig struct {
+	Audio struct {
+		Encoder   string
+		Extension string
+	}
+	Video struct {
+		Encoder   string
+		Muxer     string
+		Extension string
+	}
+}
+
 type gstEnhancedConfig struct {
 	Shared struct {
 		Video struct {
@@ -29,13 +41,14 @@ type gstEnhancedConfig struct {
 		}
 		Queue queueConfig
 	}
-	Opus  mediaOptions
-	VP8   mediaOptions `yaml:"vp8"`
-	X264  mediaOptions
-	NV264 mediaOptions `yaml:"nv264"`
+	Opus     mediaOptions
+	VP8      mediaOptions `yaml:"vp8"`
+	X264     mediaOptions
+	NV264    mediaOptions `yaml:"nv264"`
+	Lossless losslessConfig
 }
 
-var templateNames = []string{"audio_only_no_recording", "audio_only", "direct", "muxed_forced_framerate", "muxed_free_framerate", "muxed_reenc_dry", "no_recording", "rtpbin_only", "split"}
+var templateNames = []string{"audio_only_no_recording", "audio_only", "direct", "muxed_forced_framerate", "muxed_free_framerate", "muxed_reenc_dry", "no_recording", "reprocess", "rtpbin_only", "split"}
 
 // global state
 var gstConfig gstEnhancedConfig
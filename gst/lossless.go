@@ -0,0 +1,108 @@
+package gst
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+// lossless recordings are written alongside compressed ones, for analyses
+// (pitch, formants...) that would be distorted by lossy codecs
+
+// capitalized props are accessible to template
+type losslessOptions struct {
+	Audio           bool
+	Video           bool
+	recordingPrefix string
+	videoConstraint string
+}
+
+func newLosslessOptions(jp types.JoinPayload, videoOptions mediaOptions, recordingPrefix string) losslessOptions {
+	return losslessOptions{
+		Audio:           jp.LosslessAudio,
+		Video:           jp.LosslessVideo && !jp.AudioOnly,
+		recordingPrefix: recordingPrefix,
+		videoConstraint: videoOptions.ConstraintFormatFramerateResolution(jp.Framerate, jp.Width, jp.Height),
+	}
+}
+
+// kind is "audio" or "video", state is "dry" or "wet"
+func losslessFile(recordingPrefix, kind, state string) string {
+	extension := gstConfig.Lossless.Audio.Extension
+	if kind == "video" {
+		extension = gstConfig.Lossless.Video.Extension
+	}
+	return recordingPrefix + "lossless-" + kind + "-" + state + "." + extension
+}
+
+func losslessFilesink(kind, state string) string {
+	return state + "_lossless_" + kind + "_filesink"
+}
+
+// lossless branches must not drop buffers (a leaky queue would silently discard
+// samples under load): a full queue blocks the tee instead
+func losslessQueue() string {
+	return gstConfig.Shared.Queue.Long
+}
+
+// expects raw audio
+func (lo losslessOptions) audioSink(state string) string {
+	return fmt.Sprintf("audioconvert ! %v ! filesink name=%v location=%v",
+		gstConfig.Lossless.Audio.Encoder,
+		losslessFilesink("audio", state),
+		quoteLocation(losslessFile(lo.recordingPrefix, "audio", state)),
+	)
+}
+
+// expects raw video, scaled to constant caps before encoding
+func (lo losslessOptions) videoSink(state string) string {
+	return fmt.Sprintf("%v ! %v ! %v ! filesink name=%v location=%v",
+		lo.videoConstraint,
+		gstConfig.Lossless.Video.Encoder,
+		gstConfig.Lossless.Video.Muxer,
+		losslessFilesink("video", state),
+		quoteLocation(losslessFile(lo.recordingPrefix, "video", state)),
+	)
+}
+
+func branch(tee string, steps []string, sink string) string {
+	elements := append([]string{tee + ".", losslessQueue()}, steps...)
+	return strings.Join(append(elements, sink), " ! ")
+}
+
+// template helpers, they render nothing when the lossless option is disabled
+
+// AudioFrom records a new branch of the tee named tee, steps (depayloader, decoder...)
+// turning tee output into raw audio
+func (lo losslessOptions) AudioFrom(tee, state string, steps ...string) string {
+	if !lo.Audio {
+		return ""
+	}
+	return branch(tee, steps, lo.audioSink(state))
+}
+
+// VideoFrom records a new branch of the tee named tee, steps (depayloader, decoder...)
+// turning tee output into raw video
+func (lo losslessOptions) VideoFrom(tee, state string, steps ...string) string {
+	if !lo.Video {
+		return ""
+	}
+	return branch(tee, steps, lo.videoSink(state))
+}
+
+// AudioTap is inserted inline in a raw audio chain and ends with "!", so that the
+// chain goes on after it (through a leaky queue, as other live branches)
+func (lo losslessOptions) AudioTap(state string) string {
+	if !lo.Audio {
+		return ""
+	}
+	tee := "tee_lossless_audio_" + state
+	return fmt.Sprintf("tee name=%v ! %v ! %v %v. ! %v !",
+		tee,
+		losslessQueue(),
+		lo.audioSink(state),
+		tee,
+		gstConfig.Shared.Queue.Leaky,
+	)
+}
@@ -0,0 +1,60 @@
+package gst
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func TestLosslessBranches(t *testing.T) {
+	jp := types.JoinPayload{
+		UserId:        "user",
+		RecordingMode: "split",
+		VideoFormat:   "H264",
+		Width:         640,
+		Height:        480,
+		Framerate:     30,
+		LosslessAudio: true,
+		LosslessVideo: true,
+		AudioFx:       types.Fx{{Element: "pitch"}},
+		VideoFx:       types.Fx{{Element: "agingtv"}},
+	}
+
+	t.Run("Disabled options render nothing", func(t *testing.T) {
+		lo := newLosslessOptions(types.JoinPayload{}, gstConfig.X264, "prefix-")
+		if got := lo.AudioFrom("tee", "dry", "decoder") + lo.VideoFrom("tee", "dry") + lo.AudioTap("wet"); got != "" {
+			t.Errorf("got %q but expected nothing", got)
+		}
+	})
+
+	t.Run("Branches don't use leaky queues", func(t *testing.T) {
+		lo := newLosslessOptions(jp, gstConfig.X264, "prefix-")
+		branch := lo.AudioFrom("tee_audio_in", "dry", "opusdec")
+		if !strings.HasPrefix(branch, "tee_audio_in. ! "+gstConfig.Shared.Queue.Long+" ! opusdec ! audioconvert") {
+			t.Errorf("unexpected branch %q", branch)
+		}
+		// the only leaky queue of the tap is the one continuing the live chain
+		tap := lo.AudioTap("wet")
+		if sink, _, _ := strings.Cut(tap, "tee_lossless_audio_wet. !"); strings.Contains(sink, "leaky") {
+			t.Errorf("leaky queue in lossless tap %q", tap)
+		}
+		if !strings.HasSuffix(tap, " !") {
+			t.Errorf("tap %q should end with a link", tap)
+		}
+	})
+
+	t.Run("All templates render lossless sinks", func(t *testing.T) {
+		videoOptions, audioOptions := getOptions(jp, "interaction")
+		for _, mode := range []string{"split", "free", "reenc", "forced"} {
+			jp := jp
+			jp.RecordingMode = mode
+			def := newPipelineDef(jp, "", t.TempDir(), "prefix", videoOptions, audioOptions)
+			for _, sink := range []string{"dry_lossless_audio_filesink", "wet_lossless_audio_filesink", "dry_lossless_video_filesink", "wet_lossless_video_filesink"} {
+				if !strings.Contains(def, "name="+sink+" ") {
+					t.Errorf("%v: missing %v", mode, sink)
+				}
+			}
+		}
+	})
+}
@@ -17,14 +17,18 @@ type mediaOptions struct {
 	nvCuda  bool
 	Overlay bool
 	// properties depending on yml definitions
-	DefaultBitrate  int
-	DefaultKBitrate int
-	Fx              string
-	Muxer           string
-	Extension       string
-	Decoder         string
-	Encoder         string
-	Rtp             struct {
+	DefaultBitrate    int
+	DefaultKBitrate   int
+	RecordingBitrate  int
+	RecordingKBitrate int
+	Fx                string
+	Muxer             string
+	Extension         string
+	Decoder           string
+	Encoder           string
+	RecordingEncoder  string `yaml:"recordingEncoder"`
+	FragmentDuration  int    `yaml:"fragmentDuration"`
+	Rtp               struct {
 		Caps         string
 		Pay          string
 		Depay        string
@@ -43,6 +47,8 @@ func (mo *mediaOptions) addSharedVideoProperties() {
 	// used in template or by template helpers
 	mo.DefaultBitrate = config.SFU.Video.DefaultBitrate
 	mo.DefaultKBitrate = config.SFU.Video.DefaultBitrate / 1000
+	mo.RecordingBitrate = config.SFU.Video.RecordingBitrate
+	mo.RecordingKBitrate = config.SFU.Video.RecordingBitrate / 1000
 	mo.TimeOverlay = gstConfig.Shared.Video.TimeOverlay
 }
 
@@ -62,6 +68,33 @@ func (mo mediaOptions) EncodeWithCache(name, folder, filePrefix string) (output
 	return
 }
 
+// encoder used only for wet recordings, with a fixed quality
+func (mo mediaOptions) RecordingEncodeWith(name string) (output string) {
+	output = strings.Replace(mo.RecordingEncoder, "{{.Name}}", name, -1)
+	output = strings.Replace(output, "{{.RecordingBitrate}}", strconv.Itoa(mo.RecordingBitrate), -1)
+	output = strings.Replace(output, "{{.RecordingKBitrate}}", strconv.Itoa(mo.RecordingKBitrate), -1)
+	return
+}
+
+// mp4mux either writes fragments (playable even if the pipeline crashes, remuxed
+// when the pipeline is deleted) or a faststart file (only playable after a clean EOS)
+func (mo mediaOptions) MuxWith(name, folder, filePrefix string) (output string) {
+	output = mo.Muxer + " name=" + name
+	if mo.Muxer != "mp4mux" {
+		return
+	}
+	if mo.FragmentDuration > 0 {
+		output += " fragment-duration=" + strconv.Itoa(mo.FragmentDuration)
+	} else {
+		output += " faststart=true faststart-file=" + folder + "/cache/" + filePrefix + "-" + name + ".faststart"
+	}
+	return
+}
+
+func (mo mediaOptions) isFragmented() bool {
+	return mo.Muxer == "mp4mux" && mo.FragmentDuration > 0
+}
+
 func (mo mediaOptions) ConstraintFormat() (output string) {
 	output = strings.Replace(gstConfig.Shared.Video.Constraint.Format, "{{.VideoFormat}}", gstConfig.Shared.Video.RawFormat, -1)
 	if mo.nvCuda {
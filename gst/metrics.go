@@ -0,0 +1,29 @@
+package gst
+
+import (
+	"regexp"
+
+	"github.com/ducksouplab/ducksoup/metrics"
+)
+
+// unnamed elements are numbered by GStreamer (queue12), the number is dropped to
+// keep label cardinality bounded
+var elementIndexRegexp = regexp.MustCompile(`\d+$`)
+
+var gstreamerErrorsTotal = metrics.NewCounterVec(
+	"ducksoup_gstreamer_errors_total",
+	"GStreamer errors posted on pipeline buses, by element.",
+	"element")
+
+func init() {
+	metrics.NewGaugeFunc(
+		"ducksoup_pipelines_alive",
+		"GStreamer pipelines not deleted yet.",
+		func(set func(value float64, labelValues ...string)) {
+			set(float64(LivePipelineCount()))
+		})
+}
+
+func countError(element string) {
+	gstreamerErrorsTotal.Inc(elementIndexRegexp.ReplaceAllString(element, ""))
+}
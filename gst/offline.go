@@ -0,0 +1,67 @@
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0
+#include "gst.h"
+*/
+import "C"
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// offline pipelines process recordings once they are written
+
+// quotes a file path for the location property in pipeline descriptions, so that
+// spaces or "!" in paths can't break (or extend) the pipeline
+func quoteLocation(path string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(path) + `"`
+}
+
+// runs pipeline till its end, blocking
+func runPipeline(pipelineStr string, timeout time.Duration) error {
+	cPipelineStr := C.CString(pipelineStr)
+	defer C.free(unsafe.Pointer(cPipelineStr))
+
+	cErr := C.gstRunPipeline(cPipelineStr, C.int(timeout.Seconds()))
+	if cErr != nil {
+		defer C.free(unsafe.Pointer(cErr))
+		return errors.New(C.GoString(cErr))
+	}
+	return nil
+}
+
+// remuxes a fragmented mp4 file (playable even if it has not been properly closed)
+// to a regular faststart mp4 file, more widely supported, replacing the original one
+// if it succeeds. streamCount is needed to link demuxer pads to the muxer
+func remuxToFaststart(file, cacheFolder string, streamCount int) error {
+	base := filepath.Base(file)
+	tmpFile := cacheFolder + "/" + base + ".remux"
+
+	var b strings.Builder
+	b.WriteString("filesrc location=" + quoteLocation(file) + " ! qtdemux name=demux ")
+	b.WriteString("mp4mux name=mux faststart=true faststart-file=" + quoteLocation(cacheFolder+"/"+base+".faststart") + " ! ")
+	b.WriteString("filesink location=" + quoteLocation(tmpFile))
+	for i := 0; i < streamCount; i++ {
+		b.WriteString(" demux. ! queue ! mux.")
+	}
+
+	if err := runPipeline(b.String(), 10*time.Minute); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, file)
+}
+
+// Remux remuxes a fragmented mp4 file to a faststart one (see remuxToFaststart)
+func Remux(file, cacheFolder string) error {
+	r := VerifyFile(file)
+	if !r.Valid {
+		return errors.New(r.Error)
+	}
+	return remuxToFaststart(file, cacheFolder, len(r.Streams))
+}
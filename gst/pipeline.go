@@ -10,8 +10,8 @@ import (
 	"fmt"
 	"slices"
 	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -21,7 +21,7 @@ import (
 	"github.com/rs/zerolog"
 )
 
-var muxedModes = []string{"forced", "free", "reenc"}
+var muxedModes = []string{"muxed", "forced", "free", "reenc"}
 
 // Pipeline is a wrapper for a GStreamer pipeline and output track
 type Pipeline struct {
@@ -31,8 +31,10 @@ type Pipeline struct {
 	audioOutput types.TrackWriter
 	videoOutput types.TrackWriter
 	startedCh   chan struct{}
+	doneCh      chan struct{} // closed once the pipeline is deleted and its files are finalized
 	// sfu info
 	jp              types.JoinPayload
+	receiverId      string // set if pipeline only processes the stream sent to a given receiver
 	plir            types.PLIRequester
 	iRandomId       string // interaction random id for filenames
 	connectionCount int    // count #connections for this user in this interaction
@@ -44,8 +46,18 @@ type Pipeline struct {
 	// data and log
 	dataFolder string
 	logger     zerolog.Logger
+	// fragmented mp4 files to be remuxed once the pipeline is deleted (value is the stream count)
+	fragmentedFiles map[string]int
+	// synchronization of recordings
+	syncMu        sync.Mutex
+	filesinks     map[string]string               // filesink name to recording file
+	streams       map[string][]types.StreamTiming // per recording file
+	senderReports []types.SenderReport
+	rtpAnchors    map[string]rtpAnchor    // per kind
+	anchored      map[string]*atomic.Bool // per kind, not to lock when pushing RTP
 	// API
 	RecordingFiles []string
+	StartedAt      time.Time
 }
 
 func fileName(namespace string, prefix string, suffix string) string {
@@ -80,11 +92,9 @@ func getOptions(jp types.JoinPayload, iRandomId string) (videoOptions, audioOpti
 	videoOptions.nvCuda = nvCuda
 	videoOptions.Overlay = jp.Overlay || env.ForceOverlay
 	// complete with Fx
-	audioOptions.Fx = strings.Replace(jp.AudioFx, "name=", "name=client_", -1)
-	videoOptions.Fx = strings.Replace(jp.VideoFx, "name=", "name=client_", -1)
-	if strings.Contains(videoOptions.Fx, "mozza") {
-		videoOptions.Fx += fmt.Sprintf(" user-id=r-%v-u-%v", iRandomId, jp.UserId)
-	}
+	audioOptions.Fx = renderFx(jp.AudioFx, "audioconvert")
+	videoFx := withMozzaUserId(jp.VideoFx, fmt.Sprintf("r-%v-u-%v", iRandomId, jp.UserId))
+	videoOptions.Fx = renderFx(videoFx, "videoconvert")
 
 	return
 }
@@ -94,12 +104,11 @@ func getOptions(jp types.JoinPayload, iRandomId string) (videoOptions, audioOpti
 //     with the interaction creation timestamp
 //   - when the pipeline is started, the timestamp is updated
 func (p *Pipeline) filePrefix() string {
-	return "i-" + p.iRandomId +
-		"-a-" + time.Now().Format("20060102-150405.000") +
-		"-s-" + p.jp.Namespace +
-		"-n-" + p.jp.InteractionName +
-		"-u-" + p.jp.UserId +
-		"-c-" + fmt.Sprint(p.connectionCount)
+	return types.FilePrefix(p.jp, p.iRandomId, p.connectionCount, p.receiverId)
+}
+
+func (p *Pipeline) receiverSuffix() string {
+	return types.ReceiverSuffix(p.receiverId)
 }
 
 func envInterceptGSTLogs() int {
@@ -118,12 +127,30 @@ func StartMainLoop() {
 
 // create a GStreamer pipeline
 func NewPipeline(jp types.JoinPayload, plir types.PLIRequester, dataFolder, iRandomId string, connectionCount int, logger zerolog.Logger) *Pipeline {
+	return newPipeline(jp, "", plir, dataFolder, iRandomId, connectionCount, logger)
+}
+
+// create a GStreamer pipeline that processes the stream of jp.UserId sent to receiverId only,
+// with the effects declared for this receiver and without recording
+func NewReceiverPipeline(jp types.JoinPayload, receiverId string, plir types.PLIRequester, dataFolder, iRandomId string, connectionCount int, logger zerolog.Logger) *Pipeline {
+	receiverJp := jp
+	receiverJp.AudioFx = jp.ReceiverFx[receiverId].AudioFx
+	receiverJp.VideoFx = jp.ReceiverFx[receiverId].VideoFx
+	receiverJp.RecordingMode = "none"
+	receiverJp.ReceiverFx = nil
+	return newPipeline(receiverJp, receiverId, plir, dataFolder, iRandomId, connectionCount, logger)
+}
+
+func newPipeline(jp types.JoinPayload, receiverId string, plir types.PLIRequester, dataFolder, iRandomId string, connectionCount int, logger zerolog.Logger) *Pipeline {
 	id := uuid.New().String()
 	logger = logger.With().
 		Str("context", "pipeline").
 		Str("user", jp.UserId).
 		Str("pipeline", id).
 		Logger()
+	if len(receiverId) > 0 {
+		logger = logger.With().Str("toUser", receiverId).Logger()
+	}
 
 	videoOptions, audioOptions := getOptions(jp, iRandomId)
 	logger.Info().Str("audioOptions", fmt.Sprintf("%+v", audioOptions)).Msg("template_data")
@@ -133,6 +160,7 @@ func NewPipeline(jp types.JoinPayload, plir types.PLIRequester, dataFolder, iRan
 		mu:              sync.Mutex{},
 		id:              id,
 		jp:              jp,
+		receiverId:      receiverId,
 		plir:            plir,
 		iRandomId:       iRandomId,
 		connectionCount: connectionCount,
@@ -140,12 +168,18 @@ func NewPipeline(jp types.JoinPayload, plir types.PLIRequester, dataFolder, iRan
 		audioOptions:    audioOptions,
 		stoppedCount:    0,
 		startedCh:       make(chan struct{}),
+		doneCh:          make(chan struct{}),
 		dataFolder:      dataFolder,
 		logger:          logger,
+		fragmentedFiles: make(map[string]int),
+		filesinks:       make(map[string]string),
+		streams:         make(map[string][]types.StreamTiming),
+		rtpAnchors:      make(map[string]rtpAnchor),
+		anchored:        map[string]*atomic.Bool{"audio": {}, "video": {}},
 	}
 
 	// C pipeline
-	pipelineStr := newPipelineDef(jp, p.dataFolder, p.filePrefix(), videoOptions, audioOptions)
+	pipelineStr := newPipelineDef(jp, p.receiverSuffix(), p.dataFolder, p.filePrefix(), videoOptions, audioOptions)
 	cPipelineStr := C.CString(pipelineStr)
 	cId := C.CString(id)
 	defer C.free(unsafe.Pointer(cPipelineStr))
@@ -173,11 +207,22 @@ func (p *Pipeline) Started() chan struct{} {
 	return p.startedCh
 }
 
+func (p *Pipeline) Done() chan struct{} {
+	return p.doneCh
+}
+
+// recording files, set when the pipeline starts
+func (p *Pipeline) Files() []string {
+	return p.RecordingFiles
+}
+
 func (p *Pipeline) PushRTP(kind string, buffer []byte) {
+	p.anchorRTP(kind, buffer)
 	p.srcPush(kind+"_rtp_src", buffer)
 }
 
 func (p *Pipeline) PushRTCP(kind string, buffer []byte) {
+	p.addSenderReports(kind, buffer)
 	p.srcPush(kind+"_rtcp_src", buffer)
 }
 
@@ -201,8 +246,13 @@ func (p *Pipeline) updateReady(kind string) {
 }
 
 func (p *Pipeline) start() {
+	p.StartedAt = time.Now()
 	// update timestamps in recordings file paths
 	p.updateRecordingFiles()
+	for filesink := range p.filesinks {
+		// may only be updated by updateRecordingFiles
+		p.watchStreams(filesink)
+	}
 	// GStreamer start
 	audioOnly := 0
 	if p.jp.AudioOnly {
@@ -238,44 +288,106 @@ func (p *Pipeline) Stop() {
 }
 
 func (p *Pipeline) updateRecordingFiles() {
+	if env.NoRecording || p.jp.RecordingMode == "none" {
+		return
+	}
 	hasWetFiles := len(p.jp.AudioFx) > 0 || len(p.jp.VideoFx) > 0
 	recordingPrefix := p.dataFolder + "/recordings/" + p.filePrefix() + "-"
 
 	if p.jp.AudioOnly {
 		dryAudioFile := recordingPrefix + "audio-dry." + p.audioOptions.Extension
-		p.setPropString("dry_audio_filesink", "location", dryAudioFile)
-		p.RecordingFiles = append(p.RecordingFiles, dryAudioFile)
+		p.setRecordingFile("dry_audio_filesink", dryAudioFile)
 		if hasWetFiles {
 			wetAudioFile := recordingPrefix + "audio-wet." + p.audioOptions.Extension
-			p.setPropString("wet_audio_filesink", "location", wetAudioFile)
-			p.RecordingFiles = append(p.RecordingFiles, wetAudioFile)
+			p.setRecordingFile("wet_audio_filesink", wetAudioFile)
 		}
 	} else {
 		if slices.Contains(muxedModes, p.jp.RecordingMode) {
 			dryFile := recordingPrefix + "dry." + p.videoOptions.Extension
-			p.setPropString("dry_filesink", "location", dryFile)
-			p.RecordingFiles = append(p.RecordingFiles, dryFile)
+			p.setRecordingFile("dry_filesink", dryFile)
+			p.addFragmentedFile(dryFile, 2)
 			if hasWetFiles {
 				wetFile := recordingPrefix + "wet." + p.videoOptions.Extension
-				p.setPropString("wet_filesink", "location", wetFile)
-				p.RecordingFiles = append(p.RecordingFiles, wetFile)
+				p.setRecordingFile("wet_filesink", wetFile)
+				p.addFragmentedFile(wetFile, 2)
 			}
 		} else if p.jp.RecordingMode == "split" {
 			dryAudioFile := recordingPrefix + "audio-dry." + p.audioOptions.Extension
 			dryVideoFile := recordingPrefix + "video-dry." + p.videoOptions.Extension
-			p.setPropString("dry_audio_filesink", "location", dryAudioFile)
-			p.setPropString("dry_video_filesink", "location", dryVideoFile)
-			p.RecordingFiles = append(p.RecordingFiles, dryAudioFile, dryVideoFile)
+			p.setRecordingFile("dry_audio_filesink", dryAudioFile)
+			p.setRecordingFile("dry_video_filesink", dryVideoFile)
+			p.addFragmentedFile(dryVideoFile, 1)
 			if hasWetFiles {
 				wetAudioFile := recordingPrefix + "audio-wet." + p.audioOptions.Extension
 				wetVideoFile := recordingPrefix + "video-wet." + p.videoOptions.Extension
-				p.setPropString("wet_audio_filesink", "location", wetAudioFile)
-				p.setPropString("wet_video_filesink", "location", wetVideoFile)
-				p.RecordingFiles = append(p.RecordingFiles, wetAudioFile, wetVideoFile)
+				p.setRecordingFile("wet_audio_filesink", wetAudioFile)
+				p.setRecordingFile("wet_video_filesink", wetVideoFile)
+				p.addFragmentedFile(wetVideoFile, 1)
 			}
 		}
 		// else there is no record
 	}
+	if p.jp.AudioOnly || slices.Contains(muxedModes, p.jp.RecordingMode) || p.jp.RecordingMode == "split" {
+		p.updateLosslessRecordingFiles(recordingPrefix)
+	}
+}
+
+func (p *Pipeline) setRecordingFile(filesink, file string) {
+	p.setPropString(filesink, "location", file)
+	p.RecordingFiles = append(p.RecordingFiles, file)
+	p.syncMu.Lock()
+	p.filesinks[filesink] = file
+	p.syncMu.Unlock()
+}
+
+func (p *Pipeline) watchStreams(filesink string) {
+	cFilesink := C.CString(filesink)
+	defer C.free(unsafe.Pointer(cFilesink))
+
+	C.gstWatchStreams(p.cPipeline, cFilesink)
+}
+
+func (p *Pipeline) addFragmentedFile(file string, streamCount int) {
+	if p.videoOptions.isFragmented() {
+		p.fragmentedFiles[file] = streamCount
+	}
+}
+
+// called once the pipeline has been deleted and its files closed
+func (p *Pipeline) finalize() {
+	p.remuxFragmentedFiles()
+	close(p.doneCh)
+}
+
+func (p *Pipeline) remuxFragmentedFiles() {
+	for file, streamCount := range p.fragmentedFiles {
+		start := time.Now()
+		if err := remuxToFaststart(file, p.dataFolder+"/cache", streamCount); err != nil {
+			// the fragmented file is kept
+			p.logger.Error().Err(err).Str("file", file).Msg("recording_remux_failed")
+		} else {
+			p.logger.Info().Str("file", file).Int64("value", time.Since(start).Milliseconds()).Str("unit", "ms").Msg("recording_remuxed")
+		}
+	}
+}
+
+func (p *Pipeline) updateLosslessRecordingFiles(recordingPrefix string) {
+	lo := newLosslessOptions(p.jp, p.videoOptions, recordingPrefix)
+	add := func(kind, state string) {
+		p.setRecordingFile(losslessFilesink(kind, state), losslessFile(recordingPrefix, kind, state))
+	}
+	if lo.Audio {
+		add("audio", "dry")
+		if len(p.jp.AudioFx) > 0 {
+			add("audio", "wet")
+		}
+	}
+	if lo.Video {
+		add("video", "dry")
+		if len(p.jp.VideoFx) > 0 {
+			add("video", "wet")
+		}
+	}
 }
 
 func (p *Pipeline) getPropInt(name string, prop string) int {
@@ -364,12 +476,12 @@ func (p *Pipeline) SetEncodingBitrate(kind string, value int) {
 
 func (p *Pipeline) SetFxPropFloat(name string, prop string, value float32) {
 	// fx prefix needed (added during pipeline initialization)
-	p.setPropFloat("client_"+name, prop, value)
+	p.setPropFloat(fxNamePrefix+name, prop, value)
 }
 
 func (p *Pipeline) GetFxPropFloat(name string, prop string) float32 {
 	// fx prefix needed (added during pipeline initialization)
-	cName := C.CString("client_" + name)
+	cName := C.CString(fxNamePrefix + name)
 	cProp := C.CString(prop)
 
 	defer C.free(unsafe.Pointer(cName))
@@ -379,7 +491,7 @@ func (p *Pipeline) GetFxPropFloat(name string, prop string) float32 {
 }
 
 func (p *Pipeline) SetFxPolyProp(name string, prop string, kind string, value string) {
-	cName := C.CString("client_" + name)
+	cName := C.CString(fxNamePrefix + name)
 	cProp := C.CString(prop)
 
 	defer C.free(unsafe.Pointer(cName))
@@ -44,7 +44,16 @@ func (ps *pipelineStore) delete(id string) {
 	p, ok := ps.index[id]
 	if ok {
 		p.logger.Info().Msg("pipeline_deleted")
+		go p.finalize()
 	}
 
 	delete(ps.index, id)
 }
+
+// LivePipelineCount returns the number of pipelines not deleted yet
+func LivePipelineCount() int {
+	pipelineStoreSingleton.Lock()
+	defer pipelineStoreSingleton.Unlock()
+
+	return len(pipelineStoreSingleton.index)
+}
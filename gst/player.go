@@ -0,0 +1,246 @@
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0 gstreamer-app-1.0
+#include "gst.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+const defaultAudioSampleDuration = 20 * time.Millisecond
+
+// Player reads a media file in real time and encodes it as a live participant would
+type Player struct {
+	mu          sync.Mutex
+	id          string
+	cPipeline   *C.GstElement
+	options     types.PlayerOptions
+	audioOutput types.SampleWriter
+	videoOutput types.SampleWriter
+	playing     atomic.Bool // read from GStreamer threads
+	started     bool
+	stopped     bool
+	logger      zerolog.Logger
+}
+
+var (
+	playerMu    sync.Mutex
+	playerIndex = make(map[string]*Player)
+)
+
+func findPlayer(id string) (p *Player, ok bool) {
+	playerMu.Lock()
+	defer playerMu.Unlock()
+
+	p, ok = playerIndex[id]
+	return
+}
+
+// C exports
+
+//export goPlayerSample
+func goPlayerSample(cId, cKind *C.char, buffer unsafe.Pointer, bufferLen C.int, duration C.gint64, preroll C.gboolean) {
+	p, ok := findPlayer(C.GoString(cId))
+	if !ok || (preroll != 0 && p.playing.Load()) {
+		// when playing, prerolled buffers are also rendered as samples
+		return
+	}
+	kind := C.GoString(cKind)
+	output, sampleDuration := p.audioOutput, defaultAudioSampleDuration
+	if kind == "video" {
+		output, sampleDuration = p.videoOutput, time.Second/time.Duration(p.options.Framerate)
+	}
+	if duration > 0 {
+		sampleDuration = time.Duration(duration)
+	}
+	if err := output.WriteSample(C.GoBytes(buffer, bufferLen), sampleDuration); err != nil {
+		p.logger.Error().Err(err).Str("kind", kind).Msg("player_write_failed")
+	}
+}
+
+//export goPlayerEOS
+func goPlayerEOS(cId *C.char) {
+	if p, ok := findPlayer(C.GoString(cId)); ok {
+		if p.options.Loop {
+			p.logger.Info().Msg("player_looped")
+			go p.Rewind()
+		} else {
+			p.logger.Info().Msg("player_ended")
+		}
+	}
+}
+
+//export goPlayerError
+func goPlayerError(cId, cMsg, cEl *C.char) {
+	if p, ok := findPlayer(C.GoString(cId)); ok {
+		p.logger.Error().Err(errors.New(C.GoString(cMsg))).Str("element", C.GoString(cEl)).Msg("gstreamer_error")
+	}
+}
+
+func playerPipelineDef(id string, o types.PlayerOptions, hasAudio, hasVideo bool) string {
+	var b strings.Builder
+	b.WriteString("filesrc location=" + quoteLocation(o.File) + " ! decodebin name=decoder\n")
+	if hasAudio {
+		audioOptions := gstConfig.Opus
+		b.WriteString("decoder. ! " + gstConfig.Shared.Queue.Base + " ! audioconvert ! audioresample ! audio/x-raw,rate=48000,channels=2 ! ")
+		b.WriteString(audioOptions.EncodeWith("audio_encoder_player") + " ! ")
+		b.WriteString("appsink name=audio_sample_sink\n")
+	}
+	if hasVideo {
+		videoOptions := gstConfig.VP8
+		if o.VideoFormat == "H264" {
+			videoOptions = gstConfig.X264
+		}
+		b.WriteString("decoder. ! " + gstConfig.Shared.Queue.Base + " ! ")
+		b.WriteString(videoOptions.ConstraintFormatFramerateResolution(o.Framerate, o.Width, o.Height) + " ! ")
+		b.WriteString(videoOptions.EncodeWithCache("video_encoder_player", o.DataFolder, "player-"+id) + " ! ")
+		if o.VideoFormat == "H264" {
+			b.WriteString("video/x-h264,stream-format=byte-stream,alignment=au ! ")
+		}
+		b.WriteString("appsink name=video_sample_sink\n")
+	}
+	return b.String()
+}
+
+// API
+
+// NewPlayer checks the streams of o.File and prepares a (not started) player
+func NewPlayer(o types.PlayerOptions, audioOutput, videoOutput types.SampleWriter, logger zerolog.Logger) (*Player, error) {
+	v := VerifyFile(o.File)
+	if !v.Valid {
+		return nil, errors.New(v.Error)
+	}
+	hasAudio, hasVideo := false, false
+	for _, s := range v.Streams {
+		if strings.HasPrefix(s.Codec, "audio/") {
+			hasAudio = true
+		} else if strings.HasPrefix(s.Codec, "video/") {
+			hasVideo = true
+		}
+	}
+	if !hasAudio {
+		return nil, errors.New("no audio stream")
+	}
+	if !o.AudioOnly && !hasVideo {
+		return nil, errors.New("no video stream")
+	}
+
+	id := uuid.New().String()
+	p := &Player{
+		id:          id,
+		options:     o,
+		audioOutput: audioOutput,
+		videoOutput: videoOutput,
+		logger:      logger.With().Str("context", "pipeline").Str("pipeline", id).Logger(),
+	}
+	p.playing.Store(o.Autoplay)
+
+	pipelineStr := playerPipelineDef(id, o, hasAudio, !o.AudioOnly)
+	cPipelineStr := C.CString(pipelineStr)
+	cId := C.CString(id)
+	defer C.free(unsafe.Pointer(cPipelineStr))
+	defer C.free(unsafe.Pointer(cId))
+	p.cPipeline = C.gstParsePipeline(cPipelineStr, cId)
+	if p.cPipeline == nil {
+		return nil, fmt.Errorf("invalid pipeline: %v", pipelineStr)
+	}
+	p.logger.Info().Str("pipeline", pipelineStr).Msg("player_initialized")
+
+	playerMu.Lock()
+	playerIndex[id] = p
+	playerMu.Unlock()
+	return p, nil
+}
+
+// Start prerolls the player, and starts reading if Autoplay is set or Play has been called
+func (p *Player) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.started || p.stopped {
+		return
+	}
+	p.started = true
+	playing := p.playing.Load()
+	C.gstStartPlayer(p.cPipeline, C.int(boolToInt(playing)))
+	p.logger.Info().Bool("playing", playing).Msg("player_started")
+}
+
+func (p *Player) Play() {
+	p.setPlaying(true)
+}
+
+func (p *Player) Pause() {
+	p.setPlaying(false)
+}
+
+func (p *Player) setPlaying(playing bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped || p.playing.Load() == playing {
+		return
+	}
+	p.playing.Store(playing)
+	if !p.started {
+		// applied by Start
+		return
+	}
+	C.gstSetPlaying(p.cPipeline, C.int(boolToInt(playing)))
+}
+
+// Rewind goes back to the beginning of the file, keeping the playing state
+func (p *Player) Rewind() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.started || p.stopped {
+		return
+	}
+	C.gstRewindPlayer(p.cPipeline)
+	// decoders of receivers need a key frame after the discontinuity
+	C.gstSendPLI(p.cPipeline)
+}
+
+func (p *Player) RequestKeyFrame() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.started && !p.stopped {
+		C.gstSendPLI(p.cPipeline)
+	}
+}
+
+func (p *Player) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	playerMu.Lock()
+	delete(playerIndex, p.id)
+	playerMu.Unlock()
+	C.gstStopPlayer(p.cPipeline)
+	p.logger.Info().Msg("player_stopped")
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0
+#include "gst.h"
+*/
+import "C"
+import (
+	"slices"
+	"strings"
+	"unsafe"
+
+	"github.com/ducksouplab/ducksoup/env"
+)
+
+// factory names of the elements of a partial pipeline description (first word of each
+// part), caps and unresolved template parts are skipped
+func elementsOf(description string) (names []string) {
+	for _, part := range strings.Split(description, "!") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		name := fields[0]
+		if strings.ContainsAny(name, "/=,{") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return
+}
+
+func (mo mediaOptions) elements() (names []string) {
+	for _, description := range []string{mo.Muxer, mo.Rtp.Pay, mo.Rtp.Depay, mo.Decoder, mo.Encoder, mo.RecordingEncoder} {
+		names = append(names, elementsOf(description)...)
+	}
+	return
+}
+
+func appendUnique(names []string, added ...string) []string {
+	for _, name := range added {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// RequiredElements lists the elements declared in config/gst.yml, except NVIDIA ones
+func RequiredElements() (names []string) {
+	names = appendUnique(names, gstConfig.Opus.elements()...)
+	names = appendUnique(names, gstConfig.VP8.elements()...)
+	names = appendUnique(names, gstConfig.X264.elements()...)
+	for _, description := range []string{
+		gstConfig.Shared.Video.TimeOverlay,
+		gstConfig.Shared.Queue.Base,
+		gstConfig.Lossless.Audio.Encoder,
+		gstConfig.Lossless.Video.Encoder,
+		gstConfig.Lossless.Video.Muxer,
+	} {
+		names = appendUnique(names, elementsOf(description)...)
+	}
+	return
+}
+
+// NVCodecElements lists the elements used when DUCKSOUP_NVCODEC (and DUCKSOUP_NVCUDA) are set
+func NVCodecElements() (names []string) {
+	names = appendUnique(names, gstConfig.NV264.elements()...)
+	if env.NVCuda {
+		names = appendUnique(names, "cudaupload", "cudaconvertscale", "cudadownload")
+	}
+	return
+}
+
+// MissingElements returns the names that are not found in the GStreamer registry
+func MissingElements(names []string) (missing []string) {
+	for _, name := range names {
+		cName := C.CString(name)
+		found := C.gstElementExists(cName) != 0
+		C.free(unsafe.Pointer(cName))
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	return
+}
+
+// MainLoopRunning tells if the GLib main loop (see StartMainLoop) is running
+func MainLoopRunning() bool {
+	return C.gstMainLoopIsRunning() != 0
+}
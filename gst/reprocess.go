@@ -0,0 +1,116 @@
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0 gstreamer-controller-1.0
+#include "gst.h"
+*/
+import "C"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func formatControlEvents(events []types.ControlEvent) string {
+	sorted := append([]types.ControlEvent{}, events...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sorted[a].At < sorted[b].At
+	})
+	var b strings.Builder
+	for _, e := range sorted {
+		at := e.At
+		if at < 0 {
+			at = 0
+		}
+		b.WriteString(fmt.Sprintf("%v%v;%v;%v;%v;%v\n", fxNamePrefix, e.Name, e.Property, at.Nanoseconds(), e.Duration.Nanoseconds(), e.Value))
+	}
+	return b.String()
+}
+
+func runControlledPipeline(pipelineStr string, timeout time.Duration, events []types.ControlEvent) error {
+	cPipelineStr := C.CString(pipelineStr)
+	defer C.free(unsafe.Pointer(cPipelineStr))
+	cControls := C.CString(formatControlEvents(events))
+	defer C.free(unsafe.Pointer(cControls))
+
+	cErr := C.gstRunControlledPipeline(cPipelineStr, C.int(timeout.Seconds()), cControls)
+	if cErr != nil {
+		defer C.free(unsafe.Pointer(cErr))
+		return errors.New(C.GoString(cErr))
+	}
+	return nil
+}
+
+// renders the reprocess template with the media options of the live pipeline of jp,
+// so that encoders, muxer and effects are the ones used for live wet recordings
+func newReprocessDef(input, output string, jp types.JoinPayload, hasAudio, hasVideo bool) (string, error) {
+	videoOptions, audioOptions := getOptions(jp, "reprocess")
+	extension := audioOptions.Extension
+	if hasVideo {
+		extension = videoOptions.Extension
+	}
+	if filepath.Ext(output) != "."+extension {
+		return "", fmt.Errorf("output_extension_not_%v", extension)
+	}
+
+	data := struct {
+		Queue    queueConfig
+		Video    mediaOptions
+		Audio    mediaOptions
+		Input    string
+		Output   string
+		HasAudio bool
+		HasVideo bool
+	}{
+		gstConfig.Shared.Queue,
+		videoOptions,
+		audioOptions,
+		quoteLocation(input),
+		quoteLocation(output),
+		hasAudio,
+		hasVideo,
+	}
+	var buf bytes.Buffer
+	if err := templateIndex["reprocess"].Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return formatPipelineDef(buf.String()), nil
+}
+
+// Reprocess applies the effects of jp (AudioFx and VideoFx) to a dry recording and
+// encodes the result to output, as the live pipeline of jp would have done for its
+// wet recording (output extension has to match). Control events are replayed at their
+// offsets in the stream: since control values are bound to buffer timestamps, the
+// result does not depend on processing speed
+func Reprocess(input, output string, jp types.JoinPayload, events []types.ControlEvent) error {
+	r := VerifyFile(input)
+	if !r.Valid {
+		return errors.New(r.Error)
+	}
+	hasAudio, hasVideo := false, false
+	for _, s := range r.Streams {
+		if strings.HasPrefix(s.Codec, "audio/") {
+			hasAudio = true
+		} else if strings.HasPrefix(s.Codec, "video/") {
+			hasVideo = !jp.AudioOnly
+		}
+	}
+	pipelineStr, err := newReprocessDef(input, output, jp, hasAudio, hasVideo)
+	if err != nil {
+		return err
+	}
+
+	if err := runControlledPipeline(pipelineStr, 30*time.Minute, events); err != nil {
+		os.Remove(output)
+		return err
+	}
+	return nil
+}
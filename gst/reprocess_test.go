@@ -0,0 +1,81 @@
+package gst
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func TestNewReprocessDef(t *testing.T) {
+	jp := types.JoinPayload{
+		UserId:      "user",
+		VideoFormat: "H264",
+		AudioFx:     types.Fx{{Element: "pitch", Name: "pitch"}},
+		VideoFx:     types.Fx{{Element: "agingtv"}},
+	}
+
+	t.Run("Uses the live wet encoders and muxer", func(t *testing.T) {
+		def, err := newReprocessDef("in-dry.mp4", "out-wet.mp4", jp, true, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		videoOptions, audioOptions := getOptions(jp, "reprocess")
+		for _, expected := range []string{
+			gstConfig.X264.Muxer + " name=wet_muxer",
+			`filesink name=wet_filesink location="out-wet.mp4"`,
+			`filesrc location="in-dry.mp4"`,
+			audioOptions.Fx,
+			videoOptions.Fx,
+			strings.Fields(audioOptions.EncodeWith("audio_encoder_wet"))[0],
+			strings.Fields(videoOptions.RecordingEncodeWith("video_encoder_rec"))[0],
+		} {
+			if !strings.Contains(def, expected) {
+				t.Errorf("%q not found in:\n%v", expected, def)
+			}
+		}
+	})
+
+	t.Run("Depends on the video format", func(t *testing.T) {
+		jp := jp
+		jp.VideoFormat = "VP8"
+		def, err := newReprocessDef("in-dry.mkv", "out-wet.mkv", jp, false, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(def, "vp8enc name=video_encoder_rec") || !strings.Contains(def, "matroskamux") {
+			t.Errorf("unexpected VP8 pipeline:\n%v", def)
+		}
+		if strings.Contains(def, "opusenc") {
+			t.Errorf("audio branch rendered without audio:\n%v", def)
+		}
+	})
+
+	t.Run("Audio only files use the audio muxer", func(t *testing.T) {
+		def, err := newReprocessDef("in-audio-dry.ogg", "out-audio-wet.ogg", jp, true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(def, gstConfig.Opus.Muxer+" name=wet_muxer") || strings.Contains(def, "video_encoder_rec") {
+			t.Errorf("unexpected audio only pipeline:\n%v", def)
+		}
+	})
+
+	t.Run("Rejects another extension", func(t *testing.T) {
+		if _, err := newReprocessDef("in-dry.mp4", "out-wet.mkv", jp, true, true); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestFormatControlEvents(t *testing.T) {
+	got := formatControlEvents([]types.ControlEvent{
+		{Name: "b", Property: "pitch", At: 2 * time.Second, Value: 1.5},
+		{Name: "a", Property: "pitch", At: -time.Second, Duration: 500 * time.Millisecond, Value: 0.5},
+	})
+	want := fxNamePrefix + "a;pitch;0;500000000;0.5\n" + fxNamePrefix + "b;pitch;2000000000;0;1.5\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,132 @@
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0
+#include "gst.h"
+*/
+import "C"
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/pion/rtcp"
+)
+
+// seconds between NTP epoch (1900) and Unix epoch (1970)
+const ntpEpochOffset = 2208988800
+
+// RTP timestamp of the first packet pushed to the pipeline and its running time
+type rtpAnchor struct {
+	rtpTime     uint32
+	runningTime int64
+}
+
+func ntpToTime(ntp uint64) time.Time {
+	seconds := int64(ntp>>32) - ntpEpochOffset
+	nanoseconds := (int64(ntp&0xffffffff) * int64(time.Second)) >> 32
+	return time.Unix(seconds, nanoseconds)
+}
+
+func clockRate(kind string) int {
+	if kind == "audio" {
+		return 48000
+	}
+	return 90000
+}
+
+// keeps sender reports found in a compound RTCP packet, if the pipeline records
+func (p *Pipeline) addSenderReports(kind string, buffer []byte) {
+	p.syncMu.Lock()
+	recording := len(p.filesinks) > 0
+	p.syncMu.Unlock()
+	if !recording {
+		return
+	}
+
+	packets, err := rtcp.Unmarshal(buffer)
+	if err != nil {
+		return
+	}
+	receivedAt := time.Now()
+	for _, packet := range packets {
+		if sr, ok := packet.(*rtcp.SenderReport); ok {
+			p.syncMu.Lock()
+			p.senderReports = append(p.senderReports, types.SenderReport{
+				Kind:       kind,
+				SSRC:       sr.SSRC,
+				ReceivedAt: receivedAt,
+				NTPTime:    ntpToTime(sr.NTPTime),
+				RTPTime:    sr.RTPTime,
+				ClockRate:  clockRate(kind),
+			})
+			p.syncMu.Unlock()
+		}
+	}
+}
+
+// keeps the first RTP timestamp of each kind with the running time of its push, to
+// estimate the RTP timestamps of buffers reaching muxers
+func (p *Pipeline) anchorRTP(kind string, buffer []byte) {
+	if p.anchored[kind].Load() || len(buffer) < 8 {
+		return
+	}
+	runningTime := C.gstRunningTime(p.cPipeline)
+	if runningTime == ^C.GstClockTime(0) { // GST_CLOCK_TIME_NONE
+		return
+	}
+	p.syncMu.Lock()
+	p.rtpAnchors[kind] = rtpAnchor{binary.BigEndian.Uint32(buffer[4:8]), int64(runningTime)}
+	p.syncMu.Unlock()
+	p.anchored[kind].Store(true)
+}
+
+func (p *Pipeline) setStreamFirstBuffer(filesink string, s types.StreamTiming, wallTime time.Time) {
+	s.CapturedAt = wallTime.Add(-time.Duration(s.ClockTime - (s.BaseTime + s.RunningTime)))
+
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	if file, ok := p.filesinks[filesink]; ok {
+		p.streams[file] = append(p.streams[file], s)
+	}
+}
+
+// sender wall clock of the media captured at running time, estimated from the RTP anchor
+// and the first sender report of the same kind
+func senderTimeAt(kind string, runningTime int64, anchor rtpAnchor, reports []types.SenderReport) (t time.Time, ok bool) {
+	for _, r := range reports {
+		if r.Kind != kind {
+			continue
+		}
+		rate := int64(r.ClockRate)
+		rtpTime := anchor.rtpTime + uint32((runningTime-anchor.runningTime)*rate/int64(time.Second))
+		// signed difference handles timestamp wraparound
+		ticks := int64(int32(rtpTime - r.RTPTime))
+		return r.NTPTime.Add(time.Duration(ticks * int64(time.Second) / rate)), true
+	}
+	return
+}
+
+// SyncInfo returns a copy of the synchronization data gathered so far
+func (p *Pipeline) SyncInfo() types.SyncInfo {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	info := types.SyncInfo{
+		StartedAt:     p.StartedAt,
+		Streams:       make(map[string][]types.StreamTiming),
+		SenderReports: append([]types.SenderReport{}, p.senderReports...),
+	}
+	for file, streams := range p.streams {
+		for _, s := range streams {
+			if anchor, ok := p.rtpAnchors[s.Kind]; ok {
+				if t, ok := senderTimeAt(s.Kind, s.RunningTime, anchor, info.SenderReports); ok {
+					s.SenderCapturedAt = &t
+				}
+			}
+			info.Streams[file] = append(info.Streams[file], s)
+		}
+	}
+	return info
+}
@@ -0,0 +1,52 @@
+package gst
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func TestStreamTiming(t *testing.T) {
+	t.Run("Capture time from the pipeline clock", func(t *testing.T) {
+		p := &Pipeline{
+			filesinks: map[string]string{"dry_video_filesink": "video.mkv"},
+			streams:   make(map[string][]types.StreamTiming),
+		}
+		wallTime := time.Date(2023, 3, 1, 10, 0, 5, 0, time.UTC)
+		// the buffer reaches the muxer 300ms after its running time
+		p.setStreamFirstBuffer("dry_video_filesink", types.StreamTiming{Kind: "video", RunningTime: 2e9, BaseTime: 10e9, ClockTime: 12.3e9}, wallTime)
+		p.setStreamFirstBuffer("unknown_filesink", types.StreamTiming{Kind: "audio"}, wallTime)
+
+		if len(p.streams) != 1 || len(p.streams["video.mkv"]) != 1 {
+			t.Fatalf("unexpected streams %+v", p.streams)
+		}
+		if got, want := p.streams["video.mkv"][0].CapturedAt, wallTime.Add(-300*time.Millisecond); !got.Equal(want) {
+			t.Errorf("got %v but expected %v", got, want)
+		}
+	})
+
+	t.Run("Sender time from RTCP SR", func(t *testing.T) {
+		ntpTime := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+		reports := []types.SenderReport{
+			{Kind: "video", RTPTime: 0, NTPTime: ntpTime.Add(time.Hour), ClockRate: 90000},
+			{Kind: "audio", RTPTime: 1000 + 48000, NTPTime: ntpTime, ClockRate: 48000},
+		}
+		anchor := rtpAnchor{rtpTime: 1000, runningTime: 0}
+
+		got, ok := senderTimeAt("audio", 2e9, anchor, reports)
+		if !ok || !got.Equal(ntpTime.Add(time.Second)) {
+			t.Errorf("got %v but expected %v", got, ntpTime.Add(time.Second))
+		}
+		// RTP timestamps wrap around
+		wrapped := rtpAnchor{rtpTime: 0xffffffff - 47999, runningTime: 0}
+		reports[1].RTPTime = 0xffffffff - 47999
+		got, _ = senderTimeAt("audio", 2e9, wrapped, reports)
+		if !got.Equal(ntpTime.Add(2 * time.Second)) {
+			t.Errorf("got %v but expected %v", got, ntpTime.Add(2*time.Second))
+		}
+		if _, ok := senderTimeAt("video", 0, anchor, reports[1:]); ok {
+			t.Error("no video report, sender time can't be known")
+		}
+	})
+}
@@ -19,7 +19,7 @@ import (
 	"github.com/ducksouplab/ducksoup/types"
 )
 
-func newPipelineDef(jp types.JoinPayload, dataFolder, filePrefix string, videoOptions, audioOptions mediaOptions) string {
+func newPipelineDef(jp types.JoinPayload, receiverSuffix, dataFolder, filePrefix string, videoOptions, audioOptions mediaOptions) string {
 
 	// shape template data
 	data := struct {
@@ -34,6 +34,7 @@ func newPipelineDef(jp types.JoinPayload, dataFolder, filePrefix string, videoOp
 		Framerate  int
 		RTPBin     string
 		FinalQueue string
+		Lossless   losslessOptions
 	}{
 		gstConfig.Shared.Queue,
 		videoOptions,
@@ -46,13 +47,14 @@ func newPipelineDef(jp types.JoinPayload, dataFolder, filePrefix string, videoOp
 		"rtpbin name=rtpbin latency=" + strconv.Itoa(env.JitterBuffer),
 		// important: max-size-time greater than the jitter buffer latency to prevent audio glitches
 		"queue max-size-buffers=0 max-size-bytes=0 max-size-time=" + strconv.Itoa(env.JitterBuffer+100) + "000000",
+		newLosslessOptions(jp, videoOptions, dataFolder+"/recordings/"+filePrefix+"-"),
 	}
 
 	// render pipeline from template
 	var buf bytes.Buffer
 	var templateName string
 	if jp.AudioOnly {
-		if env.NoRecording {
+		if env.NoRecording || jp.RecordingMode == "none" {
 			templateName = "audio_only_no_recording"
 		} else {
 			// audio only default
@@ -88,18 +90,21 @@ func newPipelineDef(jp types.JoinPayload, dataFolder, filePrefix string, videoOp
 	if jp.RecordingMode != "bypass" {
 		contents := []byte("// DuckSoup#" + config.BackendVersion + " Pipeline#" + templateName + "\n\n")
 		contents = append(contents, buf.Bytes()...)
-		os.WriteFile(dataFolder+"/pipeline-u-"+jp.UserId+"-"+time.Now().Format("20060102-150405.000")+".txt", contents, 0666)
+		os.WriteFile(dataFolder+"/pipeline-u-"+jp.UserId+receiverSuffix+"-"+time.Now().Format("20060102-150405.000")+".txt", contents, 0666)
 	}
 
-	// process lines (trim and remove blank lines)
+	return formatPipelineDef(buf.String())
+}
+
+// trims lines and removes blank ones
+func formatPipelineDef(def string) string {
 	var formattedBuf bytes.Buffer
-	scanner := bufio.NewScanner(strings.NewReader(buf.String()))
+	scanner := bufio.NewScanner(strings.NewReader(def))
 	for scanner.Scan() {
 		trimmed := strings.TrimSpace(scanner.Text())
 		if len(trimmed) > 0 {
 			formattedBuf.WriteString(trimmed + "\n")
 		}
 	}
-
 	return formattedBuf.String()
 }
@@ -0,0 +1,43 @@
+package gst
+
+import (
+	"strings"
+	"sync"
+)
+
+// last face tracking state reported by mozza (see DUCKSOUP_GST_TRACKING), per
+// mozza user-id (r-<interaction random id>-u-<user id>)
+var (
+	trackingMu    sync.Mutex
+	trackingIndex = make(map[string]bool)
+)
+
+func setTracking(mozzaUserId string, tracking bool) {
+	trackingMu.Lock()
+	defer trackingMu.Unlock()
+
+	trackingIndex[mozzaUserId] = tracking
+}
+
+// Tracking returns the last face tracking state of a user, ok is false if mozza has
+// not reported it (tracking logs are only processed if DUCKSOUP_GST_TRACKING is set)
+func Tracking(iRandomId, userId string) (tracking, ok bool) {
+	trackingMu.Lock()
+	defer trackingMu.Unlock()
+
+	tracking, ok = trackingIndex["r-"+iRandomId+"-u-"+userId]
+	return
+}
+
+// DeleteTracking forgets tracking states of an interaction
+func DeleteTracking(iRandomId string) {
+	trackingMu.Lock()
+	defer trackingMu.Unlock()
+
+	prefix := "r-" + iRandomId + "-u-"
+	for key := range trackingIndex {
+		if strings.HasPrefix(key, prefix) {
+			delete(trackingIndex, key)
+		}
+	}
+}
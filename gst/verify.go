@@ -0,0 +1,101 @@
+package gst
+
+/*
+#cgo pkg-config: gstreamer-1.0
+#include "gst.h"
+*/
+import "C"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/ducksouplab/ducksoup/helpers"
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+const inspectReportLength = 4096
+
+func inspectFile(file string, timeout time.Duration) (streams []types.StreamReport, err string) {
+	cFile := C.CString(file)
+	defer C.free(unsafe.Pointer(cFile))
+	cReport := (*C.char)(C.malloc(inspectReportLength))
+	defer C.free(unsafe.Pointer(cReport))
+
+	cErr := C.gstInspectFile(cFile, C.int(timeout.Seconds()), cReport, inspectReportLength)
+	if cErr != nil {
+		defer C.free(unsafe.Pointer(cErr))
+		err = C.GoString(cErr)
+	}
+
+	for _, line := range strings.Split(C.GoString(cReport), "\n") {
+		fields := strings.Split(line, ";")
+		if len(fields) != 5 {
+			continue
+		}
+		s := types.StreamReport{Codec: fields[0]}
+		s.Width, _ = strconv.Atoi(fields[1])
+		s.Height, _ = strconv.Atoi(fields[2])
+		s.FirstPTS, _ = strconv.ParseInt(fields[3], 10, 64)
+		s.LastPTS, _ = strconv.ParseInt(fields[4], 10, 64)
+		streams = append(streams, s)
+	}
+	return
+}
+
+// VerifyFile checks that a recording can be opened and parsed till its end,
+// and describes its streams. It should be called once the file is closed
+func VerifyFile(file string) (r types.FileReport) {
+	r.File = file
+	r.Streams = []types.StreamReport{}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		r.Error = "file_not_found"
+		return
+	}
+	r.Size = info.Size()
+	if r.Size == 0 {
+		r.Error = "empty_file"
+		return
+	}
+	if r.SHA256, err = helpers.FileSHA256(file); err != nil {
+		r.Error = "file_not_readable"
+		return
+	}
+
+	streams, inspectErr := inspectFile(file, 5*time.Minute)
+	if streams != nil {
+		r.Streams = streams
+	}
+	if len(inspectErr) > 0 {
+		r.Error = inspectErr
+		return
+	}
+	if len(streams) == 0 {
+		r.Error = "no_stream"
+		return
+	}
+
+	var first, last int64 = -1, -1
+	for _, s := range streams {
+		if s.FirstPTS < 0 {
+			continue
+		}
+		if first < 0 || s.FirstPTS < first {
+			first = s.FirstPTS
+		}
+		if s.LastPTS > last {
+			last = s.LastPTS
+		}
+	}
+	if first < 0 {
+		r.Error = "no_timestamped_buffer"
+		return
+	}
+	r.Duration = (last - first) / int64(time.Millisecond)
+	r.Valid = true
+	return
+}
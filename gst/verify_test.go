@@ -0,0 +1,48 @@
+package gst
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyFile(t *testing.T) {
+	t.Run("Missing and empty files", func(t *testing.T) {
+		folder := t.TempDir()
+		if r := VerifyFile(folder + "/missing.mp4"); r.Valid || r.Error != "file_not_found" {
+			t.Errorf("unexpected report for missing file: %+v", r)
+		}
+		empty := folder + "/empty.mp4"
+		os.WriteFile(empty, nil, 0644)
+		if r := VerifyFile(empty); r.Valid || r.Error != "empty_file" {
+			t.Errorf("unexpected report for empty file: %+v", r)
+		}
+	})
+
+	t.Run("Known-good and truncated files", func(t *testing.T) {
+		if missing := MissingElements([]string{"videotestsrc", "x264enc", "mp4mux", "parsebin"}); len(missing) > 0 {
+			t.Skipf("GStreamer elements not available: %v", missing)
+		}
+		file := filepath.Join(t.TempDir(), "good.mp4")
+		// 2 seconds at 25 fps, not fragmented: the moov atom is written last
+		if err := runPipeline("videotestsrc num-buffers=50 ! video/x-raw,width=320,height=240,framerate=25/1 ! x264enc ! h264parse ! mp4mux ! filesink location="+file, time.Minute); err != nil {
+			t.Fatalf("can't write test file: %v", err)
+		}
+
+		r := VerifyFile(file)
+		if !r.Valid || len(r.Streams) != 1 || r.Streams[0].Codec != "video/x-h264" || r.Streams[0].Width != 320 {
+			t.Fatalf("unexpected report for known-good file: %+v", r)
+		}
+		if r.Duration < 1900 || r.Duration > 2100 {
+			t.Errorf("unexpected duration %v ms", r.Duration)
+		}
+
+		truncated := filepath.Join(filepath.Dir(file), "truncated.mp4")
+		data, _ := os.ReadFile(file)
+		os.WriteFile(truncated, data[:len(data)/2], 0644)
+		if r := VerifyFile(truncated); r.Valid || len(r.Error) == 0 {
+			t.Errorf("truncated file should be invalid: %+v", r)
+		}
+	})
+}
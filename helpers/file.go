@@ -2,7 +2,10 @@ package helpers
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -53,3 +56,18 @@ func EnsureDir(path string) {
 		os.MkdirAll(path, 0775)
 	}
 }
+
+// FileSHA256 returns the hex encoded SHA-256 of the file content
+func FileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"regexp"
 	"sync"
+	"sync/atomic"
 
 	"github.com/ducksouplab/ducksoup/env"
 	"github.com/ducksouplab/ducksoup/helpers"
@@ -20,7 +21,7 @@ var password string
 
 var turnIP net.IP
 var server *turn.Server
-var started bool
+var started atomic.Bool
 var userStore struct {
 	mu    sync.Mutex
 	index map[string][]byte
@@ -47,7 +48,7 @@ func init() {
 // Contains STUN servers from DUCKSOUP_STUN_SERVER_URLS env var + TURN server with credentials if enabled
 func GetICEServers(u string) []webrtc.ICEServer {
 	iceServers := GetDefaultSTUNServers()
-	if started {
+	if started.Load() {
 		// todo store hash
 		iceServers = append(iceServers, webrtc.ICEServer{
 			URLs:       []string{"turn:" + env.TurnAddress + ":" + env.TurnPort},
@@ -58,8 +59,18 @@ func GetICEServers(u string) []webrtc.ICEServer {
 	return iceServers
 }
 
+// TURNEnabled tells if the embedded TURN server is configured
+func TURNEnabled() bool {
+	return turnIP != nil && len(env.TurnAddress) > 0 && len(env.TurnPort) > 0
+}
+
+// TURNStarted tells if the embedded TURN server has started (and not been stopped)
+func TURNStarted() bool {
+	return started.Load()
+}
+
 func StartTURN() {
-	if turnIP == nil || len(env.TurnAddress) == 0 || len(env.TurnPort) == 0 {
+	if !TURNEnabled() {
 		log.Info().Str("context", "app").Msg("turn_server_disabled")
 		return
 	}
@@ -99,12 +110,12 @@ func StartTURN() {
 		log.Error().Str("context", "app").Err(err).Msg("turn_server_error")
 		return
 	}
-	started = true
+	started.Store(true)
 	log.Info().Str("context", "app").Msg("turn_server_started")
 }
 
 func StopTURN() {
-	if started {
+	if started.Swap(false) {
 		server.Close()
 	}
 }
@@ -0,0 +1,48 @@
+// Package jobs runs post-processing of recordings in the background, with a bounded
+// number of workers and a queue persisted on disk to survive restarts
+package jobs
+
+import (
+	"time"
+)
+
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed" // after MaxAttempts
+)
+
+const (
+	MaxAttempts    = 3
+	RetryDelay     = 30 * time.Second // multiplied by the number of attempts
+	MaxPendingJobs = 1000
+	// ended (done or failed) jobs are deleted after EndedJobRetention, and the oldest
+	// ones when there are more than MaxEndedJobs
+	EndedJobRetention = 30 * 24 * time.Hour
+	MaxEndedJobs      = 1000
+)
+
+// Handler processes a job, the job is retried if an error is returned
+type Handler func(j Job) error
+
+// Validator checks a payload before it is enqueued and before it is run (for jobs
+// persisted by previous versions)
+type Validator func(payload map[string]string) error
+
+type Job struct {
+	Id        string            `json:"id"`
+	Kind      string            `json:"kind"`
+	Payload   map[string]string `json:"payload"`
+	DependsOn string            `json:"dependsOn,omitempty"` // id of a job that must have ended (done or failed) before this one runs
+	Status    string            `json:"status"`
+	Attempts  int               `json:"attempts"`
+	Error     string            `json:"error,omitempty"` // error of the last attempt
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+	RunAfter  time.Time         `json:"runAfter"`
+}
+
+func (j Job) hasEnded() bool {
+	return j.Status == StatusDone || j.Status == StatusFailed
+}
@@ -0,0 +1,371 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/helpers"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+var jobsFolder = env.DataRoot + "/jobs"
+
+// Folder is where jobs are persisted, inside env.DataRoot
+func Folder() string {
+	return jobsFolder
+}
+
+var queueSingleton *queue
+
+type queue struct {
+	sync.Mutex
+	index      map[string]*Job
+	handlers   map[string]Handler
+	validators map[string]Validator
+	notifyCh   chan struct{}
+	// set by Start
+	started bool
+	busy    func() bool
+}
+
+func init() {
+	queueSingleton = newQueue()
+}
+
+func newQueue() *queue {
+	return &queue{
+		index:      make(map[string]*Job),
+		handlers:   make(map[string]Handler),
+		validators: make(map[string]Validator),
+		notifyCh:   make(chan struct{}, 1),
+	}
+}
+
+func jobFile(id string) string {
+	return jobsFolder + "/" + id + ".json"
+}
+
+// not guarded, job is written to a temporary file then renamed
+func persist(j *Job) {
+	formatted, err := json.MarshalIndent(j, "", "  ")
+	if err == nil {
+		tmp := jobFile(j.Id) + ".tmp"
+		if err = os.WriteFile(tmp, append(formatted, '\n'), 0644); err == nil {
+			err = os.Rename(tmp, jobFile(j.Id))
+		}
+	}
+	if err != nil {
+		log.Error().Str("context", "jobs").Str("job", j.Id).Err(err).Msg("job_persist_failed")
+	}
+}
+
+func (q *queue) load() {
+	files, _ := filepath.Glob(jobsFolder + "/*.json")
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		j := &Job{}
+		if err := json.Unmarshal(data, j); err != nil {
+			log.Error().Str("context", "jobs").Str("file", file).Err(err).Msg("job_load_failed")
+			continue
+		}
+		if j.Status == StatusRunning {
+			// interrupted by a restart
+			j.Status = StatusPending
+			persist(j)
+		}
+		q.index[j.Id] = j
+	}
+	q.prune()
+	log.Info().Str("context", "jobs").Int("value", len(q.index)).Msg("jobs_loaded")
+}
+
+// deletes ended jobs after EndedJobRetention, and the oldest ones above MaxEndedJobs
+func (q *queue) prune() {
+	ended := []*Job{}
+	for _, j := range q.index {
+		if j.hasEnded() {
+			ended = append(ended, j)
+		}
+	}
+	sort.Slice(ended, func(a, b int) bool {
+		return ended[a].UpdatedAt.After(ended[b].UpdatedAt)
+	})
+	limit := time.Now().Add(-EndedJobRetention)
+	for index, j := range ended {
+		if index < MaxEndedJobs && j.UpdatedAt.After(limit) {
+			continue
+		}
+		if err := os.Remove(jobFile(j.Id)); err != nil && !os.IsNotExist(err) {
+			log.Error().Str("context", "jobs").Str("job", j.Id).Err(err).Msg("job_prune_failed")
+			continue
+		}
+		delete(q.index, j.Id)
+	}
+}
+
+func (q *queue) notify() {
+	select {
+	case q.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (q *queue) pendingCount() (count int) {
+	for _, j := range q.index {
+		if j.Status == StatusPending {
+			count++
+		}
+	}
+	return
+}
+
+// oldest pending job that may run now, marked as running
+func (q *queue) next() (j Job, ok bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	candidates := []*Job{}
+	now := time.Now()
+	for _, j := range q.index {
+		if j.Status != StatusPending || j.RunAfter.After(now) {
+			continue
+		}
+		if _, ok := q.handlers[j.Kind]; !ok {
+			continue
+		}
+		if dep, ok := q.index[j.DependsOn]; ok && !dep.hasEnded() {
+			continue
+		}
+		candidates = append(candidates, j)
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	sort.Slice(candidates, func(a, b int) bool {
+		return candidates[a].CreatedAt.Before(candidates[b].CreatedAt)
+	})
+	next := candidates[0]
+	next.Status = StatusRunning
+	next.Attempts++
+	next.UpdatedAt = now
+	persist(next)
+	return *next, true
+}
+
+func (q *queue) end(id string, err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	j, ok := q.index[id]
+	if !ok {
+		return
+	}
+	j.UpdatedAt = time.Now()
+	if err == nil {
+		j.Status = StatusDone
+		j.Error = ""
+		log.Info().Str("context", "jobs").Str("job", j.Id).Str("kind", j.Kind).Int("attempts", j.Attempts).Msg("job_done")
+	} else {
+		j.Error = err.Error()
+		if j.Attempts >= MaxAttempts {
+			j.Status = StatusFailed
+			log.Error().Str("context", "jobs").Str("job", j.Id).Str("kind", j.Kind).Int("attempts", j.Attempts).Err(err).Msg("job_failed")
+		} else {
+			j.Status = StatusPending
+			j.RunAfter = j.UpdatedAt.Add(time.Duration(j.Attempts) * RetryDelay)
+			log.Error().Str("context", "jobs").Str("job", j.Id).Str("kind", j.Kind).Int("attempts", j.Attempts).Err(err).Msg("job_retry_scheduled")
+		}
+	}
+	persist(j)
+	if j.hasEnded() {
+		q.prune()
+	}
+}
+
+func (q *queue) run(j Job) (err error) {
+	q.Lock()
+	handler, validate := q.handlers[j.Kind], q.validators[j.Kind]
+	q.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	log.Info().Str("context", "jobs").Str("job", j.Id).Str("kind", j.Kind).Int("attempts", j.Attempts).Msg("job_started")
+	if validate != nil {
+		if err = validate(j.Payload); err != nil {
+			return
+		}
+	}
+	return handler(j)
+}
+
+func (q *queue) loopWorker() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-q.notifyCh:
+		}
+		// live pipelines have priority
+		if q.busy != nil && q.busy() {
+			continue
+		}
+		if j, ok := q.next(); ok {
+			q.end(j.Id, q.run(j))
+			// other jobs may be ready
+			q.notify()
+		}
+	}
+}
+
+// API
+
+// Register declares how jobs of the given kind are processed (to be called before Start),
+// v may be nil
+func Register(kind string, h Handler, v Validator) {
+	queueSingleton.Lock()
+	defer queueSingleton.Unlock()
+
+	queueSingleton.handlers[kind] = h
+	if v != nil {
+		queueSingleton.validators[kind] = v
+	}
+}
+
+// Start loads persisted jobs and launches env.JobWorkers workers. busy (may be nil)
+// is checked before starting each job, jobs are deferred while it returns true
+func Start(busy func() bool) {
+	q := queueSingleton
+	helpers.EnsureDir(jobsFolder)
+
+	q.Lock()
+	q.load()
+	q.busy = busy
+	q.started = true
+	q.Unlock()
+
+	for w := 0; w < env.JobWorkers; w++ {
+		go q.loopWorker()
+	}
+	log.Info().Str("context", "jobs").Int("value", env.JobWorkers).Msg("job_workers_started")
+}
+
+// Enqueue adds and persists a job, dependsOn may be empty
+func Enqueue(kind string, payload map[string]string, dependsOn string) (Job, error) {
+	q := queueSingleton
+	q.Lock()
+	defer q.Unlock()
+
+	if !q.started {
+		return Job{}, errors.New("jobs_not_started")
+	}
+	if _, ok := q.handlers[kind]; !ok {
+		return Job{}, errors.New("unknown_job_kind")
+	}
+	if validate, ok := q.validators[kind]; ok {
+		if err := validate(payload); err != nil {
+			return Job{}, err
+		}
+	}
+	if q.pendingCount() >= MaxPendingJobs {
+		return Job{}, errors.New("job_queue_full")
+	}
+	now := time.Now()
+	j := &Job{
+		Id:        uuid.New().String(),
+		Kind:      kind,
+		Payload:   payload,
+		DependsOn: dependsOn,
+		Status:    StatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+		RunAfter:  now,
+	}
+	q.index[j.Id] = j
+	persist(j)
+	log.Info().Str("context", "jobs").Str("job", j.Id).Str("kind", kind).Msg("job_enqueued")
+
+	q.notify()
+	return *j, nil
+}
+
+// List returns jobs (filtered by status if not empty) from the oldest to the most recent
+func List(status string) []Job {
+	q := queueSingleton
+	q.Lock()
+	defer q.Unlock()
+
+	list := []Job{}
+	for _, j := range q.index {
+		if len(status) == 0 || j.Status == status {
+			list = append(list, *j)
+		}
+	}
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].CreatedAt.Before(list[b].CreatedAt)
+	})
+	return list
+}
+
+func Get(id string) (Job, bool) {
+	q := queueSingleton
+	q.Lock()
+	defer q.Unlock()
+
+	j, ok := q.index[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// Retry resets a failed job so that it is run again
+func Retry(id string) (Job, error) {
+	q := queueSingleton
+	q.Lock()
+	defer q.Unlock()
+
+	j, ok := q.index[id]
+	if !ok {
+		return Job{}, errors.New("job_not_found")
+	}
+	if j.Status != StatusFailed {
+		return Job{}, errors.New("job_not_failed")
+	}
+	j.Status = StatusPending
+	j.Attempts = 0
+	j.RunAfter = time.Now()
+	j.UpdatedAt = j.RunAfter
+	persist(j)
+
+	q.notify()
+	return *j, nil
+}
+
+// Kinds returns registered job kinds
+func Kinds() (kinds []string) {
+	q := queueSingleton
+	q.Lock()
+	defer q.Unlock()
+
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return
+}
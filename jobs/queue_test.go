@@ -0,0 +1,163 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// replaces the queue singleton by a started queue (without workers) persisting to a
+// temporary folder, jobs are then run step by step with runNext
+func setupQueue(t *testing.T) *queue {
+	t.Helper()
+	previousQueue, previousFolder := queueSingleton, jobsFolder
+	t.Cleanup(func() {
+		queueSingleton, jobsFolder = previousQueue, previousFolder
+	})
+	jobsFolder = t.TempDir()
+	queueSingleton = newQueue()
+	queueSingleton.started = true
+	return queueSingleton
+}
+
+// runs the next job ready, if any
+func (q *queue) runNext() (j Job, ok bool) {
+	if j, ok = q.next(); ok {
+		q.end(j.Id, q.run(j))
+	}
+	return
+}
+
+func TestQueue(t *testing.T) {
+	t.Run("Enqueue validates kind and payload", func(t *testing.T) {
+		setupQueue(t)
+		Register("test", func(j Job) error { return nil }, func(payload map[string]string) error {
+			if len(payload["file"]) == 0 {
+				return errors.New("missing_payload_file")
+			}
+			return nil
+		})
+
+		if _, err := Enqueue("unknown", nil, ""); err == nil || err.Error() != "unknown_job_kind" {
+			t.Errorf("unexpected error %v", err)
+		}
+		if _, err := Enqueue("test", map[string]string{}, ""); err == nil || err.Error() != "missing_payload_file" {
+			t.Errorf("unexpected error %v", err)
+		}
+		j, err := Enqueue("test", map[string]string{"file": "a"}, "")
+		if err != nil || j.Status != StatusPending {
+			t.Fatalf("unexpected job %+v (%v)", j, err)
+		}
+	})
+
+	t.Run("Persist and reload", func(t *testing.T) {
+		q := setupQueue(t)
+		Register("test", func(j Job) error { return nil }, nil)
+		pending, _ := Enqueue("test", map[string]string{"file": "a"}, "")
+		running, _ := Enqueue("test", map[string]string{"file": "b"}, "")
+		q.Lock()
+		q.index[running.Id].Status = StatusRunning
+		persist(q.index[running.Id])
+		q.Unlock()
+
+		reloaded := newQueue()
+		reloaded.load()
+		if len(reloaded.index) != 2 || reloaded.index[pending.Id].Payload["file"] != "a" {
+			t.Fatalf("unexpected reloaded jobs %+v", reloaded.index)
+		}
+		// interrupted by a restart
+		if status := reloaded.index[running.Id].Status; status != StatusPending {
+			t.Errorf("running job reloaded as %v", status)
+		}
+	})
+
+	t.Run("Retry up to MaxAttempts", func(t *testing.T) {
+		q := setupQueue(t)
+		calls := 0
+		Register("test", func(j Job) error {
+			calls++
+			return errors.New("always_failing")
+		}, nil)
+		j, _ := Enqueue("test", nil, "")
+
+		for attempt := 1; attempt <= MaxAttempts; attempt++ {
+			if _, ok := q.runNext(); !ok {
+				t.Fatalf("attempt #%v not run", attempt)
+			}
+			current, _ := Get(j.Id)
+			if current.Attempts != attempt || current.Error != "always_failing" {
+				t.Fatalf("unexpected job after attempt #%v: %+v", attempt, current)
+			}
+			if attempt < MaxAttempts {
+				if current.Status != StatusPending || !current.RunAfter.After(time.Now()) {
+					t.Fatalf("retry not scheduled: %+v", current)
+				}
+				// skip the retry delay
+				q.Lock()
+				q.index[j.Id].RunAfter = time.Now()
+				q.Unlock()
+			}
+		}
+		if current, _ := Get(j.Id); current.Status != StatusFailed || calls != MaxAttempts {
+			t.Errorf("unexpected job %+v after %v calls", current, calls)
+		}
+		if _, ok := q.runNext(); ok {
+			t.Error("failed job should not run again")
+		}
+
+		// until it is retried manually
+		if _, err := Retry(j.Id); err != nil {
+			t.Fatal(err)
+		}
+		if next, ok := q.runNext(); !ok || next.Id != j.Id || next.Attempts != 1 {
+			t.Errorf("unexpected retried job %+v", next)
+		}
+	})
+
+	t.Run("Dependencies are run first", func(t *testing.T) {
+		q := setupQueue(t)
+		order := []string{}
+		Register("test", func(j Job) error {
+			order = append(order, j.Payload["name"])
+			return nil
+		}, nil)
+		first, _ := Enqueue("test", map[string]string{"name": "first"}, "")
+		// a job depending on a job enqueued later
+		dependent, _ := Enqueue("test", map[string]string{"name": "dependent"}, "")
+		last, _ := Enqueue("test", map[string]string{"name": "last"}, first.Id)
+		q.Lock()
+		q.index[dependent.Id].DependsOn = last.Id
+		q.Unlock()
+
+		for {
+			if _, ok := q.runNext(); !ok {
+				break
+			}
+		}
+		if len(order) != 3 || order[0] != "first" || order[1] != "last" || order[2] != "dependent" {
+			t.Errorf("unexpected order %v", order)
+		}
+	})
+
+	t.Run("Prune ended jobs", func(t *testing.T) {
+		q := setupQueue(t)
+		Register("test", func(j Job) error { return nil }, nil)
+		old, _ := Enqueue("test", nil, "")
+		recent, _ := Enqueue("test", nil, "")
+		pending, _ := Enqueue("test", nil, "")
+		q.Lock()
+		for _, j := range []*Job{q.index[old.Id], q.index[recent.Id]} {
+			j.Status = StatusDone
+			j.UpdatedAt = time.Now()
+		}
+		q.index[old.Id].UpdatedAt = time.Now().Add(-EndedJobRetention - time.Hour)
+		q.prune()
+		q.Unlock()
+
+		for id, kept := range map[string]bool{old.Id: false, recent.Id: true, pending.Id: true} {
+			if _, ok := Get(id); ok != kept {
+				t.Errorf("job %v kept: %v", id, ok)
+			}
+		}
+	})
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/ducksouplab/ducksoup/env"
 	"github.com/ducksouplab/ducksoup/frontbuild"
 	"github.com/ducksouplab/ducksoup/gst"
 	"github.com/ducksouplab/ducksoup/helpers"
 	"github.com/ducksouplab/ducksoup/iceservers"
+	"github.com/ducksouplab/ducksoup/jobs"
+	"github.com/ducksouplab/ducksoup/replay"
+	"github.com/ducksouplab/ducksoup/reprocess"
 	"github.com/ducksouplab/ducksoup/server"
 	"github.com/rs/zerolog/log"
 )
@@ -45,9 +49,28 @@ func logState() {
 	log.Info().Str("context", "init").Bool("value", env.ForceOverlay).Msg("DUCKSOUP_FORCE_OVERLAY")
 	log.Info().Str("context", "init").Bool("value", env.NoRecording).Msg("DUCKSOUP_NO_RECORDING")
 	log.Info().Str("context", "init").Str("value", fmt.Sprintf("%v", env.STUNServerURLS)).Msg("DUCKSOUP_STUN_SERVER_URLS")
+	log.Info().Str("context", "init").Int("value", env.JobWorkers).Msg("DUCKSOUP_JOB_WORKERS")
+	log.Info().Str("context", "init").Int("value", env.JobMaxLivePipelines).Msg("DUCKSOUP_JOB_MAX_LIVE_PIPELINES")
+	log.Info().Str("context", "init").Int("value", env.MinFreeDiskMB).Msg("DUCKSOUP_MIN_FREE_DISK_MB")
 }
 
 func main() {
+	// subcommands
+	if len(os.Args) > 1 && os.Args[1] == "reprocess" {
+		if err := reprocess.Run(os.Args[2:]); err != nil {
+			log.Error().Str("context", "reprocess").Err(err).Msg("reprocess_failed")
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replay.Run(os.Args[2:]); err != nil {
+			log.Error().Str("context", "replay").Err(err).Msg("replay_failed")
+			os.Exit(1)
+		}
+		return
+	}
+
 	// always build front (in watch mode or not, depending on env.Mode value, see front/build.go)
 	frontbuild.Build()
 
@@ -63,6 +86,11 @@ func main() {
 		// log initial state
 		logState()
 
+		// launch post-processing workers, deferring jobs while there are too many live pipelines
+		jobs.Start(func() bool {
+			return env.JobMaxLivePipelines >= 0 && gst.LivePipelineCount() > env.JobMaxLivePipelines
+		})
+
 		// launch http (with websockets) server
 		go server.Start()
 
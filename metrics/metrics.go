@@ -0,0 +1,226 @@
+// Package metrics exposes counters, gauges and summaries in the Prometheus text
+// exposition format (version 0.0.4), without depending on the Prometheus client library
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// separates label values in map keys
+const keySeparator = "\xff"
+
+type family interface {
+	write(w io.Writer)
+}
+
+var registry = struct {
+	sync.Mutex
+	families map[string]family
+}{families: make(map[string]family)}
+
+func register(name string, f family) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.families[name]; ok {
+		panic("metrics: " + name + " already registered")
+	}
+	registry.families[name] = f
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatLabels(labels []string, key string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	values := strings.Split(key, keySeparator)
+	pairs := make([]string, len(labels))
+	for index, label := range labels {
+		pairs[index] = label + `="` + escape(values[index]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writes one sample per key, sorted by label values
+func writeSamples(w io.Writer, name string, labels []string, values map[string]float64) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, key), formatValue(values[key]))
+	}
+}
+
+func labelKey(labels []string, labelValues []string) string {
+	if len(labelValues) != len(labels) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, keySeparator)
+}
+
+// CounterVec is a set of counters partitioned by label values
+type CounterVec struct {
+	sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+// NewCounterVec registers a counter, labels may be empty
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	register(name, c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	key := labelKey(c.labels, labelValues)
+
+	c.Lock()
+	defer c.Unlock()
+	c.values[key] += value
+}
+
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	key := labelKey(c.labels, labelValues)
+
+	c.Lock()
+	defer c.Unlock()
+	return c.values[key]
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	writeSamples(w, c.name, c.labels, c.values)
+}
+
+// SummaryVec tracks the count and sum of observations (no quantiles) partitioned by label values
+type SummaryVec struct {
+	sync.Mutex
+	name   string
+	help   string
+	labels []string
+	sums   map[string]float64
+	counts map[string]float64
+}
+
+func NewSummaryVec(name, help string, labels ...string) *SummaryVec {
+	s := &SummaryVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		sums:   make(map[string]float64),
+		counts: make(map[string]float64),
+	}
+	register(name, s)
+	return s
+}
+
+func (s *SummaryVec) Observe(value float64, labelValues ...string) {
+	key := labelKey(s.labels, labelValues)
+
+	s.Lock()
+	defer s.Unlock()
+	s.sums[key] += value
+	s.counts[key]++
+}
+
+func (s *SummaryVec) write(w io.Writer) {
+	s.Lock()
+	defer s.Unlock()
+
+	writeHeader(w, s.name, s.help, "summary")
+	writeSamples(w, s.name+"_sum", s.labels, s.sums)
+	writeSamples(w, s.name+"_count", s.labels, s.counts)
+}
+
+// GaugeFunc computes its values when metrics are scraped, by calling collect with a
+// set function. Setting the same label values several times sums values
+type GaugeFunc struct {
+	name    string
+	help    string
+	labels  []string
+	collect func(set func(value float64, labelValues ...string))
+}
+
+func NewGaugeFunc(name, help string, collect func(set func(value float64, labelValues ...string)), labels ...string) *GaugeFunc {
+	g := &GaugeFunc{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		collect: collect,
+	}
+	register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	values := make(map[string]float64)
+	g.collect(func(value float64, labelValues ...string) {
+		values[labelKey(g.labels, labelValues)] += value
+	})
+	writeHeader(w, g.name, g.help, "gauge")
+	writeSamples(w, g.name, g.labels, values)
+}
+
+// Write writes all registered metrics, sorted by name
+func Write(w io.Writer) {
+	registry.Lock()
+	names := make([]string, 0, len(registry.families))
+	for name := range registry.families {
+		names = append(names, name)
+	}
+	families := make([]family, len(names))
+	sort.Strings(names)
+	for index, name := range names {
+		families[index] = registry.families[name]
+	}
+	registry.Unlock()
+
+	for _, f := range families {
+		f.write(w)
+	}
+}
+
+// Handler serves metrics to Prometheus scrapers
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		b := bufio.NewWriter(w)
+		Write(b)
+		b.Flush()
+	})
+}
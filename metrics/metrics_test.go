@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	joins := NewCounterVec("test_joins_total", "Joins by outcome", "outcome")
+	joins.Inc("new")
+	joins.Inc("full")
+	joins.Add(2, "new")
+
+	loss := NewSummaryVec("test_loss", "Loss", "kind")
+	loss.Observe(0.25, "video")
+	loss.Observe(0.5, "video")
+
+	NewGaugeFunc("test_peers", "Peers", func(set func(value float64, labelValues ...string)) {
+		set(1, `a"b`)
+		set(2, `a"b`)
+	}, "name")
+
+	var b strings.Builder
+	Write(&b)
+
+	want := `# HELP test_joins_total Joins by outcome
+# TYPE test_joins_total counter
+test_joins_total{outcome="full"} 1
+test_joins_total{outcome="new"} 3
+# HELP test_loss Loss
+# TYPE test_loss summary
+test_loss_sum{kind="video"} 0.75
+test_loss_count{kind="video"} 2
+# HELP test_peers Peers
+# TYPE test_peers gauge
+test_peers{name="a\"b"} 3
+`
+	if got := b.String(); got != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,216 @@
+// Package replay implements the "ducksoup replay" command, feeding a raw RTP capture
+// (see JoinPayload#CaptureRTP) into a pipeline built from the same template as the
+// live one, at the original timing
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/gst"
+	"github.com/ducksouplab/ducksoup/helpers"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/pion/webrtc/v3/pkg/media/rtpdump"
+	"github.com/rs/zerolog/log"
+)
+
+// waited for after the last packet, for the pipeline to be stopped and its files finalized
+const stopTimeout = 30 * time.Second
+
+type options struct {
+	capture       string
+	output        string
+	recordingMode string
+}
+
+// output tracks of the replayed pipeline, processed RTP is dropped
+type discardTrack struct {
+	kind string
+}
+
+func (t discardTrack) ID() string {
+	return "replay-" + t.kind
+}
+
+func (t discardTrack) Write(buf []byte) error {
+	return nil
+}
+
+// there is no sender to forward PLIs to
+type noPLIRequester struct{}
+
+func (noPLIRequester) PLIRequest(cause string) {}
+
+type capturedStream struct {
+	kind   string
+	reader *rtpdump.Reader
+	file   *os.File
+	next   rtpdump.Packet // first packet not pushed yet
+	empty  bool
+}
+
+func parseOptions(args []string) (o options, err error) {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.StringVar(&o.capture, "capture", "", "capture description, the ...-capture.json file (required)")
+	fs.StringVar(&o.output, "output", "", "data folder where the pipeline writes its files (defaults to a replay-<timestamp> folder next to the capture)")
+	fs.StringVar(&o.recordingMode, "mode", "", "recording mode (defaults to the one of the capture, required for bypass captures)")
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+
+	if len(o.capture) == 0 {
+		fs.Usage()
+		return o, errors.New("missing_capture")
+	}
+	if len(o.output) == 0 {
+		o.output = filepath.Join(filepath.Dir(o.capture), "replay-"+time.Now().Format("20060102-150405"))
+	}
+	return
+}
+
+func readInfo(file string) (info types.CaptureInfo, err error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &info)
+	return
+}
+
+func openStreams(folder string, info types.CaptureInfo) (streams []*capturedStream, err error) {
+	for _, kind := range []string{"audio", "video"} {
+		file, ok := info.Files[kind]
+		if !ok {
+			continue
+		}
+		s := &capturedStream{kind: kind}
+		if s.file, err = os.Open(filepath.Join(folder, file)); err != nil {
+			break
+		}
+		streams = append(streams, s)
+		if s.reader, _, err = rtpdump.NewReader(s.file); err != nil {
+			break
+		}
+		if s.next, err = s.reader.Next(); errors.Is(err, io.EOF) {
+			// the track may not have been received, the pipeline still needs it to start
+			s.empty, err = true, nil
+		} else if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		for _, s := range streams {
+			s.file.Close()
+		}
+		return nil, err
+	}
+	for _, s := range streams {
+		if !s.empty {
+			return
+		}
+	}
+	for _, s := range streams {
+		s.file.Close()
+	}
+	return nil, errors.New("empty_capture")
+}
+
+// pushes packets at their original offsets, relatively to origin
+func (s *capturedStream) push(p *gst.Pipeline, start time.Time, origin time.Duration) (count int, err error) {
+	defer s.file.Close()
+
+	if s.empty {
+		return
+	}
+	for {
+		time.Sleep(time.Until(start.Add(s.next.Offset - origin)))
+		if s.next.IsRTCP {
+			p.PushRTCP(s.kind, s.next.Payload)
+		} else {
+			p.PushRTP(s.kind, s.next.Payload)
+		}
+		count++
+		if s.next, err = s.reader.Next(); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			return
+		}
+	}
+}
+
+// Run executes the command with its arguments (without "replay")
+func Run(args []string) error {
+	o, err := parseOptions(args)
+	if err != nil {
+		return err
+	}
+	info, err := readInfo(o.capture)
+	if err != nil {
+		return err
+	}
+	jp := info.JoinPayload
+	if len(o.recordingMode) > 0 {
+		jp.RecordingMode = o.recordingMode
+	}
+	if jp.RecordingMode == "bypass" {
+		return errors.New("bypass_has_no_pipeline")
+	}
+	streams, err := openStreams(filepath.Dir(o.capture), info)
+	if err != nil {
+		return err
+	}
+	// starts with the first captured packet
+	origin := time.Duration(-1)
+	for _, s := range streams {
+		if !s.empty && (origin < 0 || s.next.Offset < origin) {
+			origin = s.next.Offset
+		}
+	}
+
+	helpers.EnsureDir(o.output + "/recordings")
+	if jp.VideoFormat == "H264" {
+		helpers.EnsureDir(o.output + "/cache")
+	}
+	go gst.StartMainLoop()
+
+	p := gst.NewPipeline(jp, noPLIRequester{}, o.output, info.IRandomId, info.ConnectionCount, log.Logger)
+	for _, s := range streams {
+		p.BindTrackAutoStart(s.kind, discardTrack{s.kind})
+	}
+	<-p.Started()
+
+	log.Info().Str("context", "replay").Str("file", filepath.Base(o.capture)).Str("recordingMode", jp.RecordingMode).Msg("replay_started")
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make([]error, len(streams))
+	for index, s := range streams {
+		wg.Add(1)
+		go func(index int, s *capturedStream) {
+			defer wg.Done()
+			count, err := s.push(p, start, origin)
+			errs[index] = err
+			log.Info().Str("context", "replay").Str("kind", s.kind).Int("count", count).Msg("replay_stream_ended")
+			p.Stop()
+		}(index, s)
+	}
+	wg.Wait()
+
+	select {
+	case <-p.Done():
+	case <-time.After(stopTimeout):
+		return errors.New("pipeline_stop_timeout")
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	log.Info().Str("context", "replay").Str("files", strings.Join(p.Files(), ",")).Int64("value", time.Since(start).Milliseconds()).Str("unit", "ms").Msg("replay_done")
+	return nil
+}
@@ -0,0 +1,233 @@
+// Package reprocess implements the "ducksoup reprocess" command, regenerating a wet
+// recording from a dry one, the effects and the control events of the interaction log
+package reprocess
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/gst"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/rs/zerolog/log"
+)
+
+var userFileRegexp = regexp.MustCompile(`-u-(.+?)-c-\d+-`)
+
+type options struct {
+	input    string
+	output   string
+	logFile  string
+	userId   string
+	receiver string
+	audioFx  types.Fx
+	videoFx  types.Fx
+	offset   float64 // in ms, start of the recording since interaction start
+	// join payload found in log, its effects being replaced by audioFx and videoFx
+	jp     types.JoinPayload
+	joined bool
+}
+
+// log line fields that are used
+type logLine struct {
+	Message    string             `json:"message"`
+	User       string             `json:"user"`
+	ToUser     string             `json:"toUser"`
+	Name       string             `json:"name"`
+	Property   string             `json:"property"`
+	Kind       string             `json:"kind"`
+	Value      json.RawMessage    `json:"value"`
+	Duration   int                `json:"duration"` // in ms
+	SinceStart string             `json:"sinceStart"`
+	Payload    *types.JoinPayload `json:"payload"`
+}
+
+// a JSON list of effects or a raw GStreamer description
+func parseFx(value string) (fx types.Fx, err error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "[") {
+		value = strconv.Quote(value)
+	}
+	err = json.Unmarshal([]byte(value), &fx)
+	return
+}
+
+// "1234ms" to a duration, ok is false if s is empty
+func parseSince(s string) (d time.Duration, ok bool) {
+	ms, err := strconv.ParseInt(strings.TrimSuffix(s, "ms"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// reads the start of the recording (since interaction start) from its sync sidecar
+func readOffset(input string) (float64, error) {
+	data, err := os.ReadFile(input + ".sync.json")
+	if err != nil {
+		return 0, err
+	}
+	sidecar := struct {
+		FirstBufferOffset *float64 `json:"firstBufferOffset"`
+	}{}
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return 0, err
+	}
+	if sidecar.FirstBufferOffset == nil {
+		return 0, errors.New("no_first_buffer_offset")
+	}
+	return *sidecar.FirstBufferOffset, nil
+}
+
+func defaultOutput(input string) string {
+	extension := filepath.Ext(input)
+	base := strings.TrimSuffix(input, extension)
+	if strings.HasSuffix(base, "dry") {
+		return strings.TrimSuffix(base, "dry") + "wet-reprocessed" + extension
+	}
+	return base + "-reprocessed" + extension
+}
+
+// reads control events (relative to the recording start), the join payload of the
+// user and, if not already set, the effects declared when the user joined
+func parseLog(o *options) (events []types.ControlEvent, err error) {
+	f, err := os.Open(o.logFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fxFromLog := o.audioFx == nil && o.videoFx == nil
+	offset := time.Duration(o.offset * float64(time.Millisecond))
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		l := logLine{}
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil || l.User != o.userId {
+			continue
+		}
+		switch l.Message {
+		case "peer_joined":
+			// the first join defines the pipeline (reconnections reuse it)
+			if l.Payload == nil || o.joined {
+				continue
+			}
+			o.jp, o.joined = *l.Payload, true
+			if fxFromLog {
+				if len(o.receiver) > 0 {
+					o.audioFx = l.Payload.ReceiverFx[o.receiver].AudioFx
+					o.videoFx = l.Payload.ReceiverFx[o.receiver].VideoFx
+				} else {
+					o.audioFx = l.Payload.AudioFx
+					o.videoFx = l.Payload.VideoFx
+				}
+			}
+		case "client_fx_control", "coupling_fx_control":
+			if l.ToUser != o.receiver {
+				continue
+			}
+			var value float64
+			if err := json.Unmarshal(l.Value, &value); err != nil {
+				// polycontrol values are strings
+				var raw string
+				if json.Unmarshal(l.Value, &raw) != nil {
+					continue
+				}
+				if value, err = strconv.ParseFloat(raw, 64); err != nil {
+					log.Info().Str("context", "reprocess").Str("name", l.Name).Str("property", l.Property).Msg("reprocess_control_skipped")
+					continue
+				}
+			}
+			// events logged before the interaction start are applied from the beginning
+			since, _ := parseSince(l.SinceStart)
+			events = append(events, types.ControlEvent{
+				Name:     l.Name,
+				Property: l.Property,
+				At:       since - offset,
+				Duration: time.Duration(l.Duration) * time.Millisecond,
+				Value:    value,
+			})
+		}
+	}
+	return events, scanner.Err()
+}
+
+func parseOptions(args []string) (o options, err error) {
+	fs := flag.NewFlagSet("reprocess", flag.ContinueOnError)
+	fs.StringVar(&o.input, "input", "", "dry recording (required)")
+	fs.StringVar(&o.output, "output", "", "file to be written, with the extension of live wet recordings (defaults to input with dry replaced by wet-reprocessed)")
+	fs.StringVar(&o.logFile, "log", "", "interaction log file, with control events (required)")
+	fs.StringVar(&o.userId, "user", "", "user id (defaults to the one found in input file name)")
+	fs.StringVar(&o.receiver, "receiver", "", "receiver id, to reprocess a stream with receiverFx")
+	audioFx := fs.String("audio-fx", "", "audio effects, as a JSON list or a GStreamer description (defaults to the one found in log)")
+	videoFx := fs.String("video-fx", "", "video effects, as a JSON list or a GStreamer description (defaults to the one found in log)")
+	offset := fs.String("offset", "", "start of the recording in ms since interaction start (defaults to firstBufferOffset of the input sync sidecar)")
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+
+	if len(o.input) == 0 || len(o.logFile) == 0 {
+		fs.Usage()
+		return o, errors.New("missing_input_or_log")
+	}
+	if len(o.output) == 0 {
+		o.output = defaultOutput(o.input)
+	}
+	if len(o.userId) == 0 {
+		matches := userFileRegexp.FindStringSubmatch(filepath.Base(o.input))
+		if matches == nil {
+			return o, errors.New("missing_user")
+		}
+		o.userId = matches[1]
+	}
+	if len(*audioFx) > 0 {
+		if o.audioFx, err = parseFx(*audioFx); err != nil {
+			return
+		}
+	}
+	if len(*videoFx) > 0 {
+		if o.videoFx, err = parseFx(*videoFx); err != nil {
+			return
+		}
+	}
+	if len(*offset) > 0 {
+		o.offset, err = strconv.ParseFloat(*offset, 64)
+	} else {
+		o.offset, err = readOffset(o.input)
+	}
+	return
+}
+
+// Run executes the command with its arguments (without "reprocess")
+func Run(args []string) error {
+	o, err := parseOptions(args)
+	if err != nil {
+		return err
+	}
+	events, err := parseLog(&o)
+	if err != nil {
+		return err
+	}
+	if !o.joined {
+		return fmt.Errorf("no_join_for_user_%v", o.userId)
+	}
+	if len(o.audioFx) == 0 && len(o.videoFx) == 0 {
+		return fmt.Errorf("no_fx_for_user_%v", o.userId)
+	}
+	o.jp.AudioFx, o.jp.VideoFx = o.audioFx, o.videoFx
+
+	start := time.Now()
+	if err := gst.Reprocess(o.input, o.output, o.jp, events); err != nil {
+		return err
+	}
+	log.Info().Str("context", "reprocess").Str("file", o.output).Int("count", len(events)).Int64("value", time.Since(start).Milliseconds()).Str("unit", "ms").Msg("reprocess_done")
+	return nil
+}
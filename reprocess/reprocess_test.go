@@ -0,0 +1,163 @@
+package reprocess
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func writeFile(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// legacy effects (declared as strings) are parsed to a single raw element
+func rawFx(fx types.Fx) string {
+	if len(fx) != 1 {
+		return ""
+	}
+	return fx[0].Raw
+}
+
+func TestParseSince(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   time.Duration
+		wantOk bool
+	}{
+		{"1234ms", 1234 * time.Millisecond, true},
+		{"0ms", 0, true},
+		{"-250ms", -250 * time.Millisecond, true},
+		{"1234", 1234 * time.Millisecond, true},
+		{"", 0, false},
+		{"1.5s", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSince(tt.in)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("parseSince(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+const testLog = `{"message":"interaction_created"}
+{"message":"peer_joined","user":"b","payload":{"userId":"b","videoFormat":"VP8"}}
+{"message":"peer_joined","user":"a","payload":{"userId":"a","videoFormat":"H264","audioFx":"pitch name=p","receiverFx":{"b":{"audioFx":"pitch name=q"}}}}
+{"message":"client_fx_control","user":"a","name":"p","property":"pitch","value":1.5,"sinceStart":"-500ms"}
+{"message":"client_fx_control","user":"a","name":"p","property":"pitch","value":"0.5","duration":200,"sinceStart":"3000ms"}
+{"message":"client_fx_control","user":"a","name":"p","property":"pitch","value":"high","sinceStart":"3500ms"}
+{"message":"client_fx_control","user":"b","name":"p","property":"pitch","value":2,"sinceStart":"4000ms"}
+{"message":"coupling_fx_control","user":"a","toUser":"b","name":"q","property":"pitch","value":0.8,"sinceStart":"5000ms"}
+{"message":"peer_joined","user":"a","payload":{"userId":"a","videoFormat":"VP8"}}
+not json
+`
+
+func TestParseLog(t *testing.T) {
+	logFile := writeFile(t, "test.log", testLog)
+
+	tests := []struct {
+		name        string
+		o           options
+		wantEvents  []types.ControlEvent
+		wantAudioFx string
+		wantFormat  string
+	}{
+		{
+			name: "Events of the user, relative to the recording start",
+			o:    options{userId: "a", offset: 1000},
+			wantEvents: []types.ControlEvent{
+				{Name: "p", Property: "pitch", At: -1500 * time.Millisecond, Value: 1.5},
+				{Name: "p", Property: "pitch", At: 2000 * time.Millisecond, Duration: 200 * time.Millisecond, Value: 0.5},
+			},
+			wantAudioFx: "pitch name=p",
+			wantFormat:  "H264",
+		},
+		{
+			name: "Events and effects of the stream sent to a receiver",
+			o:    options{userId: "a", receiver: "b"},
+			wantEvents: []types.ControlEvent{
+				{Name: "q", Property: "pitch", At: 5000 * time.Millisecond, Value: 0.8},
+			},
+			wantAudioFx: "pitch name=q",
+			wantFormat:  "H264",
+		},
+		{
+			name:       "Other user",
+			o:          options{userId: "b", offset: 4000},
+			wantEvents: []types.ControlEvent{{Name: "p", Property: "pitch", At: 0, Value: 2}},
+			wantFormat: "VP8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.o
+			o.logFile = logFile
+			events, err := parseLog(&o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("got events %+v, want %+v", events, tt.wantEvents)
+			}
+			if !o.joined || o.jp.VideoFormat != tt.wantFormat {
+				t.Errorf("got join payload %+v, want the first one of the user", o.jp)
+			}
+			if audioFx := rawFx(o.audioFx); audioFx != tt.wantAudioFx {
+				t.Errorf("got audio fx %q, want %q", audioFx, tt.wantAudioFx)
+			}
+		})
+	}
+
+	t.Run("Effects set by options are kept", func(t *testing.T) {
+		o := options{userId: "a", logFile: logFile}
+		o.audioFx, _ = parseFx("echo name=e")
+		if _, err := parseLog(&o); err != nil {
+			t.Fatal(err)
+		}
+		if audioFx := rawFx(o.audioFx); audioFx != "echo name=e" {
+			t.Errorf("got audio fx %q", audioFx)
+		}
+	})
+}
+
+func TestOffset(t *testing.T) {
+	logFile := writeFile(t, "test.log", testLog)
+	input := writeFile(t, "i-x-a-20240101-120000.000-s-ns-n-name-u-a-c-1-dry.mp4", "")
+
+	t.Run("Read from the sync sidecar", func(t *testing.T) {
+		os.WriteFile(input+".sync.json", []byte(`{"firstBufferOffset":1250.5}`), 0666)
+		o, err := parseOptions([]string{"-input", input, "-log", logFile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.offset != 1250.5 || o.userId != "a" || !strings.HasSuffix(o.output, "-wet-reprocessed.mp4") {
+			t.Errorf("unexpected options %+v", o)
+		}
+		events, _ := parseLog(&o)
+		if at := events[1].At; at != 1749500*time.Microsecond {
+			t.Errorf("got event at %v", at)
+		}
+	})
+
+	t.Run("Overridden by option", func(t *testing.T) {
+		o, err := parseOptions([]string{"-input", input, "-log", logFile, "-offset", "3000"})
+		if err != nil || o.offset != 3000 {
+			t.Errorf("got offset %v (%v)", o.offset, err)
+		}
+	})
+
+	t.Run("Missing from the sidecar", func(t *testing.T) {
+		os.WriteFile(input+".sync.json", []byte(`{}`), 0666)
+		if _, err := parseOptions([]string{"-input", input, "-log", logFile}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
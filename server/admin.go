@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ducksouplab/ducksoup/jobs"
+	"github.com/ducksouplab/ducksoup/sfu"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/gorilla/mux"
+)
+
+type enqueuePayload struct {
+	Kind      string            `json:"kind"`
+	Payload   map[string]string `json:"payload"`
+	DependsOn string            `json:"dependsOn"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jobs.List(r.FormValue("status")))
+}
+
+func enqueueJobHandler(w http.ResponseWriter, r *http.Request) {
+	var payload enqueuePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request_body")
+		return
+	}
+	j, err := jobs.Enqueue(payload.Kind, payload.Payload, payload.DependsOn)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, j)
+}
+
+func getJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := jobs.Get(mux.Vars(r)["id"])
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "job_not_found")
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func retryJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := jobs.Retry(mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func jobKindsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jobs.Kinds())
+}
+
+func writeInteractionError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if err.Error() == "interaction_not_found" {
+		status = http.StatusNotFound
+	}
+	writeJSONError(w, status, err.Error())
+}
+
+// GET returns the coupling rules of a live interaction, PUT replaces them (origin
+// query parameter being needed if several interactions share namespace and name)
+func couplingsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	origin := r.FormValue("origin")
+	if r.Method == http.MethodPut {
+		var couplings []types.Coupling
+		if err := json.NewDecoder(r.Body).Decode(&couplings); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_request_body")
+			return
+		}
+		if err := sfu.SetInteractionCouplings(vars["namespace"], vars["name"], origin, couplings); err != nil {
+			writeInteractionError(w, err)
+			return
+		}
+	}
+	couplings, err := sfu.InteractionCouplings(vars["namespace"], vars["name"], origin)
+	if err != nil {
+		writeInteractionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, couplings)
+}
+
+// POST starts draining, DELETE stops it
+func drainHandler(w http.ResponseWriter, r *http.Request) {
+	sfu.SetDraining(r.Method == http.MethodPost)
+	writeJSON(w, http.StatusOK, map[string]bool{"draining": sfu.Draining()})
+}
+
+func addAdminRoutes(router *mux.Router, webPrefix, login, password string) {
+	adminRouter := router.PathPrefix(webPrefix + "/admin").Subrouter()
+	adminRouter.Use(basicAuthWith(login, password))
+	adminRouter.HandleFunc("/jobs", listJobsHandler).Methods("GET")
+	adminRouter.HandleFunc("/jobs", enqueueJobHandler).Methods("POST")
+	adminRouter.HandleFunc("/jobs/kinds", jobKindsHandler).Methods("GET")
+	adminRouter.HandleFunc("/jobs/{id}", getJobHandler).Methods("GET")
+	adminRouter.HandleFunc("/jobs/{id}/retry", retryJobHandler).Methods("POST")
+	adminRouter.HandleFunc("/drain", drainHandler).Methods("POST", "DELETE")
+	adminRouter.HandleFunc("/interactions/{namespace}/{name}/couplings", couplingsHandler).Methods("GET", "PUT")
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/gst"
+	"github.com/ducksouplab/ducksoup/iceservers"
+	"github.com/ducksouplab/ducksoup/sfu"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+type healthCheck struct {
+	name string
+	run  func() error
+}
+
+type checkResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// last readiness, logged when it changes
+var readiness struct {
+	sync.Mutex
+	ready   bool
+	checked bool
+}
+
+func checkMainLoop() error {
+	if !gst.MainLoopRunning() {
+		return errors.New("glib_main_loop_not_running")
+	}
+	return nil
+}
+
+func checkElements(names []string) error {
+	if missing := gst.MissingElements(names); len(missing) > 0 {
+		return fmt.Errorf("missing_elements: %s", strings.Join(missing, ","))
+	}
+	return nil
+}
+
+func checkDataFolderWritable() error {
+	f, err := os.CreateTemp(sfu.DataRoot, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.WriteString("ok"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func checkFreeDisk() error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(sfu.DataRoot, &stat); err != nil {
+		return err
+	}
+	freeMB := stat.Bavail * uint64(stat.Bsize) / (1024 * 1024)
+	if freeMB < uint64(env.MinFreeDiskMB) {
+		return fmt.Errorf("low_free_disk: %d MB", freeMB)
+	}
+	return nil
+}
+
+func checkTURN() error {
+	if !iceservers.TURNStarted() {
+		return errors.New("turn_server_not_started")
+	}
+	return nil
+}
+
+func checkNotDraining() error {
+	if sfu.Draining() {
+		return errors.New("draining")
+	}
+	return nil
+}
+
+// liveness: failing checks are only fixed by a restart
+func livenessChecks() []healthCheck {
+	return []healthCheck{
+		{"main_loop", checkMainLoop},
+	}
+}
+
+// readiness: the server can host new interactions
+func readinessChecks() []healthCheck {
+	checks := append(livenessChecks(),
+		healthCheck{"gstreamer_elements", func() error { return checkElements(gst.RequiredElements()) }},
+		// a full or read-only disk is not fixed by a restart
+		healthCheck{"data_folder_writable", checkDataFolderWritable},
+		healthCheck{"free_disk", checkFreeDisk},
+		healthCheck{"not_draining", checkNotDraining},
+	)
+	if env.NVCodec {
+		checks = append(checks, healthCheck{"nvcodec_elements", func() error { return checkElements(gst.NVCodecElements()) }})
+	}
+	if iceservers.TURNEnabled() {
+		checks = append(checks, healthCheck{"turn_server", checkTURN})
+	}
+	return checks
+}
+
+func runChecks(checks []healthCheck) (report healthReport, ok bool) {
+	ok = true
+	report.Checks = make(map[string]checkResult)
+	for _, c := range checks {
+		if err := c.run(); err != nil {
+			ok = false
+			report.Checks[c.name] = checkResult{Error: err.Error()}
+		} else {
+			report.Checks[c.name] = checkResult{OK: true}
+		}
+	}
+	report.Status = "ok"
+	if !ok {
+		report.Status = "failing"
+	}
+	return
+}
+
+func writeHealthReport(w http.ResponseWriter, report healthReport, ok bool) {
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	report, ok := runChecks(livenessChecks())
+	writeHealthReport(w, report, ok)
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	report, ok := runChecks(readinessChecks())
+
+	readiness.Lock()
+	if !readiness.checked || readiness.ready != ok {
+		failing := []string{}
+		for name, result := range report.Checks {
+			if !result.OK {
+				failing = append(failing, name+" ("+result.Error+")")
+			}
+		}
+		sort.Strings(failing)
+		log.Info().Str("context", "server").Bool("value", ok).Str("failing", strings.Join(failing, ",")).Msg("readiness_updated")
+		readiness.ready, readiness.checked = ok, true
+	}
+	readiness.Unlock()
+
+	writeHealthReport(w, report, ok)
+}
+
+func addHealthRoutes(router *mux.Router, webPrefix string) {
+	router.HandleFunc(webPrefix+"/healthz", healthzHandler).Methods("GET")
+	router.HandleFunc(webPrefix+"/readyz", readyzHandler).Methods("GET")
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/metrics"
 	"github.com/ducksouplab/ducksoup/sfu"
 	"github.com/ducksouplab/ducksoup/stats"
 	"github.com/gorilla/mux"
@@ -123,7 +124,7 @@ func SaveAudioTestResult(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create directory path and ensure it exists
-	dirPath := filepath.Join("data", payload.Namespace, payload.Interaction)
+	dirPath := filepath.Join(sfu.DataRoot, payload.Namespace, payload.Interaction)
 	if err := os.MkdirAll(dirPath, 0755); err != nil {
 		http.Error(w, "Storage error", http.StatusInternalServerError)
 		return
@@ -180,6 +181,17 @@ func Start() {
 		statsRouter.PathPrefix("/").Handler(http.StripPrefix(webPrefix+"/stats/", http.FileServer(http.Dir("./front/static/pages/stats/"))))
 	}
 
+	// health and readiness probes without auth
+	addHealthRoutes(router, webPrefix)
+
+	// admin API with basic auth
+	addAdminRoutes(router, webPrefix, env.TestLogin, env.TestPassword)
+
+	// Prometheus metrics with basic auth
+	metricsRouter := router.PathPrefix(webPrefix + "/metrics").Subrouter()
+	metricsRouter.Use(basicAuthWith(env.TestLogin, env.TestPassword))
+	metricsRouter.Handle("", metrics.Handler()).Methods("GET")
+
 	server := &http.Server{
 		Handler:      router,
 		Addr:         ":" + env.Port,
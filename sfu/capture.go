@@ -0,0 +1,155 @@
+package sfu
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/helpers"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/pion/webrtc/v3/pkg/media/rtpdump"
+	"github.com/rs/zerolog"
+)
+
+const captureInfoSuffix = "-capture.json"
+
+// rtpCapture writes the inbound (decrypted) RTP and RTCP packets of a user connection,
+// with their arrival offsets, to one rtpdump file per kind. All files share the same
+// start time so that "ducksoup replay" keeps audio and video aligned
+type rtpCapture struct {
+	mu      sync.Mutex
+	start   time.Time
+	files   map[string]*os.File
+	buffers map[string]*bufio.Writer
+	writers map[string]*rtpdump.Writer
+	logger  zerolog.Logger
+}
+
+// returns nil if the capture is not enabled or can't be created
+func newRTPCapture(jp types.JoinPayload, i *interaction, connectionCount int) *rtpCapture {
+	if !jp.CaptureRTP {
+		return nil
+	}
+	logger := i.logger.With().Str("context", "capture").Str("user", jp.UserId).Logger()
+	folder := i.DataFolder() + "/captures"
+	helpers.EnsureDir("./" + folder)
+	prefix := folder + "/" + types.FilePrefix(jp, i.randomId, connectionCount, "")
+
+	c := &rtpCapture{
+		start:   time.Now(),
+		files:   make(map[string]*os.File),
+		buffers: make(map[string]*bufio.Writer),
+		writers: make(map[string]*rtpdump.Writer),
+		logger:  logger,
+	}
+	info := types.CaptureInfo{
+		JoinPayload:     jp,
+		IRandomId:       i.randomId,
+		ConnectionCount: connectionCount,
+		StartedAt:       c.start,
+		Files:           make(map[string]string),
+	}
+	kinds := []string{"audio"}
+	if !jp.AudioOnly {
+		kinds = append(kinds, "video")
+	}
+	for _, kind := range kinds {
+		file := prefix + "-" + kind + ".rtpdump"
+		f, err := os.Create(file)
+		if err == nil {
+			b := bufio.NewWriter(f)
+			var w *rtpdump.Writer
+			if w, err = rtpdump.NewWriter(b, rtpdump.Header{Start: c.start, Source: net.IPv4zero}); err == nil {
+				c.files[kind] = f
+				c.buffers[kind] = b
+				c.writers[kind] = w
+				info.Files[kind] = filepath.Base(file)
+				continue
+			}
+			f.Close()
+		}
+		logger.Error().Str("file", filepath.Base(file)).Err(err).Msg("rtp_capture_failed")
+		c.closeAll()
+		return nil
+	}
+
+	formatted, err := json.MarshalIndent(info, "", "  ")
+	if err == nil {
+		err = os.WriteFile(prefix+captureInfoSuffix, append(formatted, '\n'), 0644)
+	}
+	if err != nil {
+		logger.Error().Str("file", filepath.Base(prefix+captureInfoSuffix)).Err(err).Msg("rtp_capture_failed")
+		c.closeAll()
+		return nil
+	}
+	logger.Info().Str("file", filepath.Base(prefix+captureInfoSuffix)).Msg("rtp_capture_started")
+	return c
+}
+
+// nil safe (capture disabled)
+func (c *rtpCapture) write(kind string, isRTCP bool, buf []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w, ok := c.writers[kind]
+	if !ok {
+		return
+	}
+	// the rtpdump writer marshals the packet before returning
+	if err := w.WritePacket(rtpdump.Packet{Offset: time.Since(c.start), IsRTCP: isRTCP, Payload: buf}); err != nil {
+		c.logger.Error().Str("kind", kind).Err(err).Msg("rtp_capture_failed")
+		c.unguardedClose(kind)
+	}
+}
+
+// nil safe (capture disabled), packets of this kind written afterwards are ignored
+func (c *rtpCapture) stop(kind string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.writers[kind]; ok {
+		c.unguardedClose(kind)
+		c.logger.Info().Str("kind", kind).Msg("rtp_capture_stopped")
+	}
+}
+
+// nil safe (capture disabled), for tracks that may not have been received
+func (c *rtpCapture) stopAll() {
+	if c == nil {
+		return
+	}
+	for _, kind := range []string{"audio", "video"} {
+		c.stop(kind)
+	}
+}
+
+func (c *rtpCapture) unguardedClose(kind string) {
+	if b, ok := c.buffers[kind]; ok {
+		b.Flush()
+	}
+	if f, ok := c.files[kind]; ok {
+		f.Close()
+	}
+	delete(c.writers, kind)
+	delete(c.buffers, kind)
+	delete(c.files, kind)
+}
+
+func (c *rtpCapture) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for kind := range c.files {
+		c.unguardedClose(kind)
+	}
+}
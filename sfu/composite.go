@@ -0,0 +1,199 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/rs/zerolog"
+)
+
+type compositeRender struct {
+	State string   `json:"state"` // "dry" or "wet"
+	Video string   `json:"video,omitempty"`
+	Wav   string   `json:"wav,omitempty"`
+	Users []string `json:"users"` // order of tiles (left to right, top to bottom) and WAV channels
+	// in ms, per user id and kind, silence and black frames added before the user recordings
+	Delays map[string]map[string]int64 `json:"delays"`
+	// verification of written files
+	Reports []types.FileReport `json:"reports"`
+	Errors  []string           `json:"errors,omitempty"`
+}
+
+// what is needed to render the composite of an interaction, once its manifest is written
+type compositeOptions struct {
+	DataFolder string
+	FilePrefix string // composite files are named FilePrefix-composite-State
+	State      string
+	Width      int
+	Height     int
+	Framerate  int
+	AudioOnly  bool
+}
+
+// reads the capture wall clock of the first buffer of the given kind recorded to
+// file from its sync sidecar
+func readCapturedAt(file, kind string) (t time.Time) {
+	data, err := os.ReadFile(file + syncSidecarSuffix)
+	if err != nil {
+		return
+	}
+	sidecar := syncSidecar{}
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return
+	}
+	for _, s := range sidecar.Streams {
+		if s.Kind == kind {
+			return s.CapturedAt
+		}
+	}
+	if sidecar.FirstBufferAt != nil {
+		return *sidecar.FirstBufferAt
+	}
+	return sidecar.PipelineStartedAt
+}
+
+// chooses the valid audio and video files of a user's first connection (or their
+// concatenation across connections) for the given state, falling back to dry files
+// for each kind without wet file (for instance audio if only video is processed).
+// Also returns the capture wall clock of the chosen audio and video (zero if unknown)
+func compositeSources(reports []types.FileReport, consolidated []consolidatedFile, state string) (in types.CompositeInput, audioAt, videoAt time.Time) {
+	first := -1
+	for _, r := range reports {
+		if count, ok := connectionCount(r.File); ok && r.Valid && (first < 0 || count < first) {
+			first = count
+		}
+	}
+	consolidatedIndex := make(map[string]string) // first segment to concatenated file
+	for _, c := range consolidated {
+		if c.Report != nil && c.Report.Valid && len(c.Segments) > 0 {
+			consolidatedIndex[c.Segments[0]] = c.File
+		}
+	}
+
+	for _, s := range []string{state, "dry"} {
+		// kinds found with the preferred state are kept
+		needsAudio, needsVideo := len(in.AudioFile) == 0, len(in.VideoFile) == 0
+		for _, r := range reports {
+			if count, ok := connectionCount(r.File); !ok || count != first || !r.Valid {
+				continue
+			}
+			_, suffix, _ := splitConnectionFile(r.File)
+			source := r.File
+			if c, ok := consolidatedIndex[r.File]; ok {
+				source = c
+			}
+			muxed := strings.HasPrefix(suffix, s+".")
+			if needsAudio && (strings.HasPrefix(suffix, "audio-"+s+".") || (muxed && hasStream(r, "audio"))) {
+				in.AudioFile, audioAt = source, readCapturedAt(r.File, "audio")
+			}
+			if needsVideo && (strings.HasPrefix(suffix, "video-"+s+".") || (muxed && hasStream(r, "video"))) {
+				in.VideoFile, videoAt = source, readCapturedAt(r.File, "video")
+			}
+		}
+	}
+	return
+}
+
+// aligns the recordings of all participants thanks to synchronization sidecars, and
+// renders them to a composite video and a multichannel WAV, then updates the manifest
+func renderComposite(o compositeOptions, logger zerolog.Logger) error {
+	m, err := readManifestFile(o.DataFolder)
+	if err != nil {
+		return err
+	}
+
+	users := []string{}
+	for userId := range m.Files {
+		users = append(users, userId)
+	}
+	sort.Strings(users)
+
+	c := &compositeRender{
+		State:   o.State,
+		Users:   []string{},
+		Delays:  make(map[string]map[string]int64),
+		Reports: []types.FileReport{},
+	}
+	inputs := []types.CompositeInput{}
+	audioStarts, videoStarts := []time.Time{}, []time.Time{}
+	var origin time.Time
+	updateOrigin := func(t time.Time) {
+		if !t.IsZero() && (origin.IsZero() || t.Before(origin)) {
+			origin = t
+		}
+	}
+	for _, userId := range users {
+		in, audioAt, videoAt := compositeSources(m.Files[userId], m.Consolidated[userId], o.State)
+		if len(in.AudioFile) == 0 && len(in.VideoFile) == 0 {
+			continue
+		}
+		c.Users = append(c.Users, userId)
+		inputs = append(inputs, in)
+		audioStarts = append(audioStarts, audioAt)
+		videoStarts = append(videoStarts, videoAt)
+		updateOrigin(audioAt)
+		updateOrigin(videoAt)
+	}
+	if len(inputs) == 0 {
+		return errors.New("no_valid_recording")
+	}
+	delay := func(t time.Time) time.Duration {
+		if t.IsZero() {
+			return 0
+		}
+		return t.Sub(origin)
+	}
+	for index, in := range inputs {
+		delays := make(map[string]int64)
+		if len(in.AudioFile) > 0 {
+			inputs[index].AudioDelay = delay(audioStarts[index])
+			delays["audio"] = inputs[index].AudioDelay.Milliseconds()
+		}
+		if len(in.VideoFile) > 0 {
+			inputs[index].VideoDelay = delay(videoStarts[index])
+			delays["video"] = inputs[index].VideoDelay.Milliseconds()
+		}
+		c.Delays[c.Users[index]] = delays
+	}
+
+	prefix := o.DataFolder + "/recordings/" + o.FilePrefix + "-composite-" + o.State
+	render := func(output string, run func() error) bool {
+		start := time.Now()
+		if err := run(); err != nil {
+			c.Errors = append(c.Errors, err.Error())
+			logger.Error().Str("context", "recording").Str("file", filepath.Base(output)).Err(err).Msg("recording_composite_failed")
+			return false
+		}
+		c.Reports = append(c.Reports, backend.VerifyFile(output))
+		logger.Info().Str("context", "recording").Str("file", filepath.Base(output)).Int64("value", time.Since(start).Milliseconds()).Str("unit", "ms").Msg("recording_composite_rendered")
+		return true
+	}
+
+	if !o.AudioOnly {
+		output := prefix + "." + backend.CompositeExtension()
+		if render(output, func() error {
+			return backend.RenderComposite(inputs, output, o.Width, o.Height, o.Framerate)
+		}) {
+			c.Video = output
+		}
+	}
+	output := prefix + ".wav"
+	if render(output, func() error { return backend.RenderMultichannelWav(inputs, output) }) {
+		c.Wav = output
+	}
+
+	m.Composite = c
+	if err := writeManifestFile(o.DataFolder, m); err != nil {
+		return err
+	}
+	if len(c.Errors) > 0 {
+		return errors.New(strings.Join(c.Errors, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func TestCompositeSources(t *testing.T) {
+	report := func(file string, codecs ...string) types.FileReport {
+		r := types.FileReport{File: file, Valid: true}
+		for _, codec := range codecs {
+			r.Streams = append(r.Streams, types.StreamReport{Codec: codec})
+		}
+		return r
+	}
+
+	t.Run("Falls back to dry files per kind", func(t *testing.T) {
+		// split mode with a videoFx only: there is no wet audio file
+		reports := []types.FileReport{
+			report("i-u-user-a-c-1-audio-dry.ogg", "audio/x-opus"),
+			report("i-u-user-a-c-1-video-dry.mp4", "video/x-h264"),
+			report("i-u-user-a-c-1-video-wet.mp4", "video/x-h264"),
+		}
+		in, _, _ := compositeSources(reports, nil, "wet")
+		if in.AudioFile != "i-u-user-a-c-1-audio-dry.ogg" || in.VideoFile != "i-u-user-a-c-1-video-wet.mp4" {
+			t.Errorf("unexpected sources %+v", in)
+		}
+	})
+
+	t.Run("Prefers muxed wet files, first connection and consolidated files", func(t *testing.T) {
+		reports := []types.FileReport{
+			report("i-u-user-a-c-2-wet.mp4", "audio/mpeg", "video/x-h264"),
+			report("i-u-user-a-c-1-dry.mp4", "audio/mpeg", "video/x-h264"),
+			report("i-u-user-a-c-1-wet.mp4", "audio/mpeg", "video/x-h264"),
+		}
+		consolidated := []consolidatedFile{{
+			File:     "i-u-user-a-c-all-wet.mp4",
+			Segments: []string{"i-u-user-a-c-1-wet.mp4", "i-u-user-a-c-2-wet.mp4"},
+			Report:   &types.FileReport{Valid: true},
+		}}
+		in, _, _ := compositeSources(reports, consolidated, "wet")
+		if in.AudioFile != "i-u-user-a-c-all-wet.mp4" || in.VideoFile != "i-u-user-a-c-all-wet.mp4" {
+			t.Errorf("unexpected sources %+v", in)
+		}
+	})
+
+	t.Run("Ignores invalid files", func(t *testing.T) {
+		invalid := report("i-u-user-a-c-1-audio-wet.ogg", "audio/x-opus")
+		invalid.Valid = false
+		reports := []types.FileReport{invalid, report("i-u-user-a-c-1-audio-dry.ogg", "audio/x-opus")}
+		in, _, _ := compositeSources(reports, nil, "wet")
+		if in.AudioFile != "i-u-user-a-c-1-audio-dry.ogg" || len(in.VideoFile) > 0 {
+			t.Errorf("unexpected sources %+v", in)
+		}
+	})
+}
@@ -0,0 +1,307 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/google/uuid"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/rs/zerolog"
+)
+
+// confederates are virtual participants publishing a pre-recorded media file. Each one
+// joins through RunPeerServer with an in-process websocket (see local_ws.go) and its own
+// pion peer connection, so that the server handles it like any other peer (mixerSlices,
+// effects, recordings...). The file is read and encoded by a mediaPlayer
+
+type confederate struct {
+	userId      string
+	file        string
+	i           *interaction
+	jp          types.JoinPayload // sent when joining
+	conn        *localConn
+	pc          *webrtc.PeerConnection
+	player      mediaPlayer
+	tracks      []*webrtc.TrackLocalStaticSample
+	tracksAdded bool
+	logger      zerolog.Logger
+}
+
+// adapts pion sample tracks to types.SampleWriter
+type sampleTrack struct {
+	track *webrtc.TrackLocalStaticSample
+}
+
+func (st sampleTrack) WriteSample(buf []byte, duration time.Duration) error {
+	return st.track.WriteSample(media.Sample{Data: buf, Duration: duration})
+}
+
+// keeps the interaction settings of the creator, without the options meant for the
+// creator only
+func confederateJoinPayload(creator types.JoinPayload, c types.Confederate) types.JoinPayload {
+	jp := creator
+	jp.UserId = c.UserId
+	jp.AudioFx = c.AudioFx
+	jp.VideoFx = c.VideoFx
+	jp.ReceiverFx = nil
+	jp.Couplings = nil
+	jp.Impairments = nil
+	jp.Confederates = nil
+	jp.AVOffset = 0
+	jp.MirrorDelay = 0
+	return jp
+}
+
+// files are looked up in env.MediaFolder only
+func confederateFile(file string) string {
+	return filepath.Join(env.MediaFolder, filepath.Clean("/"+file))
+}
+
+func newConfederate(i *interaction, creator types.JoinPayload, c types.Confederate) (cf *confederate, err error) {
+	c.UserId = parseString(c.UserId)
+	if len(c.UserId) == 0 || c.UserId == creator.UserId {
+		return nil, errors.New("invalid_user_id")
+	}
+	jp := confederateJoinPayload(creator, c)
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			pc.Close()
+		}
+	}()
+	// track ids must be unique in the interaction (see mixer.sliceIndex), like browser ones
+	audioTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, uuid.New().String(), c.UserId)
+	if err != nil {
+		return
+	}
+	tracks := []*webrtc.TrackLocalStaticSample{audioTrack}
+	videoTrack := audioTrack // unused if audio only
+	if !jp.AudioOnly {
+		videoCapability := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+		if jp.VideoFormat == "H264" {
+			videoCapability = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}
+		}
+		if videoTrack, err = webrtc.NewTrackLocalStaticSample(videoCapability, uuid.New().String(), c.UserId); err != nil {
+			return
+		}
+		tracks = append(tracks, videoTrack)
+	}
+
+	logger := i.logger.With().Str("context", "peer").Str("user", c.UserId).Logger()
+	player, err := backend.NewPlayer(types.PlayerOptions{
+		File:        confederateFile(c.File),
+		AudioOnly:   jp.AudioOnly,
+		VideoFormat: jp.VideoFormat,
+		Width:       jp.Width,
+		Height:      jp.Height,
+		Framerate:   jp.Framerate,
+		Loop:        c.Loop,
+		Autoplay:    c.Autoplay,
+		DataFolder:  i.DataFolder(),
+	}, sampleTrack{audioTrack}, sampleTrack{videoTrack}, logger)
+	if err != nil {
+		return
+	}
+
+	cf = &confederate{
+		userId: c.UserId,
+		file:   c.File,
+		i:      i,
+		jp:     jp,
+		conn:   newLocalConn(),
+		pc:     pc,
+		player: player,
+		tracks: tracks,
+		logger: logger,
+	}
+	cf.handleCallbacks()
+	return
+}
+
+// starts the confederates declared by the creator of an interaction. Since it is called
+// with the interaction store locked (see newInteraction) and players verify their file
+// (which may take long), confederates are created in the background
+func startConfederates(i *interaction, creator types.JoinPayload) {
+	for _, c := range creator.Confederates {
+		go startConfederate(i, creator, c)
+	}
+}
+
+func startConfederate(i *interaction, creator types.JoinPayload, c types.Confederate) {
+	cf, err := newConfederate(i, creator, c)
+	if err != nil {
+		i.logger.Error().Str("context", "peer").Str("user", c.UserId).Str("file", c.File).Err(err).Msg("confederate_failed")
+		return
+	}
+	select {
+	case <-i.isDone():
+		cf.close()
+		return
+	case <-i.isAborted():
+		cf.close()
+		return
+	default:
+	}
+	i.Lock()
+	i.confederateIndex[cf.userId] = cf
+	i.Unlock()
+	cf.run(creator.Origin)
+}
+
+func (cf *confederate) handleCallbacks() {
+	cf.pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		if s == webrtc.PeerConnectionStateConnected {
+			cf.player.Start()
+		}
+	})
+	// tracks from other participants are read (and discarded) for RTCP to flow
+	cf.pc.OnTrack(func(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := remoteTrack.Read(buf); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func (cf *confederate) run(origin string) {
+	defer cf.close()
+
+	go RunPeerServer(origin, cf.conn)
+	if err := cf.conn.send("join", cf.jp); err != nil {
+		return
+	}
+	cf.logger.Info().Str("file", cf.file).Msg("confederate_joining")
+
+	for {
+		m, err := cf.conn.receive()
+		if err != nil {
+			return
+		}
+		switch m.Kind {
+		case "joined":
+			cf.logger.Info().Msg("confederate_joined")
+		case "offer":
+			if err := cf.answer(m.Payload); err != nil {
+				cf.logger.Error().Err(err).Msg("confederate_answer_failed")
+				return
+			}
+		case "candidate":
+			var candidateStr string
+			candidate := webrtc.ICECandidateInit{}
+			if err := json.Unmarshal(m.Payload, &candidateStr); err == nil {
+				if err := json.Unmarshal([]byte(candidateStr), &candidate); err == nil {
+					cf.pc.AddICECandidate(candidate)
+				}
+			}
+		case "end":
+			return
+		default:
+			if strings.HasPrefix(m.Kind, "error") {
+				cf.logger.Error().Str("cause", m.Kind).Msg("confederate_failed")
+				return
+			}
+		}
+	}
+}
+
+// answers without trickle ICE: candidates are gathered before sending the answer
+func (cf *confederate) answer(payload json.RawMessage) error {
+	var offerStr string
+	offer := webrtc.SessionDescription{}
+	if err := json.Unmarshal(payload, &offerStr); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(offerStr), &offer); err != nil {
+		return err
+	}
+	if err := cf.pc.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+	// tracks are added once the transceivers of the first offer exist, to be reused
+	if !cf.tracksAdded {
+		for _, track := range cf.tracks {
+			sender, err := cf.pc.AddTrack(track)
+			if err != nil {
+				return err
+			}
+			go cf.loopReadRTCP(sender)
+		}
+		cf.tracksAdded = true
+	}
+	answer, err := cf.pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(cf.pc)
+	if err = cf.pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	<-gatherComplete
+	return cf.conn.send("client_answer", cf.pc.LocalDescription())
+}
+
+func (cf *confederate) loopReadRTCP(sender *webrtc.RTPSender) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, packet := range packets {
+			switch packet.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				cf.player.RequestKeyFrame()
+			}
+		}
+	}
+}
+
+func (cf *confederate) control(payload confederatePayload, fromUserId string) {
+	switch payload.Action {
+	case "play":
+		cf.player.Play()
+	case "pause":
+		cf.player.Pause()
+	case "rewind":
+		cf.player.Rewind()
+	default:
+		cf.logger.Error().Str("from", fromUserId).Str("value", payload.Action).Msg("confederate_control_invalid")
+		return
+	}
+	cf.logger.Info().Str("from", fromUserId).Str("value", payload.Action).Msg("confederate_control")
+}
+
+func (cf *confederate) close() {
+	cf.player.Stop()
+	cf.pc.Close()
+	cf.conn.Close()
+
+	cf.i.Lock()
+	delete(cf.i.confederateIndex, cf.userId)
+	cf.i.Unlock()
+	cf.logger.Info().Msg("confederate_left")
+}
+
+func (i *interaction) controlConfederate(payload confederatePayload, fromUserId string) {
+	i.RLock()
+	cf, ok := i.confederateIndex[payload.UserId]
+	i.RUnlock()
+
+	if ok {
+		cf.control(payload, fromUserId)
+	} else {
+		i.logger.Error().Str("context", "peer").Str("user", payload.UserId).Str("from", fromUserId).Msg("confederate_not_found")
+	}
+}
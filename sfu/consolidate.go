@@ -0,0 +1,188 @@
+package sfu
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+// captures file name before the connection count, the connection count and what remains
+var connectionFileRegexp = regexp.MustCompile(`^(.*-c-)(\d+)-(.+)$`)
+
+// files recorded by one processor, during one connection of a user
+type segment struct {
+	jp        types.JoinPayload
+	startedAt time.Time
+	files     []string
+	processor MediaProcessor
+}
+
+type gap struct {
+	Start int64 `json:"start"` // in ms since interaction start
+	End   int64 `json:"end"`
+}
+
+type consolidatedFile struct {
+	File     string            `json:"file"`
+	Segments []string          `json:"segments"`
+	Gaps     []gap             `json:"gaps"`
+	Report   *types.FileReport `json:"report,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// suffix is what remains after "-c-N-", for instance "dry.mp4" or "audio-wet.ogg"
+func splitConnectionFile(file string) (prefix, suffix string, ok bool) {
+	matches := connectionFileRegexp.FindStringSubmatch(file)
+	if matches == nil {
+		return "", "", false
+	}
+	return matches[1], matches[3], true
+}
+
+func connectionCount(file string) (count int, ok bool) {
+	matches := connectionFileRegexp.FindStringSubmatch(file)
+	if matches == nil {
+		return 0, false
+	}
+	count, err := strconv.Atoi(matches[2])
+	return count, err == nil
+}
+
+// bypass video recordings (IVF and Annex-B byte streams, see passthrough_writers.go)
+// are not decoded by the concatenation: Annex-B streams have no timestamps
+func isConsolidable(suffix string) bool {
+	switch filepath.Ext(suffix) {
+	case ".ivf", ".h264":
+		return false
+	}
+	return true
+}
+
+func hasStream(r types.FileReport, kind string) bool {
+	for _, s := range r.Streams {
+		if strings.HasPrefix(s.Codec, kind+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// for users that have reconnected, concatenates the files of each kind recorded
+// during each connection, then updates the manifest
+func (i *interaction) consolidateRecordings(m *manifest) {
+	i.RLock()
+	segmentsIndex := make(map[string][]segment)
+	for userId, segments := range i.segmentsIndex {
+		if len(segments) > 1 {
+			segmentsIndex[userId] = append([]segment{}, segments...)
+		}
+	}
+	i.RUnlock()
+
+	if len(segmentsIndex) == 0 {
+		return
+	}
+
+	reportIndex := make(map[string]types.FileReport)
+	for _, reports := range m.Files {
+		for _, r := range reports {
+			reportIndex[r.File] = r
+		}
+	}
+	// gaps are relative to interaction start
+	origin := m.StartedAt
+	if origin.IsZero() {
+		origin = m.CreatedAt
+	}
+
+	consolidated := make(map[string][]consolidatedFile)
+	for userId, segments := range segmentsIndex {
+		sort.Slice(segments, func(a, b int) bool {
+			return segments[a].startedAt.Before(segments[b].startedAt)
+		})
+		// group valid files by suffix, keeping connection order
+		jp := segments[0].jp
+		suffixes := []string{}
+		bySuffix := make(map[string][]types.Segment)
+		outputs := make(map[string]string)
+		gaps := make(map[string][]gap)
+		for index, s := range segments {
+			var nextStartedAt time.Time
+			if index < len(segments)-1 {
+				nextStartedAt = segments[index+1].startedAt
+			}
+			for _, file := range s.files {
+				r, ok := reportIndex[file]
+				if !ok || !r.Valid {
+					continue
+				}
+				prefix, suffix, ok := splitConnectionFile(file)
+				if !ok {
+					continue
+				}
+				if _, ok := bySuffix[suffix]; !ok {
+					suffixes = append(suffixes, suffix)
+					outputs[suffix] = prefix + "all-" + suffix
+				}
+				gs := types.Segment{
+					File:     file,
+					HasAudio: hasStream(r, "audio"),
+					HasVideo: hasStream(r, "video"),
+				}
+				if !nextStartedAt.IsZero() {
+					end := s.startedAt.Add(time.Duration(r.Duration) * time.Millisecond)
+					if nextStartedAt.After(end) {
+						gs.Gap = nextStartedAt.Sub(end)
+						gaps[suffix] = append(gaps[suffix], gap{end.Sub(origin).Milliseconds(), nextStartedAt.Sub(origin).Milliseconds()})
+					}
+				}
+				bySuffix[suffix] = append(bySuffix[suffix], gs)
+			}
+		}
+
+		for _, suffix := range suffixes {
+			gsegments := bySuffix[suffix]
+			if len(gsegments) < 2 {
+				continue
+			}
+			if !isConsolidable(suffix) {
+				i.logger.Info().Str("context", "recording").Str("user", userId).Str("file", filepath.Base(outputs[suffix])).Msg("recording_consolidation_skipped")
+				continue
+			}
+			c := consolidatedFile{
+				File: outputs[suffix],
+				Gaps: gaps[suffix],
+			}
+			if c.Gaps == nil {
+				c.Gaps = []gap{}
+			}
+			for _, gs := range gsegments {
+				c.Segments = append(c.Segments, gs.File)
+			}
+			start := time.Now()
+			if err := backend.ConcatSegments(gsegments, c.File, jp.Width, jp.Height, jp.Framerate); err != nil {
+				c.Error = err.Error()
+				i.logger.Error().Str("context", "recording").Str("user", userId).Str("file", filepath.Base(c.File)).Err(err).Msg("recording_consolidation_failed")
+			} else {
+				r := backend.VerifyFile(c.File)
+				c.Report = &r
+				i.logger.Info().Str("context", "recording").Str("user", userId).Str("file", filepath.Base(c.File)).Int64("value", time.Since(start).Milliseconds()).Str("unit", "ms").Msg("recording_consolidated")
+			}
+			consolidated[userId] = append(consolidated[userId], c)
+		}
+	}
+
+	// manifest may be read concurrently, update a copy
+	updated := *m
+	updated.Consolidated = consolidated
+	i.writeManifest(&updated)
+
+	i.Lock()
+	i.manifest = &updated
+	i.Unlock()
+}
@@ -0,0 +1,36 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/rs/zerolog"
+)
+
+func TestConsolidateRecordingsSkipsBypassVideo(t *testing.T) {
+	prefix := "recordings/i-x-u-alice-c-"
+	files := func(count string) []string {
+		return []string{prefix + count + "-audio-dry.ogg", prefix + count + "-video-dry.ivf", prefix + count + "-video-dry.h264"}
+	}
+	startedAt := time.Now()
+	i := &interaction{
+		logger:     zerolog.Nop(),
+		dataFolder: t.TempDir(),
+		segmentsIndex: map[string][]segment{"alice": {
+			{startedAt: startedAt, files: files("1")},
+			{startedAt: startedAt.Add(time.Second), files: files("2")},
+		}},
+	}
+	reports := []types.FileReport{}
+	for _, file := range append(files("1"), files("2")...) {
+		reports = append(reports, types.FileReport{File: file, Valid: true})
+	}
+	i.consolidateRecordings(&manifest{CreatedAt: startedAt, Files: map[string][]types.FileReport{"alice": reports}})
+
+	// fakeMediaBackend fails to concatenate, but only audio files are attempted
+	consolidated := i.manifest.Consolidated["alice"]
+	if len(consolidated) != 1 || consolidated[0].File != prefix+"all-audio-dry.ogg" {
+		t.Fatalf("got %+v, expected audio files only", consolidated)
+	}
+}
@@ -0,0 +1,263 @@
+package sfu
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+const (
+	couplingPeriod = 50 * time.Millisecond
+	// smoothed values are applied if they differ from the last applied one by more
+	// than this ratio of the [min, max] range, to limit property changes and logs
+	couplingResolution = 0.01
+)
+
+// checks a rule on its own, see also interaction#unguardedValidateCoupling
+func validateCoupling(c types.Coupling) error {
+	if len(c.Source) == 0 || len(c.Target) == 0 || len(c.Name) == 0 || len(c.Property) == 0 {
+		return errors.New("missing_field")
+	}
+	switch c.Signal {
+	case "level", "speaking", "tracking":
+	default:
+		return errors.New("unknown_signal")
+	}
+	if c.Min == c.Max {
+		return errors.New("empty_range")
+	}
+	return nil
+}
+
+// checks a rule and that its source and target are users of the interaction, should
+// be called by another method that locked the interaction (mutex)
+func (i *interaction) unguardedValidateCoupling(c types.Coupling) error {
+	if err := validateCoupling(c); err != nil {
+		return err
+	}
+	for _, userId := range []string{c.Source, c.Target} {
+		if _, ok := i.joinedCountIndex[userId]; !ok {
+			return errors.New("unknown_user")
+		}
+	}
+	return nil
+}
+
+// input range, level being in dBov and other signals 0 or 1
+func couplingInputRange(c types.Coupling) (min, max float64) {
+	if c.InputMin != c.InputMax {
+		return c.InputMin, c.InputMax
+	}
+	if c.Signal == "level" {
+		return -60, 0
+	}
+	return 0, 1
+}
+
+func boolSignal(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// current value of a live signal of userId, ok is false if not available (yet)
+func (i *interaction) signalOf(userId, signal string) (value float64, ok bool) {
+	switch signal {
+	case "level":
+		value, _, ok = i.voiceActivityOf(userId)
+	case "speaking":
+		var speaking bool
+		_, speaking, ok = i.voiceActivityOf(userId)
+		value = boolSignal(speaking)
+	case "tracking":
+		var tracking bool
+		tracking, ok = backend.Tracking(i.randomId, userId)
+		value = boolSignal(tracking)
+	}
+	return
+}
+
+func (i *interaction) peerServerOf(userId string) (ps *peerServer, ok bool) {
+	i.RLock()
+	defer i.RUnlock()
+
+	ps, ok = i.peerServerIndex[userId]
+	return
+}
+
+// maps the source signal of a rule to [c.Min, c.Max] and smooths it
+type couplingFilter struct {
+	c                  types.Coupling
+	inputMin, inputMax float64
+	alpha              float64 // exponential smoothing factor, per couplingPeriod
+	smoothed, applied  float64
+	started            bool
+	hasApplied         bool
+}
+
+func newCouplingFilter(c types.Coupling) *couplingFilter {
+	f := &couplingFilter{c: c, alpha: 1}
+	f.inputMin, f.inputMax = couplingInputRange(c)
+	if c.Smoothing > 0 {
+		f.alpha = 1 - math.Exp(-float64(couplingPeriod)/float64(time.Duration(c.Smoothing)*time.Millisecond))
+	}
+	return f
+}
+
+// processes the input read every couplingPeriod, returns the smoothed value and
+// whether it differs enough from the last applied one to be applied
+func (f *couplingFilter) update(input float64) (value float64, apply bool) {
+	ratio := math.Max(0, math.Min(1, (input-f.inputMin)/(f.inputMax-f.inputMin)))
+	target := f.c.Min + ratio*(f.c.Max-f.c.Min)
+	if f.started {
+		f.smoothed += f.alpha * (target - f.smoothed)
+	} else {
+		f.smoothed, f.started = target, true
+	}
+	apply = !f.hasApplied || math.Abs(f.smoothed-f.applied) >= couplingResolution*math.Abs(f.c.Max-f.c.Min)
+	return f.smoothed, apply
+}
+
+func (f *couplingFilter) setApplied(value float64) {
+	f.applied, f.hasApplied = value, true
+}
+
+// should be called by another method that locked the interaction (mutex), once
+// started: stops the loops of previous rules and starts the ones of i.couplings
+func (i *interaction) unguardedRunCouplings() {
+	if i.couplingStopCh != nil {
+		close(i.couplingStopCh)
+	}
+	i.couplingStopCh = make(chan struct{})
+	for _, c := range i.couplings {
+		go i.loopCoupling(c, i.couplingStopCh)
+	}
+}
+
+// should be called by another method that locked the interaction (mutex): rules
+// declared when joining are only validated at start, once other users have joined
+func (i *interaction) unguardedStartCouplings() {
+	valid := []types.Coupling{}
+	for _, c := range i.couplings {
+		if err := i.unguardedValidateCoupling(c); err != nil {
+			i.logger.Error().Str("context", "interaction").Str("from", c.Source).Str("toUser", c.Target).Err(err).Msg("coupling_invalid")
+			continue
+		}
+		valid = append(valid, c)
+	}
+	i.couplings = valid
+	i.unguardedRunCouplings()
+}
+
+// replaces all coupling rules, which are running at once if the interaction has started
+func (i *interaction) setCouplings(couplings []types.Coupling) error {
+	i.Lock()
+	defer i.Unlock()
+
+	for _, c := range couplings {
+		if err := i.unguardedValidateCoupling(c); err != nil {
+			return err
+		}
+	}
+	i.couplings = append([]types.Coupling{}, couplings...)
+	i.logger.Info().Str("context", "interaction").Int("count", len(couplings)).Msg("couplings_updated")
+	if i.started {
+		i.unguardedRunCouplings()
+	}
+	return nil
+}
+
+func (i *interaction) getCouplings() []types.Coupling {
+	i.RLock()
+	defer i.RUnlock()
+
+	return append([]types.Coupling{}, i.couplings...)
+}
+
+// evaluates a coupling rule till the interaction ends or rules are replaced (stopCh
+// being closed): the filtered source signal is applied to the target fx property
+func (i *interaction) loopCoupling(c types.Coupling, stopCh chan struct{}) {
+	i.logger.Info().Str("context", "interaction").Str("from", c.Source).Str("signal", c.Signal).Str("user", c.Target).Str("name", c.Name).Str("property", c.Property).Msg("coupling_started")
+
+	f := newCouplingFilter(c)
+	ticker := time.NewTicker(couplingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-i.isDone():
+			return
+		case <-stopCh:
+			i.logger.Info().Str("context", "interaction").Str("from", c.Source).Str("signal", c.Signal).Str("user", c.Target).Str("name", c.Name).Str("property", c.Property).Msg("coupling_stopped")
+			return
+		case <-ticker.C:
+			input, ok := i.signalOf(c.Source, c.Signal)
+			if !ok {
+				continue
+			}
+			value, apply := f.update(input)
+			if !apply {
+				continue
+			}
+			ps, ok := i.peerServerOf(c.Target)
+			if !ok {
+				continue
+			}
+			processor, ok := ps.processorFor(c.ReceiverId)
+			if !ok {
+				continue
+			}
+			processor.SetFxPropFloat(c.Name, c.Property, float32(value))
+			f.setApplied(value)
+			ps.logInfo().
+				Str("context", "track").
+				Str("from", c.Source).
+				Str("signal", c.Signal).
+				Str("name", c.Name).
+				Str("property", c.Property).
+				Str("toUser", c.ReceiverId).
+				Float32("value", float32(value)).
+				Msg("coupling_fx_control")
+		}
+	}
+}
+
+// finds a live interaction by namespace and name (and origin if not empty)
+func findInteraction(namespace, name, origin string) (*interaction, error) {
+	var found *interaction
+	for _, i := range listInteractions() {
+		if i.namespace != namespace || i.name != name || (len(origin) > 0 && i.jp.Origin != origin) {
+			continue
+		}
+		if found != nil {
+			return nil, errors.New("ambiguous_interaction")
+		}
+		found = i
+	}
+	if found == nil {
+		return nil, errors.New("interaction_not_found")
+	}
+	return found, nil
+}
+
+// InteractionCouplings returns the coupling rules of a live interaction, origin being
+// needed only if interactions from several origins share namespace and name
+func InteractionCouplings(namespace, name, origin string) ([]types.Coupling, error) {
+	i, err := findInteraction(namespace, name, origin)
+	if err != nil {
+		return nil, err
+	}
+	return i.getCouplings(), nil
+}
+
+// SetInteractionCouplings replaces the coupling rules of a live interaction, whose
+// sources and targets have to be users of the interaction
+func SetInteractionCouplings(namespace, name, origin string, couplings []types.Coupling) error {
+	i, err := findInteraction(namespace, name, origin)
+	if err != nil {
+		return err
+	}
+	return i.setCouplings(couplings)
+}
@@ -0,0 +1,180 @@
+package sfu
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func newTestCoupling() types.Coupling {
+	return types.Coupling{Source: "user-a", Signal: "level", Target: "user-b", Name: "fx", Property: "pitch", Min: 1, Max: 2}
+}
+
+func TestValidateCoupling(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(c *types.Coupling)
+		want   string
+	}{
+		{"Valid", func(c *types.Coupling) {}, ""},
+		{"Speaking signal", func(c *types.Coupling) { c.Signal = "speaking" }, ""},
+		{"Decreasing range", func(c *types.Coupling) { c.Min, c.Max = 2, 1 }, ""},
+		{"Missing source", func(c *types.Coupling) { c.Source = "" }, "missing_field"},
+		{"Missing property", func(c *types.Coupling) { c.Property = "" }, "missing_field"},
+		{"Unknown signal", func(c *types.Coupling) { c.Signal = "pitch" }, "unknown_signal"},
+		{"Empty range", func(c *types.Coupling) { c.Max = c.Min }, "empty_range"},
+	}
+	for _, tt := range tests {
+		c := newTestCoupling()
+		tt.update(&c)
+		err := validateCoupling(c)
+		if (tt.want == "" && err != nil) || (tt.want != "" && (err == nil || err.Error() != tt.want)) {
+			t.Errorf("%v: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCouplingInputRange(t *testing.T) {
+	tests := []struct {
+		signal             string
+		inputMin, inputMax float64
+		wantMin, wantMax   float64
+	}{
+		{"level", 0, 0, -60, 0},
+		{"speaking", 0, 0, 0, 1},
+		{"tracking", 0, 0, 0, 1},
+		{"level", -50, -10, -50, -10},
+		{"speaking", 1, 0, 1, 0},
+	}
+	for _, tt := range tests {
+		c := newTestCoupling()
+		c.Signal, c.InputMin, c.InputMax = tt.signal, tt.inputMin, tt.inputMax
+		if min, max := couplingInputRange(c); min != tt.wantMin || max != tt.wantMax {
+			t.Errorf("%+v: got [%v, %v]", tt, min, max)
+		}
+	}
+}
+
+func TestCouplingFilter(t *testing.T) {
+	t.Run("Maps and clamps without smoothing", func(t *testing.T) {
+		f := newCouplingFilter(newTestCoupling())
+		for _, step := range []struct{ input, want float64 }{{-60, 1}, {-30, 1.5}, {10, 2}, {-100, 1}} {
+			value, apply := f.update(step.input)
+			if value != step.want || !apply {
+				t.Errorf("input %v: got %v (apply=%v), want %v", step.input, value, apply, step.want)
+			}
+			f.setApplied(value)
+		}
+	})
+
+	t.Run("Smooths exponentially", func(t *testing.T) {
+		c := newTestCoupling()
+		c.Smoothing = int(couplingPeriod.Milliseconds()) // time constant of one period
+		f := newCouplingFilter(c)
+		if value, _ := f.update(-60); value != 1 {
+			t.Errorf("first value is not smoothed: %v", value)
+		}
+		// step to max: the gap shrinks by e every period
+		gap := 1.0
+		for period := 0; period < 3; period++ {
+			value, _ := f.update(0)
+			gap /= math.E
+			if math.Abs((2-value)-gap) > 1e-9 {
+				t.Errorf("period %v: got %v, want %v", period, value, 2-gap)
+			}
+		}
+	})
+
+	t.Run("Applies changes above the resolution", func(t *testing.T) {
+		f := newCouplingFilter(newTestCoupling())
+		value, apply := f.update(-30)
+		if !apply {
+			t.Error("first value not applied")
+		}
+		f.setApplied(value)
+		// 0.5% of the range
+		if _, apply := f.update(-29.7); apply {
+			t.Error("small change applied")
+		}
+		if _, apply := f.update(-28.8); !apply {
+			t.Error("change above resolution not applied")
+		}
+		// the previous value was not applied (target not connected for instance), the
+		// next one is compared to the last applied value
+		if _, apply := f.update(-29.1); !apply {
+			t.Error("change compared to the last computed value instead of the applied one")
+		}
+	})
+}
+
+func TestSetCouplings(t *testing.T) {
+	name := uniqueName()
+	interactionStoreSingleton.join(newFakeJoinPayload(name, "user-a", 2, 30))
+	i, _, _ := interactionStoreSingleton.join(newFakeJoinPayload(name, "user-b", 2, 30))
+
+	t.Run("Rejects users who are not in the interaction", func(t *testing.T) {
+		c := newTestCoupling()
+		c.Target = "user-c"
+		if err := SetInteractionCouplings("test", name, "", []types.Coupling{newTestCoupling(), c}); err == nil || err.Error() != "unknown_user" {
+			t.Errorf("got %v, want unknown_user", err)
+		}
+		if couplings, _ := InteractionCouplings("test", name, ""); len(couplings) != 0 {
+			t.Errorf("rules changed: %+v", couplings)
+		}
+	})
+
+	t.Run("Rejects invalid rules", func(t *testing.T) {
+		c := newTestCoupling()
+		c.Signal = ""
+		if err := SetInteractionCouplings("test", name, "", []types.Coupling{c}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("Replaces running rules", func(t *testing.T) {
+		i.Lock()
+		i.started = true
+		i.Unlock()
+		couplings := []types.Coupling{newTestCoupling()}
+		if err := SetInteractionCouplings("test", name, testOrigin, couplings); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := InteractionCouplings("test", name, ""); len(got) != 1 || got[0] != couplings[0] {
+			t.Errorf("got %+v", got)
+		}
+		i.RLock()
+		stopCh := i.couplingStopCh
+		i.RUnlock()
+
+		if err := SetInteractionCouplings("test", name, "", nil); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-stopCh:
+		default:
+			t.Error("previous rules not stopped")
+		}
+	})
+
+	t.Run("Unknown interaction", func(t *testing.T) {
+		if _, err := InteractionCouplings("test", name, "https://other"); err == nil || err.Error() != "interaction_not_found" {
+			t.Errorf("got %v, want interaction_not_found", err)
+		}
+	})
+
+	t.Run("Join rules are validated at start", func(t *testing.T) {
+		valid, unknown := newTestCoupling(), newTestCoupling()
+		unknown.Source = "user-c"
+		i.Lock()
+		i.couplings = []types.Coupling{unknown, valid}
+		i.unguardedStartCouplings()
+		got := i.couplings
+		i.couplings = nil
+		i.unguardedRunCouplings()
+		i.Unlock()
+		if len(got) != 1 || got[0] != valid {
+			t.Errorf("got %+v", got)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/sequencing"
+)
+
+// audio is shifted against video by delaying the packets written to the live output
+// tracks of either kind. Since RTCP sender reports are computed from send times,
+// receivers play the delayed kind later. Recordings, written by pipelines, keep the
+// original alignment
+
+const (
+	maxAVOffset   = 2000 // ms
+	delayLineSize = 4096 // packets, enough for 5 s of high bitrate video
+)
+
+type delayedPacket struct {
+	sendAt time.Time
+	buf    []byte
+}
+
+type delayLine struct {
+	sync.Mutex
+	write      func([]byte) (int, error)
+	delay      time.Duration
+	lastSendAt time.Time
+	queue      chan delayedPacket
+	doneCh     chan struct{}
+}
+
+func newDelayLine(write func([]byte) (int, error), doneCh chan struct{}) *delayLine {
+	dl := &delayLine{
+		write:  write,
+		queue:  make(chan delayedPacket, delayLineSize),
+		doneCh: doneCh,
+	}
+	go dl.loop()
+	return dl
+}
+
+// queued packets are dropped once done
+func (dl *delayLine) loop() {
+	timer := time.NewTimer(0)
+	<-timer.C
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-dl.doneCh:
+			return
+		case p := <-dl.queue:
+			if wait := time.Until(p.sendAt); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-dl.doneCh:
+					return
+				case <-timer.C:
+				}
+			}
+			select {
+			case <-dl.doneCh:
+				return
+			default:
+				dl.write(p.buf)
+			}
+		}
+	}
+}
+
+func (dl *delayLine) setDelay(delay time.Duration) {
+	dl.Lock()
+	defer dl.Unlock()
+
+	dl.delay = delay
+}
+
+func (dl *delayLine) Write(buf []byte) (int, error) {
+	dl.Lock()
+	defer dl.Unlock()
+
+	now := time.Now()
+	if dl.delay <= 0 && !dl.lastSendAt.After(now) {
+		return dl.write(buf)
+	}
+	sendAt := now.Add(dl.delay)
+	if sendAt.Before(dl.lastSendAt) {
+		// when the delay decreases, packets are not reordered
+		sendAt = dl.lastSendAt
+	}
+	dl.lastSendAt = sendAt
+	select {
+	case dl.queue <- delayedPacket{sendAt, append([]byte{}, buf...)}:
+	default:
+		// queue is full, packet is dropped
+	}
+	return len(buf), nil
+}
+
+func clampAVOffset(offset int) int {
+	if offset > maxAVOffset {
+		return maxAVOffset
+	} else if offset < -maxAVOffset {
+		return -maxAVOffset
+	}
+	return offset
+}
+
+// sums up the delays of each kind: positive A/V offsets delay audio, negative ones
+// delay video, and the mirror delay (see mirror.go) applies to both
+func (ps *peerServer) applyOutputDelays() {
+	ps.Lock()
+	avOffset, mirrorDelay := ps.avOffset, ps.mirrorDelay
+	audioSlice, videoSlice := ps.audioSlice, ps.videoSlice
+	ps.Unlock()
+
+	toDuration := func(ms float32) time.Duration {
+		return time.Duration(ms * float32(time.Millisecond))
+	}
+	if audioSlice != nil {
+		audioSlice.setOutputDelay(toDuration(mirrorDelay + max(avOffset, 0)))
+	}
+	if videoSlice != nil {
+		videoSlice.setOutputDelay(toDuration(mirrorDelay + max(-avOffset, 0)))
+	}
+}
+
+func (ps *peerServer) setAVOffset(offset float32) {
+	ps.Lock()
+	ps.avOffset = offset
+	ps.Unlock()
+	ps.applyOutputDelays()
+}
+
+// calls set with values from current to target during duration (in ms), or once
+// with target if duration is 0. A new ramp with the same id stops the previous one
+func (ps *peerServer) rampOutputDelay(interpolatorId string, current, target float32, duration int, set func(float32)) {
+	ps.Lock()
+	if interpolator := ps.interpolatorIndex[interpolatorId]; interpolator != nil {
+		interpolator.Stop()
+		delete(ps.interpolatorIndex, interpolatorId)
+	}
+	if duration == 0 {
+		ps.Unlock()
+		set(target)
+		return
+	}
+	newInterpolator := sequencing.NewLinearInterpolator(current, target, duration, defaultInterpolatorStep)
+	ps.interpolatorIndex[interpolatorId] = newInterpolator
+	ps.Unlock()
+
+	defer func() {
+		ps.Lock()
+		if ps.interpolatorIndex[interpolatorId] == newInterpolator {
+			delete(ps.interpolatorIndex, interpolatorId)
+		}
+		ps.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ps.isDone():
+			return
+		case currentValue, more := <-newInterpolator.C:
+			if more {
+				set(currentValue)
+			} else {
+				return
+			}
+		}
+	}
+}
+
+// updates the A/V offset, with a linear ramp if duration (in ms) is not 0
+func (ps *peerServer) controlAVOffset(payload avOffsetPayload) {
+	offset := clampAVOffset(payload.Offset)
+	duration := min(payload.Duration, maxInterpolatorDuration)
+	ps.logInfo().
+		Str("context", "track").
+		Str("from", payload.fromUserId).
+		Int("value", offset).
+		Str("unit", "ms").
+		Int("duration", duration).
+		Msg("av_offset_updated")
+
+	ps.Lock()
+	current := ps.avOffset
+	ps.Unlock()
+	ps.rampOutputDelay("av_offset", current, float32(offset), duration, ps.setAVOffset)
+}
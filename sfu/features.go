@@ -0,0 +1,55 @@
+package sfu
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/rs/zerolog"
+)
+
+// extracts features from all valid recordings listed in the manifest (CSV files are
+// written next to them), then updates the manifest
+func extractFeatures(dataFolder string, o types.FeatureOptions, logger zerolog.Logger) error {
+	m, err := readManifestFile(dataFolder)
+	if err != nil {
+		return err
+	}
+
+	users := []string{}
+	for userId := range m.Files {
+		users = append(users, userId)
+	}
+	sort.Strings(users)
+
+	features := make(map[string][]types.FeatureReport)
+	errs := []string{}
+	for _, userId := range users {
+		for _, r := range m.Files[userId] {
+			if !r.Valid {
+				continue
+			}
+			start := time.Now()
+			report, err := backend.ExtractFeatures(r.File, o)
+			if err != nil {
+				errs = append(errs, err.Error())
+				logger.Error().Str("context", "recording").Str("user", userId).Str("file", filepath.Base(r.File)).Err(err).Msg("recording_features_failed")
+				continue
+			}
+			features[userId] = append(features[userId], report)
+			logger.Info().Str("context", "recording").Str("user", userId).Str("file", filepath.Base(r.File)).Int64("value", time.Since(start).Milliseconds()).Str("unit", "ms").Msg("recording_features_extracted")
+		}
+	}
+
+	m.Features = features
+	if err := writeManifestFile(dataFolder, m); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
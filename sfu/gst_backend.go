@@ -0,0 +1,72 @@
+//go:build cgo
+
+package sfu
+
+import (
+	"github.com/ducksouplab/ducksoup/gst"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/rs/zerolog"
+)
+
+func init() {
+	backend = gstBackend{}
+	defaultMediaProcessorFactory = newGstProcessor
+}
+
+func newGstProcessor(jp types.JoinPayload, receiverId string, plir types.PLIRequester, i *interaction, connectionCount int) MediaProcessor {
+	if len(receiverId) > 0 {
+		return gst.NewReceiverPipeline(jp, receiverId, plir, i.DataFolder(), i.randomId, connectionCount, i.logger)
+	}
+	return gst.NewPipeline(jp, plir, i.DataFolder(), i.randomId, connectionCount, i.logger)
+}
+
+type gstBackend struct{}
+
+func (gstBackend) VerifyFile(file string) types.FileReport {
+	return gst.VerifyFile(file)
+}
+
+func (gstBackend) ConcatSegments(segments []types.Segment, output string, width, height, framerate int) error {
+	return gst.ConcatSegments(segments, output, width, height, framerate)
+}
+
+func (gstBackend) Transcode(file, output string, width, height, framerate int) error {
+	return gst.Transcode(file, output, width, height, framerate)
+}
+
+func (gstBackend) Remux(file, cacheFolder string) error {
+	return gst.Remux(file, cacheFolder)
+}
+
+func (gstBackend) CompositeExtension() string {
+	return gst.CompositeExtension()
+}
+
+func (gstBackend) RenderComposite(inputs []types.CompositeInput, output string, width, height, framerate int) error {
+	return gst.RenderComposite(inputs, output, width, height, framerate)
+}
+
+func (gstBackend) RenderMultichannelWav(inputs []types.CompositeInput, output string) error {
+	return gst.RenderMultichannelWav(inputs, output)
+}
+
+func (gstBackend) ExtractFeatures(file string, o types.FeatureOptions) (types.FeatureReport, error) {
+	return gst.ExtractFeatures(file, o)
+}
+
+func (gstBackend) NewPlayer(o types.PlayerOptions, audioOutput, videoOutput types.SampleWriter, logger zerolog.Logger) (mediaPlayer, error) {
+	player, err := gst.NewPlayer(o, audioOutput, videoOutput, logger)
+	if err != nil {
+		// not a typed nil
+		return nil, err
+	}
+	return player, nil
+}
+
+func (gstBackend) Tracking(iRandomId, userId string) (tracking, ok bool) {
+	return gst.Tracking(iRandomId, userId)
+}
+
+func (gstBackend) DeleteTracking(iRandomId string) {
+	gst.DeleteTracking(iRandomId)
+}
@@ -1,7 +1,17 @@
 package sfu
 
 import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
 	"github.com/ducksouplab/ducksoup/types"
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
 )
 
 func newJoinPayload(origin, interactionName, userId, namespace string, size int) types.JoinPayload {
@@ -13,3 +23,374 @@ func newJoinPayload(origin, interactionName, userId, namespace string, size int)
 		Size:            size,
 	}
 }
+
+// end-to-end helpers
+
+const testOrigin = "https://origin"
+
+// interactions outlive tests, names are not reused when tests are run several times
+func uniqueName() string {
+	return "e2e-" + uuid.New().String()
+}
+
+// in bypass mode, media is forwarded by mixerSlices without GStreamer processing
+func newBypassJoinPayload(interactionName, userId string, size, duration int) types.JoinPayload {
+	jp := newJoinPayload(testOrigin, interactionName, userId, "test", size)
+	jp.RecordingMode = "bypass"
+	jp.VideoFormat = "VP8"
+	jp.Duration = duration
+	return jp
+}
+
+// with other recording modes, media is forwarded by fakeProcessors
+func newFakeJoinPayload(interactionName, userId string, size, duration int) types.JoinPayload {
+	jp := newBypassJoinPayload(interactionName, userId, size, duration)
+	jp.RecordingMode = "forced"
+	return jp
+}
+
+// fakeProcessor forwards media like passthroughProcessor and records fx and encoding
+// controls, it replaces GStreamer pipelines in tests (see TestMain)
+type fakeProcessor struct {
+	*passthroughProcessor
+	fxMu     sync.Mutex
+	fxProps  map[string]float32 // per "name.property"
+	bitrates map[string]int     // latest per kind
+}
+
+// processors per interaction name and user id (followed by ">" and the receiver id for
+// receiver processors)
+var fakeProcessorIndex sync.Map
+
+func newFakeProcessor(jp types.JoinPayload, receiverId string, plir types.PLIRequester, i *interaction, connectionCount int) MediaProcessor {
+	p := &fakeProcessor{
+		passthroughProcessor: newPassthroughProcessor(jp, receiverId, plir, i, connectionCount).(*passthroughProcessor),
+		fxProps:              make(map[string]float32),
+		bitrates:             make(map[string]int),
+	}
+	key := jp.InteractionName + "/" + jp.UserId
+	if len(receiverId) > 0 {
+		key += ">" + receiverId
+	}
+	fakeProcessorIndex.Store(key, p)
+	return p
+}
+
+func (p *fakeProcessor) SetFxPropFloat(name string, prop string, value float32) {
+	p.fxMu.Lock()
+	defer p.fxMu.Unlock()
+
+	p.fxProps[name+"."+prop] = value
+}
+
+func (p *fakeProcessor) GetFxPropFloat(name string, prop string) float32 {
+	p.fxMu.Lock()
+	defer p.fxMu.Unlock()
+
+	return p.fxProps[name+"."+prop]
+}
+
+func (p *fakeProcessor) SetEncodingBitrate(kind string, value int) {
+	p.fxMu.Lock()
+	defer p.fxMu.Unlock()
+
+	p.bitrates[kind] = value
+}
+
+// fakeMediaBackend only verifies that files exist (see TestMain)
+type fakeMediaBackend struct {
+	noMediaBackend
+}
+
+func (fakeMediaBackend) VerifyFile(file string) types.FileReport {
+	r := types.FileReport{File: file, Streams: []types.StreamReport{}}
+	info, err := os.Stat(file)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Size = info.Size()
+	r.Valid = r.Size > 0
+	return r
+}
+
+func fakeProcessorFor(t *testing.T, interactionName, userId string) *fakeProcessor {
+	t.Helper()
+
+	deadline := time.Now().Add(e2eTimeout)
+	for {
+		if p, ok := fakeProcessorIndex.Load(interactionName + "/" + userId); ok {
+			return p.(*fakeProcessor)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("[%v] no fake processor within %v", userId, e2eTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// testPeer is a pion client following the signaling of ducksoup.js, connected to
+// RunPeerServer through a localConn
+type testPeer struct {
+	sync.Mutex
+	t      *testing.T
+	jp     types.JoinPayload
+	conn   *localConn
+	pc     *webrtc.PeerConnection
+	tracks []*webrtc.TrackLocalStaticSample
+	// messages other than signaling ones, read by waitFor
+	messageCh chan localMessage
+	// signaling
+	answered          bool
+	offerCount        int
+	pendingCandidates []webrtc.ICECandidateInit
+	// ids of received tracks, once a first packet has been read
+	receivedIndex map[string]bool
+	closeOnce     sync.Once
+	doneCh        chan struct{}
+}
+
+func newTestPeer(t *testing.T, jp types.JoinPayload) *testPeer {
+	t.Helper()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("[%v] peer connection creation failed: %v", jp.UserId, err)
+	}
+	audioTrack, _ := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, uuid.New().String(), jp.UserId)
+	videoTrack, _ := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}, uuid.New().String(), jp.UserId)
+
+	p := &testPeer{
+		t:             t,
+		jp:            jp,
+		conn:          newLocalConn(),
+		pc:            pc,
+		tracks:        []*webrtc.TrackLocalStaticSample{audioTrack, videoTrack},
+		messageCh:     make(chan localMessage, 256),
+		receivedIndex: make(map[string]bool),
+		doneCh:        make(chan struct{}),
+	}
+	p.handleCallbacks()
+	t.Cleanup(p.close)
+	return p
+}
+
+func (p *testPeer) handleCallbacks() {
+	p.pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+		p.Lock()
+		defer p.Unlock()
+		if p.answered {
+			p.conn.send("client_ice_candidate", c.ToJSON())
+		} else {
+			p.pendingCandidates = append(p.pendingCandidates, c.ToJSON())
+		}
+	})
+	p.pc.OnTrack(func(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		for {
+			if _, _, err := remoteTrack.ReadRTP(); err != nil {
+				return
+			}
+			p.Lock()
+			p.receivedIndex[remoteTrack.ID()] = true
+			p.Unlock()
+		}
+	})
+}
+
+// joins and runs signaling in the background
+func (p *testPeer) join() *testPeer {
+	go RunPeerServer(testOrigin, p.conn)
+	if err := p.conn.send("join", p.jp); err != nil {
+		p.t.Fatalf("[%v] join failed: %v", p.jp.UserId, err)
+	}
+	go p.loop()
+	return p
+}
+
+func (p *testPeer) loop() {
+	for {
+		m, err := p.conn.receive()
+		if err != nil {
+			return
+		}
+		switch m.Kind {
+		case "offer":
+			if err := p.answer(m.Payload); err != nil {
+				select {
+				case <-p.doneCh:
+					// closed by the test
+				default:
+					p.t.Errorf("[%v] answer failed: %v", p.jp.UserId, err)
+				}
+				return
+			}
+		case "candidate":
+			candidate := webrtc.ICECandidateInit{}
+			if err := unmarshalTestPayload(m.Payload, &candidate); err == nil {
+				p.pc.AddICECandidate(candidate)
+			}
+		default:
+			select {
+			case p.messageCh <- m:
+			case <-p.doneCh:
+				return
+			}
+		}
+	}
+}
+
+// signaling payloads are JSON encoded strings
+func unmarshalTestPayload(payload json.RawMessage, v any) error {
+	var s string
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(s), v)
+}
+
+func (p *testPeer) answer(payload json.RawMessage) error {
+	offer := webrtc.SessionDescription{}
+	if err := unmarshalTestPayload(payload, &offer); err != nil {
+		return err
+	}
+	if err := p.pc.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+	p.Lock()
+	firstOffer := p.offerCount == 0
+	p.Unlock()
+	if firstOffer {
+		// reuses the transceivers created by the offer
+		for _, track := range p.tracks {
+			if _, err := p.pc.AddTrack(track); err != nil {
+				return err
+			}
+		}
+	}
+	answer, err := p.pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err := p.pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	if err := p.conn.send("client_answer", answer); err != nil {
+		return err
+	}
+
+	p.Lock()
+	p.answered = true
+	p.offerCount++
+	pending := p.pendingCandidates
+	p.pendingCandidates = nil
+	p.Unlock()
+	for _, c := range pending {
+		p.conn.send("client_ice_candidate", c)
+	}
+	if firstOffer {
+		go p.publish()
+	}
+	return nil
+}
+
+// sends Opus silence and (undecodable) VP8 frames
+func (p *testPeer) publish() {
+	audioTicker := time.NewTicker(20 * time.Millisecond)
+	videoTicker := time.NewTicker(time.Second / 30)
+	defer audioTicker.Stop()
+	defer videoTicker.Stop()
+	for frame := 0; ; {
+		select {
+		case <-audioTicker.C:
+			p.tracks[0].WriteSample(media.Sample{Data: []byte{0xf8, 0xff, 0xfe}, Duration: 20 * time.Millisecond})
+		case <-videoTicker.C:
+			data := make([]byte, 200)
+			if frame%30 != 0 {
+				data[0] = 0x01 // not a key frame
+			}
+			frame++
+			p.tracks[1].WriteSample(media.Sample{Data: data, Duration: time.Second / 30})
+		case <-p.doneCh:
+			return
+		}
+	}
+}
+
+func (p *testPeer) send(kind string, payload any) {
+	if err := p.conn.send(kind, payload); err != nil {
+		p.t.Errorf("[%v] send %v failed: %v", p.jp.UserId, kind, err)
+	}
+}
+
+// waits for a message of the given kind, skipping other ones
+func (p *testPeer) waitFor(kind string, timeout time.Duration) localMessage {
+	p.t.Helper()
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case m := <-p.messageCh:
+			if m.Kind == kind {
+				return m
+			}
+			if strings.HasPrefix(m.Kind, "error") {
+				p.t.Fatalf("[%v] received %v while waiting for %v", p.jp.UserId, m.Kind, kind)
+			}
+		case <-deadline:
+			p.t.Fatalf("[%v] %v not received within %v", p.jp.UserId, kind, timeout)
+		}
+	}
+}
+
+// waits for count tracks to be received (including past ones)
+func (p *testPeer) waitForTracks(count int, timeout time.Duration) {
+	p.t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		p.Lock()
+		received := len(p.receivedIndex)
+		p.Unlock()
+		if received >= count {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	p.t.Fatalf("[%v] %v tracks not received within %v", p.jp.UserId, count, timeout)
+}
+
+// waits for count offers to be answered (including past ones)
+func (p *testPeer) waitForOffers(count int, timeout time.Duration) {
+	p.t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		p.Lock()
+		offerCount := p.offerCount
+		p.Unlock()
+		if offerCount >= count && p.pc.SignalingState() == webrtc.SignalingStateStable {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	p.t.Fatalf("[%v] %v offers not answered within %v", p.jp.UserId, count, timeout)
+}
+
+func (p *testPeer) receivedTrackCount() int {
+	p.Lock()
+	defer p.Unlock()
+
+	return len(p.receivedIndex)
+}
+
+// like a closed browser tab
+func (p *testPeer) close() {
+	p.closeOnce.Do(func() {
+		close(p.doneCh)
+		p.conn.Close()
+		p.pc.Close()
+	})
+}
@@ -0,0 +1,73 @@
+package sfu
+
+import (
+	"errors"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+// network impairments between participants, applied by the engine.Impairer of each
+// receiver's peer connection
+
+const (
+	maxImpairmentDelay  = 5000 // ms
+	maxImpairmentJitter = 1000 // ms
+)
+
+func impairmentKey(from, to string) string {
+	return from + "#" + to
+}
+
+func validateImpairment(imp types.Impairment) error {
+	if len(imp.From) == 0 || len(imp.To) == 0 {
+		return errors.New("missing_user")
+	}
+	if imp.Delay < 0 || imp.Delay > maxImpairmentDelay {
+		return errors.New("invalid_delay")
+	}
+	if imp.Jitter < 0 || imp.Jitter > maxImpairmentJitter {
+		return errors.New("invalid_jitter")
+	}
+	if imp.Loss < 0 || imp.Loss > 1 {
+		return errors.New("invalid_loss")
+	}
+	return nil
+}
+
+// stores and applies (if imp.To is connected) an impairment, cause being
+// "join" or the user id of the client who requested it
+func (i *interaction) setImpairment(imp types.Impairment, cause string) error {
+	if err := validateImpairment(imp); err != nil {
+		i.logger.Error().Str("context", "peer").Str("from", imp.From).Str("toUser", imp.To).Str("cause", cause).Err(err).Msg("network_impairment_invalid")
+		return err
+	}
+
+	i.impairmentMu.Lock()
+	i.impairmentIndex[impairmentKey(imp.From, imp.To)] = imp
+	i.impairmentMu.Unlock()
+	if ps, connected := i.peerServerOf(imp.To); connected {
+		ps.pc.impairer.Set(imp)
+	}
+
+	i.logger.Info().
+		Str("context", "peer").
+		Str("from", imp.From).
+		Str("toUser", imp.To).
+		Str("cause", cause).
+		Int("delay", imp.Delay).
+		Int("jitter", imp.Jitter).
+		Float64("loss", imp.Loss).
+		Msg("network_impairment_updated")
+	return nil
+}
+
+// zero Impairment if none (not guarded by the interaction mutex, since called during signaling)
+func (i *interaction) impairmentOf(from, to string) types.Impairment {
+	i.impairmentMu.Lock()
+	defer i.impairmentMu.Unlock()
+
+	if imp, ok := i.impairmentIndex[impairmentKey(from, to)]; ok {
+		return imp
+	}
+	return types.Impairment{From: from, To: to}
+}
@@ -17,6 +17,9 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// DataRoot is the folder where interaction data and recordings are written
+const DataRoot = env.DataRoot
+
 const (
 	DefaultSize              = 2
 	MaxSize                  = 8
@@ -24,6 +27,8 @@ const (
 	MaxDurationInSeconds     = 1200
 	AbortLimitInSeconds      = 15
 	EndingInSeconds          = 15
+	FinalizeLimitInSeconds   = 60 // max wait for recordings to be finalized before verifying them
+	ManifestWaitInSeconds    = 20 // max wait for the manifest before sending files to peers
 )
 
 // interaction holds all the resources of a given experiment, accepting an exact number of *size* attendees
@@ -31,12 +36,20 @@ type interaction struct {
 	sync.RWMutex
 	// guarded by mutex
 	mixer               *mixer
-	peerServerIndex     map[string]*peerServer // per user id
-	connectedIndex      map[string]bool        // per user id, undefined: never connected, false: previously connected, true: connected
-	joinedCountIndex    map[string]int         // per user id
-	filesIndex          map[string][]string    // per user id, contains media file names
-	ready               bool                   // all in tracks are there
-	started             bool                   // changed once to show if interaction has been aborted or not
+	peerServerIndex     map[string]*peerServer    // per user id
+	connectedIndex      map[string]bool           // per user id, undefined: never connected, false: previously connected, true: connected
+	joinedCountIndex    map[string]int            // per user id
+	filesIndex          map[string][]string       // per user id, contains media file names
+	recordingDoneChs    []chan struct{}           // closed when pipelines have finalized their files
+	segmentsIndex       map[string][]segment      // per user id, files recorded during each connection
+	voiceActivityIndex  map[string]*voiceActivity // per user id
+	speakingEvents      *speakingQueue
+	confederateIndex    map[string]*confederate // per user id
+	couplings           []types.Coupling        // see coupling.go
+	couplingStopCh      chan struct{}           // closed when couplings are replaced
+	manifest            *manifest               // set once recordings have been verified
+	ready               bool                    // all in tracks are there
+	started             bool                    // changed once to show if interaction has been aborted or not
 	deleted             bool
 	createdAt           time.Time
 	startedAt           time.Time
@@ -48,6 +61,8 @@ type interaction struct {
 	startedCh chan struct{}
 	abortedCh chan struct{}
 	doneCh    chan struct{}
+	// closed when manifest is set
+	manifestCh chan struct{}
 	// other (written only during initialization)
 	id           string // origin+namespace+name, used for indexing in interactionStore
 	randomId     string // random internal id
@@ -59,6 +74,9 @@ type interaction struct {
 	ssrcs        []uint32
 	jp           types.JoinPayload
 	dataFolder   string
+	// network impairments, per from#to user ids (with their own mutex)
+	impairmentMu    sync.Mutex
+	impairmentIndex map[string]types.Impairment
 	// log
 	logger zerolog.Logger
 	// internals
@@ -137,6 +155,10 @@ func newInteraction(id string, jp types.JoinPayload) *interaction {
 	i := &interaction{
 		peerServerIndex:     make(map[string]*peerServer),
 		filesIndex:          make(map[string][]string),
+		segmentsIndex:       make(map[string][]segment),
+		voiceActivityIndex:  make(map[string]*voiceActivity),
+		confederateIndex:    make(map[string]*confederate),
+		impairmentIndex:     make(map[string]types.Impairment),
 		deleted:             false,
 		connectedIndex:      connectedIndex,
 		joinedCountIndex:    joinedCountIndex,
@@ -144,6 +166,7 @@ func newInteraction(id string, jp types.JoinPayload) *interaction {
 		startedCh:           make(chan struct{}),
 		abortedCh:           make(chan struct{}),
 		doneCh:              make(chan struct{}),
+		manifestCh:          make(chan struct{}),
 		createdAt:           time.Now(),
 		pipelineStartCount:  0,
 		inTracksReadyCount:  0,
@@ -157,7 +180,8 @@ func newInteraction(id string, jp types.JoinPayload) *interaction {
 		neededTracks:        neededTracks,
 		ssrcs:               []uint32{},
 		jp:                  jp,
-		dataFolder:          fmt.Sprintf("data/%v/%v", jp.Namespace, jp.InteractionName),
+		couplings:           jp.Couplings,
+		dataFolder:          fmt.Sprintf("%v/%v/%v", DataRoot, jp.Namespace, jp.InteractionName),
 		abortTimer:          time.NewTimer(time.Duration(AbortLimitInSeconds) * time.Second),
 	}
 	// create data folders
@@ -170,10 +194,15 @@ func newInteraction(id string, jp types.JoinPayload) *interaction {
 		helpers.EnsureDir("./" + i.dataFolder + "/cache")
 	}
 	i.mixer = newMixer(i)
+	i.speakingEvents = newSpeakingQueue(i.sendSpeakingEvent)
 	i.setLogger()
 
 	i.logger.Info().Str("context", "interaction").Str("user", jp.UserId).Str("origin", jp.Origin).Msg("interaction_created")
 	i.logger.Info().Str("context", "interaction").Str("user", jp.UserId).Interface("payload", jp).Msg("peer_joined")
+	for _, imp := range jp.Impairments {
+		i.setImpairment(imp, "join")
+	}
+	startConfederates(i, jp)
 
 	go i.abortCountdown()
 	return i
@@ -209,6 +238,10 @@ func (i *interaction) isDone() chan struct{} {
 	return i.doneCh
 }
 
+func (i *interaction) isManifestReady() chan struct{} {
+	return i.manifestCh
+}
+
 func (i *interaction) join(jp types.JoinPayload) (msg string, err error) {
 	i.Lock()
 	defer i.Unlock()
@@ -281,6 +314,7 @@ func (i *interaction) start() {
 			go ps.ws.sendWithPayload("start", i.remainingSeconds())
 		}
 		go i.gracefulCountdown()
+		i.unguardedStartCouplings()
 		close(i.startedCh)
 	}
 }
@@ -295,6 +329,7 @@ func (i *interaction) stop(graceful bool) {
 		i.logger.Info().Str("context", "interaction").Msg("interaction_aborted")
 	}
 	i.ready = false
+	go i.verifyRecordings()
 
 	<-time.After(3000 * time.Millisecond)
 	// most likely already deleted, see disconnectUser
@@ -402,6 +437,8 @@ func (i *interaction) connectPeerServer(ps *peerServer) {
 			other.userId,
 			other.streamId,
 		})
+		other.setReceiverConnected(ps.userId, true)
+		ps.setReceiverConnected(other.userId, true)
 	}
 	// add peer
 	i.peerServerIndex[ps.userId] = ps
@@ -415,6 +452,7 @@ func (i *interaction) unguardedDelete() {
 	i.deleted = true
 	interactionStoreSingleton.delete(i)
 	extLogger.DeleteLogger(i.randomId)
+	backend.DeleteTracking(i.randomId)
 	i.logger.Info().Str("context", "interaction").Msg("interaction_deleted")
 	// cleanup
 	for _, ssrc := range i.ssrcs {
@@ -436,9 +474,11 @@ func (i *interaction) disconnectUser(ps *peerServer) {
 				ps.userId,
 				ps.streamId,
 			})
+			other.setReceiverConnected(ps.userId, false)
 		}
 		// mark disconnected, but keep track of her
 		i.connectedIndex[ps.userId] = false
+		i.unguardedStopSpeaking(ps.userId)
 
 		// prevent useless signaling when aborting/ending room
 		if i.deleted {
@@ -454,11 +494,17 @@ func (i *interaction) disconnectUser(ps *peerServer) {
 	}
 }
 
-func (i *interaction) addFiles(userId string, files []string) {
+func (i *interaction) addFiles(jp types.JoinPayload, processor MediaProcessor) {
 	i.Lock()
 	defer i.Unlock()
 
+	userId := jp.UserId
+	files := processor.Files()
 	i.filesIndex[userId] = append(i.filesIndex[userId], files...)
+	i.recordingDoneChs = append(i.recordingDoneChs, processor.Done())
+	if len(files) > 0 {
+		i.segmentsIndex[userId] = append(i.segmentsIndex[userId], segment{jp, processor.SyncInfo().StartedAt, files, processor})
+	}
 }
 
 // API read
@@ -470,11 +516,11 @@ func (i *interaction) joinedCountForUser(userId string) int {
 	return i.joinedCountIndex[userId]
 }
 
-func (i *interaction) files() map[string][]string {
+func (i *interaction) getManifest() *manifest {
 	i.RLock()
 	defer i.RUnlock()
 
-	return i.filesIndex
+	return i.manifest
 }
 
 func (i *interaction) remainingSeconds() int {
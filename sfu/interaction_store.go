@@ -1,14 +1,19 @@
 package sfu
 
 import (
+	"errors"
 	"sync"
+	"sync/atomic"
 
 	"github.com/ducksouplab/ducksoup/types"
+	"github.com/rs/zerolog/log"
 )
 
 var (
 	// sfu package exposed singleton
 	interactionStoreSingleton *interactionStore
+	// when draining, new interactions are refused but users may still join running ones
+	draining atomic.Bool
 )
 
 type interactionStore struct {
@@ -34,6 +39,8 @@ func (is *interactionStore) join(jp types.JoinPayload) (*interaction, string, er
 	if i, ok := interactionStoreSingleton.index[interactionId]; ok {
 		msg, err := i.join(jp)
 		return i, msg, err
+	} else if draining.Load() {
+		return nil, "error", errors.New("draining")
 	} else {
 		// new user creates interaction
 		i := newInteraction(interactionId, jp)
@@ -48,3 +55,15 @@ func (is *interactionStore) delete(i *interaction) {
 
 	delete(is.index, i.id)
 }
+
+// SetDraining makes the server refuse (or accept again) new interactions, for instance
+// before it is stopped once running interactions are over
+func SetDraining(value bool) {
+	if draining.Swap(value) != value {
+		log.Info().Str("context", "app").Bool("value", value).Msg("draining_updated")
+	}
+}
+
+func Draining() bool {
+	return draining.Load()
+}
@@ -48,24 +48,23 @@ func TestJoinInteraction(t *testing.T) {
 	})
 
 	t.Run("Accept reconnections", func(t *testing.T) {
-		t.Skip()
-		// joinPayload1 := newJoinPayload("https://origin", "interaction-re", "user-1", "interaction", 2)
-		// joinPayload2 := newJoinPayload("https://origin", "interaction-re", "user-2", "interaction", 2)
-		// joinPayload2bis := newJoinPayload("https://origin", "interaction-re", "user-2", "interaction", 2)
-
-		// interactionStoreSingleton.join(joinPayload1)
-		// i, _, _ := interactionStoreSingleton.join(joinPayload2)
-		// ps2 := i.peerServerIndex[joinPayload2.UserId]
-
-		// i.disconnectUser(ps2) // TODO -> ps2 is empty
-		// _, msg, err := interactionStoreSingleton.join(joinPayload2bis)
-
-		// if err != nil {
-		// 	t.Error("interaction reconnection failed")
-		// }
-		// if msg != "reconnection" {
-		// 	t.Error("join does not provide reconnection context")
-		// }
+		joinPayload1 := newJoinPayload("https://origin", "interaction-re", "user-1", "interaction", 2)
+		joinPayload2 := newJoinPayload("https://origin", "interaction-re", "user-2", "interaction", 2)
+		joinPayload2bis := newJoinPayload("https://origin", "interaction-re", "user-2", "interaction", 2)
+
+		interactionStoreSingleton.join(joinPayload1)
+		i, _, _ := interactionStoreSingleton.join(joinPayload2)
+
+		// peerServers are not connected to the interaction here, see peer_server_test.go
+		i.disconnectUser(&peerServer{userId: joinPayload2.UserId})
+		_, msg, err := interactionStoreSingleton.join(joinPayload2bis)
+
+		if err != nil {
+			t.Error("interaction reconnection failed")
+		}
+		if msg != "reconnection" {
+			t.Error("join does not provide reconnection context")
+		}
 	})
 
 	t.Run("Reuse deleted interaction ids", func(t *testing.T) {
@@ -0,0 +1,217 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/helpers"
+	"github.com/ducksouplab/ducksoup/jobs"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// post-processing jobs, see jobs package. Payloads only refer to files on disk so
+// that jobs can be run after a restart
+
+const checksumsFile = "checksums.sha256"
+
+func init() {
+	jobs.Register("composite", compositeJob, validatePaths("dataFolder"))
+	jobs.Register("features", featuresJob, validatePaths("dataFolder"))
+	jobs.Register("checksum", checksumJob, validatePaths("dataFolder"))
+	jobs.Register("remux", remuxJob, validatePaths("dataFolder", "file"))
+	jobs.Register("transcode", transcodeJob, validatePaths("file", "output"))
+}
+
+// payload paths are relative to the working directory (as written by interactions,
+// for instance "data/namespace/name") and must resolve inside DataRoot, but not in
+// the jobs folder
+func checkDataPath(path string) error {
+	root, err := filepath.Abs(DataRoot)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.New("outside_data_folder")
+	}
+	jobsFolder, err := filepath.Abs(jobs.Folder())
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(jobsFolder, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.New("in_jobs_folder")
+	}
+	return nil
+}
+
+func validatePaths(keys ...string) jobs.Validator {
+	return func(payload map[string]string) error {
+		for _, key := range keys {
+			if len(payload[key]) == 0 {
+				return fmt.Errorf("missing_payload_%v", key)
+			}
+			if err := checkDataPath(payload[key]); err != nil {
+				return fmt.Errorf("invalid_payload_%v: %w", key, err)
+			}
+		}
+		return nil
+	}
+}
+
+func jobLogger(j jobs.Job) zerolog.Logger {
+	return log.With().
+		Str("namespace", j.Payload["namespace"]).
+		Str("interaction", j.Payload["interaction"]).
+		Str("job", j.Id).
+		Logger()
+}
+
+func payloadInt(j jobs.Job, key string, fallback int) int {
+	if value, err := strconv.Atoi(j.Payload[key]); err == nil {
+		return value
+	}
+	return fallback
+}
+
+func requirePayload(j jobs.Job, keys ...string) error {
+	for _, key := range keys {
+		if len(j.Payload[key]) == 0 {
+			return fmt.Errorf("missing_payload_%v", key)
+		}
+	}
+	return nil
+}
+
+// payload: dataFolder, filePrefix, state ("dry" or "wet") and optionally width, height, framerate, audioOnly
+func compositeJob(j jobs.Job) error {
+	if err := requirePayload(j, "dataFolder", "filePrefix", "state"); err != nil {
+		return err
+	}
+	return renderComposite(compositeOptions{
+		DataFolder: j.Payload["dataFolder"],
+		FilePrefix: j.Payload["filePrefix"],
+		State:      j.Payload["state"],
+		Width:      payloadInt(j, "width", defaultWidth),
+		Height:     payloadInt(j, "height", defaultHeight),
+		Framerate:  payloadInt(j, "framerate", defaultFramerate),
+		AudioOnly:  j.Payload["audioOnly"] == "true",
+	}, jobLogger(j))
+}
+
+// payload: dataFolder and optionally vadThreshold (dBFS) and vadHangover (ms), defaulting
+// to the VAD settings of config/sfu.yml
+func featuresJob(j jobs.Job) error {
+	if err := requirePayload(j, "dataFolder"); err != nil {
+		return err
+	}
+	threshold := config.SFU.VAD.Threshold
+	if value, err := strconv.ParseFloat(j.Payload["vadThreshold"], 64); err == nil {
+		threshold = value
+	}
+	return extractFeatures(j.Payload["dataFolder"], types.FeatureOptions{
+		VADThreshold: threshold,
+		VADHangover:  time.Duration(payloadInt(j, "vadHangover", config.SFU.VAD.Hangover)) * time.Millisecond,
+	}, jobLogger(j))
+}
+
+// payload: dataFolder. Writes the SHA-256 of all recordings to checksums.sha256
+// (same format as sha256sum) in the data folder
+func checksumJob(j jobs.Job) error {
+	if err := requirePayload(j, "dataFolder"); err != nil {
+		return err
+	}
+	dataFolder := j.Payload["dataFolder"]
+	files, err := filepath.Glob(dataFolder + "/recordings/*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	var b strings.Builder
+	for _, file := range files {
+		if info, err := os.Stat(file); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		sum, err := helpers.FileSHA256(file)
+		if err != nil {
+			return err
+		}
+		b.WriteString(sum + "  recordings/" + filepath.Base(file) + "\n")
+	}
+	return os.WriteFile(dataFolder+"/"+checksumsFile, []byte(b.String()), 0644)
+}
+
+// payload: dataFolder and file (fragmented mp4 to be remuxed to faststart)
+func remuxJob(j jobs.Job) error {
+	if err := requirePayload(j, "dataFolder", "file"); err != nil {
+		return err
+	}
+	return backend.Remux(j.Payload["file"], j.Payload["dataFolder"]+"/cache")
+}
+
+// payload: file, output and optionally width, height, framerate
+func transcodeJob(j jobs.Job) error {
+	if err := requirePayload(j, "file", "output"); err != nil {
+		return err
+	}
+	if filepath.Clean(j.Payload["file"]) == filepath.Clean(j.Payload["output"]) {
+		return errors.New("same_file_and_output")
+	}
+	return backend.Transcode(j.Payload["file"], j.Payload["output"], payloadInt(j, "width", 0), payloadInt(j, "height", 0), payloadInt(j, "framerate", defaultFramerate))
+}
+
+// called once recordings have been verified (and consolidated)
+func (i *interaction) enqueueJobs() {
+	i.RLock()
+	hasFiles := len(i.filesIndex) > 0
+	i.RUnlock()
+	if !hasFiles {
+		return
+	}
+
+	basePayload := func() map[string]string {
+		return map[string]string{
+			"namespace":   i.namespace,
+			"interaction": i.name,
+			"dataFolder":  i.dataFolder,
+		}
+	}
+	enqueue := func(kind string, payload map[string]string, dependsOn string) string {
+		j, err := jobs.Enqueue(kind, payload, dependsOn)
+		if err != nil {
+			i.logger.Error().Str("context", "recording").Str("kind", kind).Err(err).Msg("job_enqueue_failed")
+			return dependsOn
+		}
+		return j.Id
+	}
+
+	lastId := ""
+	if i.jp.Composite == "dry" || i.jp.Composite == "wet" {
+		payload := basePayload()
+		payload["filePrefix"] = "i-" + i.randomId + "-s-" + i.namespace + "-n-" + i.name
+		payload["state"] = i.jp.Composite
+		payload["width"] = strconv.Itoa(i.jp.Width)
+		payload["height"] = strconv.Itoa(i.jp.Height)
+		payload["framerate"] = strconv.Itoa(i.jp.Framerate)
+		payload["audioOnly"] = strconv.FormatBool(i.jp.AudioOnly)
+		lastId = enqueue("composite", payload, lastId)
+	}
+	if i.jp.Features {
+		lastId = enqueue("features", basePayload(), lastId)
+	}
+	// checksums cover files written by previous jobs
+	enqueue("checksum", basePayload(), lastId)
+}
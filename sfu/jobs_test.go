@@ -0,0 +1,24 @@
+package sfu
+
+import "testing"
+
+func TestCheckDataPath(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		valid bool
+	}{
+		{"data/ns/name", true},
+		{"data/ns/name/recordings/i-u-user-a-c-1-dry.mp4", true},
+		{"./data/ns/../ns/name", true},
+		{"data", false},
+		{"data/../main.go", false},
+		{"data/ns/../../etc/passwd", false},
+		{"/etc/passwd", false},
+		{"database/file", false},
+		{"data/jobs/id.json", false},
+	} {
+		if err := checkDataPath(tc.path); (err == nil) != tc.valid {
+			t.Errorf("%q: got error %v, expected valid=%v", tc.path, err, tc.valid)
+		}
+	}
+}
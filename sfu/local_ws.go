@@ -0,0 +1,198 @@
+package sfu
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// same error as gorilla when the connection drops, so that wsConn.receive closes the peerServer
+var errLocalConnClosed = &websocket.CloseError{Code: websocket.CloseAbnormalClosure, Text: "local conn closed"}
+
+// localConn is an in-process websocket for server-side virtual peers (see confederate.go):
+// the server side implements ws.IGorilla, so that the peer joins through RunPeerServer
+// like any other one, and the peer side uses send and receive
+type localConn struct {
+	closeOnce sync.Once
+	toServer  chan []byte
+	toPeer    chan []byte
+	closedCh  chan struct{}
+}
+
+// message as received by the peer side
+type localMessage struct {
+	Kind    string          `json:"kind"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type localWriter struct {
+	conn *localConn
+	buf  bytes.Buffer
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *localWriter) Close() error {
+	return w.conn.WriteMessage(websocket.TextMessage, w.buf.Bytes())
+}
+
+func newLocalConn() *localConn {
+	return &localConn{
+		toServer: make(chan []byte, 64),
+		toPeer:   make(chan []byte, 64),
+		closedCh: make(chan struct{}),
+	}
+}
+
+func (c *localConn) isClosed() chan struct{} {
+	return c.closedCh
+}
+
+// peer side
+
+// payload is JSON encoded (as a string) like browsers do, see messageIn
+func (c *localConn) send(kind string, payload any) error {
+	m := messageIn{Kind: kind}
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		m.Payload = string(b)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-c.closedCh:
+		return errLocalConnClosed
+	default:
+	}
+	select {
+	case c.toServer <- b:
+		return nil
+	case <-c.closedCh:
+		return errLocalConnClosed
+	}
+}
+
+func (c *localConn) receive() (m localMessage, err error) {
+	b, err := c.read(c.toPeer)
+	if err == nil {
+		err = json.Unmarshal(b, &m)
+	}
+	return
+}
+
+// messages written before closing are still delivered, like the server "end" one
+func (c *localConn) read(ch chan []byte) ([]byte, error) {
+	select {
+	case b := <-ch:
+		return b, nil
+	case <-c.closedCh:
+		select {
+		case b := <-ch:
+			return b, nil
+		default:
+			return nil, errLocalConnClosed
+		}
+	}
+}
+
+// server side (ws.IGorilla)
+
+func (c *localConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closedCh)
+	})
+	return nil
+}
+
+func (c *localConn) ReadMessage() (messageType int, p []byte, err error) {
+	if p, err = c.read(c.toServer); err != nil {
+		return -1, nil, err
+	}
+	return websocket.TextMessage, p, nil
+}
+
+func (c *localConn) ReadJSON(v any) error {
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+func (c *localConn) WriteMessage(messageType int, data []byte) error {
+	select {
+	case <-c.closedCh:
+		return errLocalConnClosed
+	default:
+	}
+	select {
+	case c.toPeer <- append([]byte{}, data...):
+		return nil
+	case <-c.closedCh:
+		return errLocalConnClosed
+	}
+}
+
+func (c *localConn) WriteJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(websocket.TextMessage, b)
+}
+
+func (c *localConn) NextReader() (messageType int, r io.Reader, err error) {
+	messageType, p, err := c.ReadMessage()
+	return messageType, bytes.NewReader(p), err
+}
+
+func (c *localConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return &localWriter{conn: c}, nil
+}
+
+func (c *localConn) WritePreparedMessage(pm *websocket.PreparedMessage) error {
+	return errors.New("prepared messages are not supported")
+}
+
+func (c *localConn) CloseHandler() func(code int, text string) error {
+	return func(code int, text string) error {
+		return c.Close()
+	}
+}
+
+func (c *localConn) PingHandler() func(appData string) error {
+	return func(appData string) error { return nil }
+}
+
+func (c *localConn) PongHandler() func(appData string) error {
+	return func(appData string) error { return nil }
+}
+
+func (c *localConn) LocalAddr() net.Addr                                 { return &net.IPAddr{} }
+func (c *localConn) RemoteAddr() net.Addr                                { return &net.IPAddr{} }
+func (c *localConn) UnderlyingConn() net.Conn                            { return nil }
+func (c *localConn) Subprotocol() string                                 { return "" }
+func (c *localConn) EnableWriteCompression(enable bool)                  {}
+func (c *localConn) SetCompressionLevel(level int) error                 { return nil }
+func (c *localConn) SetCloseHandler(h func(code int, text string) error) {}
+func (c *localConn) SetPingHandler(h func(appData string) error)         {}
+func (c *localConn) SetPongHandler(h func(appData string) error)         {}
+func (c *localConn) SetReadDeadline(t time.Time) error                   { return nil }
+func (c *localConn) SetWriteDeadline(t time.Time) error                  { return nil }
+func (c *localConn) SetReadLimit(limit int64)                            {}
+func (c *localConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLocalConnClosed(t *testing.T) {
+	c := newLocalConn()
+	c.Close()
+
+	// wsConn.receive only closes the peerServer on unexpected close errors
+	_, _, err := c.ReadMessage()
+	if !websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		t.Errorf("ReadMessage: expected an unexpected close error, got %v", err)
+	}
+	if err := c.WriteMessage(websocket.TextMessage, []byte("{}")); err == nil {
+		t.Error("WriteMessage: expected an error once closed")
+	}
+	if err := c.send("join", nil); err == nil {
+		t.Error("send: expected an error once closed")
+	}
+}
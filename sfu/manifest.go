@@ -0,0 +1,129 @@
+package sfu
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+// manifest describes the recordings of an interaction once they have been verified,
+// it is written to manifest.json in the interaction data folder
+type manifest struct {
+	Namespace       string                        `json:"namespace"`
+	InteractionName string                        `json:"interactionName"`
+	CreatedAt       time.Time                     `json:"createdAt"`
+	StartedAt       time.Time                     `json:"startedAt"`
+	VerifiedAt      time.Time                     `json:"verifiedAt"`
+	Valid           bool                          `json:"valid"` // true if all files are valid
+	Files           map[string][]types.FileReport `json:"files"` // per user id
+	// per user id, files concatenated across connections
+	Consolidated map[string][]consolidatedFile `json:"consolidated,omitempty"`
+	// all participants aligned in a single video and a multichannel WAV
+	Composite *compositeRender `json:"composite,omitempty"`
+	// per user id, audio features and video statistics extracted from recordings
+	Features map[string][]types.FeatureReport `json:"features,omitempty"`
+}
+
+// reports sent to peers with the files event: verification results if the manifest
+// is ready, otherwise files are listed with a "not_verified" error
+func (i *interaction) fileReports() map[string][]types.FileReport {
+	i.RLock()
+	defer i.RUnlock()
+
+	if i.manifest != nil {
+		return i.manifest.Files
+	}
+	reports := make(map[string][]types.FileReport)
+	for userId, files := range i.filesIndex {
+		for _, file := range files {
+			reports[userId] = append(reports[userId], types.FileReport{File: file, Error: "not_verified", Streams: []types.StreamReport{}})
+		}
+	}
+	return reports
+}
+
+func writeManifestFile(dataFolder string, m *manifest) error {
+	formatted, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dataFolder+"/manifest.json", append(formatted, '\n'), 0644)
+}
+
+func readManifestFile(dataFolder string) (*manifest, error) {
+	data, err := os.ReadFile(dataFolder + "/manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (i *interaction) writeManifest(m *manifest) {
+	if err := writeManifestFile(i.dataFolder, m); err != nil {
+		i.logger.Error().Str("context", "recording").Err(err).Msg("manifest_write_failed")
+	}
+}
+
+// waits for pipelines to finalize their recordings (with a time limit), then
+// verifies every recorded file and writes the manifest
+func (i *interaction) verifyRecordings() {
+	i.RLock()
+	recordingDoneChs := append([]chan struct{}{}, i.recordingDoneChs...)
+	filesIndex := make(map[string][]string)
+	for userId, files := range i.filesIndex {
+		filesIndex[userId] = append([]string{}, files...)
+	}
+	i.RUnlock()
+
+	timeout := time.After(FinalizeLimitInSeconds * time.Second)
+wait:
+	for _, doneCh := range recordingDoneChs {
+		select {
+		case <-doneCh:
+		case <-timeout:
+			i.logger.Error().Str("context", "recording").Msg("recordings_finalize_timeout")
+			break wait
+		}
+	}
+
+	i.writeSyncSidecars()
+
+	m := &manifest{
+		Namespace:       i.namespace,
+		InteractionName: i.name,
+		CreatedAt:       i.createdAt,
+		StartedAt:       i.startedAt,
+		Valid:           true,
+		Files:           make(map[string][]types.FileReport),
+	}
+	for userId, files := range filesIndex {
+		reports := []types.FileReport{}
+		for _, file := range files {
+			r := backend.VerifyFile(file)
+			if !r.Valid {
+				m.Valid = false
+				i.logger.Error().Str("context", "recording").Str("user", userId).Str("file", file).Str("cause", r.Error).Msg("recording_invalid")
+			}
+			reports = append(reports, r)
+		}
+		m.Files[userId] = reports
+	}
+	m.VerifiedAt = time.Now()
+
+	i.writeManifest(m)
+	i.logger.Info().Str("context", "recording").Bool("valid", m.Valid).Msg("manifest_written")
+
+	i.Lock()
+	i.manifest = m
+	i.Unlock()
+	close(i.manifestCh)
+
+	i.consolidateRecordings(m)
+	i.enqueueJobs()
+}
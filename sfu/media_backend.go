@@ -0,0 +1,86 @@
+package sfu
+
+import (
+	"errors"
+
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/rs/zerolog"
+)
+
+// mediaBackend decodes, renders and analyzes media files and plays them for confederates.
+// It is implemented with GStreamer by gstBackend (see gst_backend.go, built with cgo
+// only), so that this package (and its tests) can be built without cgo
+type mediaBackend interface {
+	VerifyFile(file string) types.FileReport
+	ConcatSegments(segments []types.Segment, output string, width, height, framerate int) error
+	Transcode(file, output string, width, height, framerate int) error
+	Remux(file, cacheFolder string) error
+	CompositeExtension() string
+	RenderComposite(inputs []types.CompositeInput, output string, width, height, framerate int) error
+	RenderMultichannelWav(inputs []types.CompositeInput, output string) error
+	ExtractFeatures(file string, o types.FeatureOptions) (types.FeatureReport, error)
+	NewPlayer(o types.PlayerOptions, audioOutput, videoOutput types.SampleWriter, logger zerolog.Logger) (mediaPlayer, error)
+	// face tracking states reported by processors
+	Tracking(iRandomId, userId string) (tracking, ok bool)
+	DeleteTracking(iRandomId string)
+}
+
+// mediaPlayer reads a media file in real time and encodes it, see confederate
+type mediaPlayer interface {
+	Start()
+	Play()
+	Pause()
+	Rewind()
+	RequestKeyFrame()
+	Stop()
+}
+
+var errNoMediaBackend = errors.New("no_media_backend")
+
+// backend is replaced by gstBackend when built with cgo
+var backend mediaBackend = noMediaBackend{}
+
+// noMediaBackend fails on everything but tracking (never reported)
+type noMediaBackend struct{}
+
+func (noMediaBackend) VerifyFile(file string) types.FileReport {
+	return types.FileReport{File: file, Error: errNoMediaBackend.Error(), Streams: []types.StreamReport{}}
+}
+
+func (noMediaBackend) ConcatSegments(segments []types.Segment, output string, width, height, framerate int) error {
+	return errNoMediaBackend
+}
+
+func (noMediaBackend) Transcode(file, output string, width, height, framerate int) error {
+	return errNoMediaBackend
+}
+
+func (noMediaBackend) Remux(file, cacheFolder string) error {
+	return errNoMediaBackend
+}
+
+func (noMediaBackend) CompositeExtension() string {
+	return "mp4"
+}
+
+func (noMediaBackend) RenderComposite(inputs []types.CompositeInput, output string, width, height, framerate int) error {
+	return errNoMediaBackend
+}
+
+func (noMediaBackend) RenderMultichannelWav(inputs []types.CompositeInput, output string) error {
+	return errNoMediaBackend
+}
+
+func (noMediaBackend) ExtractFeatures(file string, o types.FeatureOptions) (types.FeatureReport, error) {
+	return types.FeatureReport{}, errNoMediaBackend
+}
+
+func (noMediaBackend) NewPlayer(o types.PlayerOptions, audioOutput, videoOutput types.SampleWriter, logger zerolog.Logger) (mediaPlayer, error) {
+	return nil, errNoMediaBackend
+}
+
+func (noMediaBackend) Tracking(iRandomId, userId string) (tracking, ok bool) {
+	return
+}
+
+func (noMediaBackend) DeleteTracking(iRandomId string) {}
@@ -0,0 +1,61 @@
+package sfu
+
+import (
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+// MediaProcessor processes (applies fx, encodes, records...) the RTP streams of a user
+// before they are written to output tracks. It is either the stream sent to all other
+// users or, when a receiver id is given, the stream sent to this receiver only
+type MediaProcessor interface {
+	// output tracks are bound once processing may start: when all kinds are bound,
+	// processing starts and Started() is closed
+	BindTrackAutoStart(kind string, t types.TrackWriter)
+	Started() chan struct{}
+	// closed once processing is stopped and recordings are finalized
+	Done() chan struct{}
+	PushRTP(kind string, buffer []byte)
+	PushRTCP(kind string, buffer []byte)
+	SendPLI()
+	SetEncodingBitrate(kind string, value int)
+	SetFxPropFloat(name string, prop string, value float32)
+	GetFxPropFloat(name string, prop string) float32
+	SetFxPolyProp(name string, prop string, kind string, value string)
+	GetCurrentLevelTime(name string) uint64
+	// recording files, known once started
+	Files() []string
+	SyncInfo() types.SyncInfo
+	// called once per kind, processing stops when all kinds are stopped
+	Stop()
+}
+
+type mediaProcessorFactory func(jp types.JoinPayload, receiverId string, plir types.PLIRequester, i *interaction, connectionCount int) MediaProcessor
+
+// processors by recording mode, other modes use defaultMediaProcessorFactory
+var mediaProcessorFactories = map[string]mediaProcessorFactory{
+	"bypass": newPassthroughProcessor,
+}
+
+// processes with GStreamer, set when built with cgo (see gst_backend.go)
+var defaultMediaProcessorFactory mediaProcessorFactory
+
+// receiverId is empty for the processor of the stream sent to all other users. Without
+// a processor for the recording mode (GStreamer being unavailable), media is forwarded
+// untouched and recorded like in bypass mode, fx being ignored
+func newMediaProcessor(jp types.JoinPayload, receiverId string, plir types.PLIRequester, i *interaction, connectionCount int) MediaProcessor {
+	if factory, ok := mediaProcessorFactories[jp.RecordingMode]; ok {
+		return factory(jp, receiverId, plir, i, connectionCount)
+	}
+	if defaultMediaProcessorFactory == nil {
+		hasFx := len(jp.AudioFx) > 0 || len(jp.VideoFx) > 0 || len(jp.ReceiverFx) > 0
+		i.logger.Warn().
+			Str("context", "peer").
+			Str("user", jp.UserId).
+			Str("toUser", receiverId).
+			Str("mode", jp.RecordingMode).
+			Bool("hasFx", hasFx).
+			Msg("media_processor_unavailable")
+		return newPassthroughProcessor(jp, receiverId, plir, i, connectionCount)
+	}
+	return defaultMediaProcessorFactory(jp, receiverId, plir, i, connectionCount)
+}
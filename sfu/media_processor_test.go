@@ -0,0 +1,32 @@
+package sfu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/rs/zerolog"
+)
+
+func TestNewMediaProcessorUnavailable(t *testing.T) {
+	defer func(factory mediaProcessorFactory) { defaultMediaProcessorFactory = factory }(defaultMediaProcessorFactory)
+	defaultMediaProcessorFactory = nil
+
+	var out bytes.Buffer
+	i := &interaction{logger: zerolog.New(&out), dataFolder: t.TempDir()}
+
+	p := newMediaProcessor(types.JoinPayload{UserId: "alice", RecordingMode: "bypass"}, "", nil, i, 1)
+	if _, ok := p.(*passthroughProcessor); !ok || out.Len() > 0 {
+		t.Errorf("bypass mode: got %T and logs %q", p, out.String())
+	}
+
+	jp := types.JoinPayload{UserId: "alice", RecordingMode: "split", AudioFx: types.Fx{{Name: "pitch"}}}
+	p = newMediaProcessor(jp, "", nil, i, 1)
+	if _, ok := p.(*passthroughProcessor); !ok {
+		t.Errorf("split mode: got %T, expected passthrough", p)
+	}
+	if logs := out.String(); !strings.Contains(logs, `"media_processor_unavailable"`) || !strings.Contains(logs, `"hasFx":true`) {
+		t.Errorf("split mode: missing warning in %q", logs)
+	}
+}
@@ -0,0 +1,167 @@
+package sfu
+
+import (
+	"github.com/ducksouplab/ducksoup/metrics"
+)
+
+// inbound message kinds handled by peerServer#loop (and the join message), other kinds
+// are counted as "unknown" since clients may send anything
+var knownInboundKinds = map[string]bool{
+	"join":                      true,
+	"client_ice_candidate":      true,
+	"client_answer":             true,
+	"client_negotiation_needed": true,
+	"client_ice_connection_state_disconnected": true,
+	"client_selected_candidate_pair":           true,
+	"client_control":                           true,
+	"client_av_offset":                         true,
+	"client_mirror_delay":                      true,
+	"client_confederate":                       true,
+	"client_impair":                            true,
+	"client_polycontrol":                       true,
+	"client_video_resolution_updated":          true,
+	"client_video_fps_updated":                 true,
+	"client_keyframe_encoded_count_updated":    true,
+	"stop":                                     true,
+}
+
+// join messages returned by interactionStore#join
+var joinOutcomes = map[string]string{
+	"new_interaction":      "new",
+	"existing-interaction": "existing",
+	"reconnection":         "reconnection",
+}
+
+var (
+	joinsTotal = metrics.NewCounterVec(
+		"ducksoup_joins_total",
+		"Joins by outcome (new, existing, reconnection, full, duplicate).",
+		"outcome")
+	pliRequestsTotal = metrics.NewCounterVec(
+		"ducksoup_pli_requests_total",
+		"PLI requests sent to video senders, by cause.",
+		"cause")
+	rtcpFractionLost = metrics.NewSummaryVec(
+		"ducksoup_rtcp_fraction_lost",
+		"Fraction lost (0 to 1) reported by receivers in RTCP receiver reports, by kind.",
+		"kind")
+	wsMessagesTotal = metrics.NewCounterVec(
+		"ducksoup_ws_messages_total",
+		"Websocket signaling messages, by direction (in, out) and kind.",
+		"direction", "kind")
+)
+
+func init() {
+	metrics.NewGaugeFunc(
+		"ducksoup_interactions",
+		"Interactions by state (waiting, running, ending).",
+		collectInteractions,
+		"state")
+	metrics.NewGaugeFunc(
+		"ducksoup_peers_connected",
+		"Users currently connected to an interaction.",
+		collectPeersConnected)
+	metrics.NewGaugeFunc(
+		"ducksoup_input_bitrate_bits_per_second",
+		"Sum of input bitrates of tracks received from users, by kind.",
+		collectBitrates(func(ms *mixerSlice) int { return ms.inputBitrate }),
+		"kind")
+	metrics.NewGaugeFunc(
+		"ducksoup_output_bitrate_bits_per_second",
+		"Sum of output bitrates of processed tracks, by kind.",
+		collectBitrates(func(ms *mixerSlice) int { return ms.outputBitrate }),
+		"kind")
+	metrics.NewGaugeFunc(
+		"ducksoup_target_bitrate_bits_per_second",
+		"Sum of encoding target bitrates of processed tracks, by kind.",
+		collectBitrates(func(ms *mixerSlice) int { return ms.targetBitrate }),
+		"kind")
+}
+
+func countJoin(msg string, err error) {
+	if err != nil {
+		joinsTotal.Inc(err.Error())
+	} else if outcome, ok := joinOutcomes[msg]; ok {
+		joinsTotal.Inc(outcome)
+	}
+}
+
+func countInboundMessage(kind string) {
+	if !knownInboundKinds[kind] {
+		kind = "unknown"
+	}
+	wsMessagesTotal.Inc("in", kind)
+}
+
+func countOutboundMessage(kind string) {
+	wsMessagesTotal.Inc("out", kind)
+}
+
+// locks are not nested (store, then each interaction) not to interfere with signaling
+func listInteractions() []*interaction {
+	interactionStoreSingleton.Lock()
+	defer interactionStoreSingleton.Unlock()
+
+	interactions := make([]*interaction, 0, len(interactionStoreSingleton.index))
+	for _, i := range interactionStoreSingleton.index {
+		interactions = append(interactions, i)
+	}
+	return interactions
+}
+
+func (i *interaction) state() string {
+	select {
+	case <-i.doneCh:
+		return "ending"
+	case <-i.abortedCh:
+		return "ending"
+	default:
+	}
+	i.RLock()
+	defer i.RUnlock()
+	if i.started {
+		return "running"
+	}
+	return "waiting"
+}
+
+func collectInteractions(set func(value float64, labelValues ...string)) {
+	// always exposes all states
+	for _, state := range []string{"waiting", "running", "ending"} {
+		set(0, state)
+	}
+	for _, i := range listInteractions() {
+		set(1, i.state())
+	}
+}
+
+func collectPeersConnected(set func(value float64, labelValues ...string)) {
+	set(0)
+	for _, i := range listInteractions() {
+		i.RLock()
+		set(float64(i.unguardedConnectedUserCount()))
+		i.RUnlock()
+	}
+}
+
+func collectBitrates(get func(ms *mixerSlice) int) func(set func(value float64, labelValues ...string)) {
+	return func(set func(value float64, labelValues ...string)) {
+		for _, kind := range []string{"audio", "video"} {
+			set(0, kind)
+		}
+		for _, i := range listInteractions() {
+			i.mixer.RLock()
+			slices := make([]*mixerSlice, 0, len(i.mixer.sliceIndex))
+			for _, ms := range i.mixer.sliceIndex {
+				slices = append(slices, ms)
+			}
+			i.mixer.RUnlock()
+
+			for _, ms := range slices {
+				ms.Lock()
+				set(float64(get(ms)), ms.kind)
+				ms.Unlock()
+			}
+		}
+	}
+}
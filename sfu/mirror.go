@@ -0,0 +1,48 @@
+package sfu
+
+import "github.com/ducksouplab/ducksoup/types"
+
+// when size is 1, the interaction acts as a mirror (see prepareOutTracks): the
+// user's own tracks are sent back, possibly delayed (delayed auditory and visual
+// feedback) thanks to the same delay lines as A/V offsets, without reencoding
+
+const maxMirrorDelay = 5000 // ms
+
+func clampMirrorDelay(delay int) int {
+	return max(0, min(delay, maxMirrorDelay))
+}
+
+func mirrorDelayFor(i *interaction, jp types.JoinPayload) int {
+	if i.size != 1 {
+		return 0
+	}
+	return clampMirrorDelay(jp.MirrorDelay)
+}
+
+func (ps *peerServer) setMirrorDelay(delay float32) {
+	ps.Lock()
+	ps.mirrorDelay = delay
+	ps.Unlock()
+	ps.applyOutputDelays()
+}
+
+// updates the mirror delay, with a linear ramp if duration (in ms) is not 0
+func (ps *peerServer) controlMirrorDelay(payload mirrorDelayPayload) {
+	if ps.i.size != 1 {
+		ps.logError().Str("context", "track").Int("value", payload.Delay).Str("unit", "ms").Msg("mirror_delay_ignored")
+		return
+	}
+	delay := clampMirrorDelay(payload.Delay)
+	duration := min(payload.Duration, maxInterpolatorDuration)
+	ps.logInfo().
+		Str("context", "track").
+		Int("value", delay).
+		Str("unit", "ms").
+		Int("duration", duration).
+		Msg("mirror_delay_updated")
+
+	ps.Lock()
+	current := ps.mirrorDelay
+	ps.Unlock()
+	ps.rampOutputDelay("mirror_delay", current, float32(delay), duration, ps.setMirrorDelay)
+}
@@ -10,7 +10,6 @@ import (
 
 	"github.com/ducksouplab/ducksoup/config"
 	"github.com/ducksouplab/ducksoup/env"
-	"github.com/ducksouplab/ducksoup/gst"
 	"github.com/ducksouplab/ducksoup/plot"
 	"github.com/ducksouplab/ducksoup/sequencing"
 	"github.com/pion/webrtc/v3"
@@ -37,17 +36,23 @@ type mixerSlice struct {
 	kind         string
 	streamConfig config.SFUStream
 	// webrtc
-	input    *webrtc.TrackRemote
-	output   *webrtc.TrackLocalStaticRTP
-	receiver *webrtc.RTPReceiver
+	input           *webrtc.TrackRemote
+	output          *webrtc.TrackLocalStaticRTP
+	receiverOutputs map[string]*webrtc.TrackLocalStaticRTP // per receiver user id, see receiver_processors.go
+	// delay lines before writing to output tracks (see desync.go)
+	outputDelay    *delayLine
+	receiverDelays map[string]*delayLine
+	receiver       *webrtc.RTPReceiver
 	// processing
-	pipeline          *gst.Pipeline
+	processor         MediaProcessor
 	interpolatorIndex map[string]*sequencing.LinearInterpolator
 	// controller
 	senderControllerIndex map[string]*senderController // per user id
 	targetBitrate         int
 	// plots
 	plotBuffers bool
+	// live voice activity of audio slices (see speaking.go)
+	voiceActivity *voiceActivity
 	// stats
 	lastStats     time.Time
 	inputBits     int
@@ -61,6 +66,21 @@ type mixerSlice struct {
 	plot *plot.SlicePlot
 }
 
+// receiverOutput lets a receiver processor write to the track sent to its receiver
+type receiverOutput struct {
+	track *webrtc.TrackLocalStaticRTP
+	delay *delayLine
+}
+
+func (ro *receiverOutput) ID() string {
+	return ro.track.ID()
+}
+
+func (ro *receiverOutput) Write(buf []byte) (err error) {
+	_, err = ro.delay.Write(buf)
+	return
+}
+
 // helpers
 
 func minInt(v []int) (min int) {
@@ -90,7 +110,7 @@ func newMixerSlice(ps *peerServer, remoteTrack *webrtc.TrackRemote, receiver *we
 		streamConfig = config.SFU.Audio
 	} else {
 		err := errors.New("invalid kind")
-		ms.logError().Str("context", "track").Err(err).Msg("new_mixer_slice_failed")
+		ps.logError().Str("context", "track").Err(err).Msg("new_mixer_slice_failed")
 		return nil, err
 	}
 
@@ -98,21 +118,33 @@ func newMixerSlice(ps *peerServer, remoteTrack *webrtc.TrackRemote, receiver *we
 	localTrack, err := webrtc.NewTrackLocalStaticRTP(remoteTrack.Codec().RTPCodecCapability, newId, ps.streamId)
 
 	if err != nil {
-		ms.logError().Str("context", "track").Err(err).Msg("new_mixer_slice_failed")
+		ps.logError().Str("context", "track").Err(err).Msg("new_mixer_slice_failed")
 		return
 	}
 
+	// same ID and stream ID since they are sent on different peer connections
+	receiverOutputs := make(map[string]*webrtc.TrackLocalStaticRTP)
+	for receiverId := range ps.jp.ReceiverFx {
+		receiverTrack, err := webrtc.NewTrackLocalStaticRTP(remoteTrack.Codec().RTPCodecCapability, newId, ps.streamId)
+		if err != nil {
+			ps.logError().Str("context", "track").Err(err).Msg("new_mixer_slice_failed")
+			return nil, err
+		}
+		receiverOutputs[receiverId] = receiverTrack
+	}
+
 	ms = &mixerSlice{
 		fromPs:       ps,
 		i:            ps.i,
 		kind:         kind,
 		streamConfig: streamConfig,
 		// webrtc
-		input:    remoteTrack,
-		output:   localTrack,
-		receiver: receiver, // TODO read RTCP?
+		input:           remoteTrack,
+		output:          localTrack,
+		receiverOutputs: receiverOutputs,
+		receiver:        receiver, // TODO read RTCP?
 		// processing
-		pipeline:          ps.pipeline,
+		processor:         ps.processor,
 		interpolatorIndex: make(map[string]*sequencing.LinearInterpolator),
 		// controller
 		senderControllerIndex: map[string]*senderController{},
@@ -123,6 +155,14 @@ func newMixerSlice(ps *peerServer, remoteTrack *webrtc.TrackRemote, receiver *we
 		// status
 		doneCh: make(chan struct{}),
 	}
+	if kind == "audio" {
+		ms.voiceActivity = ps.i.voiceActivityFor(ps.userId)
+	}
+	ms.outputDelay = newDelayLine(localTrack.Write, ms.doneCh)
+	ms.receiverDelays = make(map[string]*delayLine)
+	for receiverId, receiverTrack := range receiverOutputs {
+		ms.receiverDelays[receiverId] = newDelayLine(receiverTrack.Write, ms.doneCh)
+	}
 	// analysis
 	if env.GeneratePlots {
 		ms.plot = plot.NewSlicePlot(ms, kind, ms.plotBuffers, ps.userId, ps.i.DataFolder()+"/plots")
@@ -156,6 +196,19 @@ func (ms *mixerSlice) ID() string {
 	return ms.output.ID()
 }
 
+// track sent to toUserId, either specific to this receiver or shared
+func (ms *mixerSlice) outputFor(toUserId string) *webrtc.TrackLocalStaticRTP {
+	if output, ok := ms.receiverOutputs[toUserId]; ok {
+		return output
+	}
+	return ms.output
+}
+
+// output of the processor dedicated to receiverId
+func (ms *mixerSlice) receiverOutputFor(receiverId string) *receiverOutput {
+	return &receiverOutput{ms.receiverOutputs[receiverId], ms.receiverDelays[receiverId]}
+}
+
 func (ms *mixerSlice) addSender(pc *peerConn, sender *webrtc.RTPSender) {
 	params := sender.GetParameters()
 
@@ -185,7 +238,7 @@ func (l *mixerSlice) updateInputBits(n int) {
 }
 
 func (ms *mixerSlice) Write(buf []byte) (err error) {
-	n, err := ms.output.Write(buf)
+	n, err := ms.outputDelay.Write(buf)
 
 	if err == nil {
 		ms.Lock()
@@ -197,8 +250,17 @@ func (ms *mixerSlice) Write(buf []byte) (err error) {
 	return
 }
 
+func (ms *mixerSlice) setOutputDelay(delay time.Duration) {
+	ms.outputDelay.setDelay(delay)
+	for _, dl := range ms.receiverDelays {
+		dl.setDelay(delay)
+	}
+}
+
 func (ms *mixerSlice) close() {
-	ms.pipeline.Stop()
+	ms.processor.Stop()
+	ms.fromPs.unbindReceiverSlice(ms)
+	ms.fromPs.capture.stop(ms.kind)
 	close(ms.doneCh)
 	ms.logInfo().Str("track", ms.ID()).Str("kind", ms.kind).Msg("out_track_stopped")
 }
@@ -206,16 +268,17 @@ func (ms *mixerSlice) close() {
 func (ms *mixerSlice) loop() {
 	defer ms.close()
 
-	pipeline, i, userId := ms.fromPs.pipeline, ms.fromPs.i, ms.fromPs.userId
+	processor, i := ms.fromPs.processor, ms.fromPs.i
 
-	// gives pipeline a track to write to
-	pipeline.BindTrackAutoStart(ms.kind, ms)
+	// gives processor a track to write to
+	processor.BindTrackAutoStart(ms.kind, ms)
+	ms.fromPs.bindReceiverSlice(ms)
 	// wait for audio and video
-	<-pipeline.Started()
-	i.start() // first pipeline started starts the interaction
+	<-processor.Started()
+	i.start() // first processor started starts the interaction
 
 	if ms.kind == "audio" { // add once
-		i.addFiles(userId, pipeline.RecordingFiles) // for reference
+		i.addFiles(ms.fromPs.jp, processor) // for reference and verification
 	}
 
 	go ms.loopReadRTCP()
@@ -232,51 +295,49 @@ func (ms *mixerSlice) loop() {
 
 	// main loop start
 	buf := make([]byte, config.SFU.Common.MTU)
+	var audioLevelExtId uint8
+	if ms.kind == "audio" {
+		audioLevelExtId = audioLevelExtensionId(ms.receiver)
+	}
 
-	if ms.fromPs.jp.RecordingMode == "bypass" {
-	bypass:
-		for {
-			select {
-			case <-ms.fromPs.isDone():
-				// interaction OR peer is done
-				break bypass
-			default:
-				n, _, err := ms.input.Read(buf)
-				if err != nil {
-					break bypass
-				}
-				ms.Write(buf[:n])
+toProcessor:
+	for {
+		select {
+		case <-ms.fromPs.isDone():
+			// peer is done
+			break toProcessor
+		case <-ms.fromPs.i.isDone():
+			// interaction is done: stops processors so that recordings are finalized
+			// and verified before peers are closed
+			break toProcessor
+		default:
+			n, _, err := ms.input.Read(buf)
+			if err != nil {
+				break toProcessor
 			}
-		}
-	} else {
-	toPipeline:
-		for {
-			select {
-			case <-ms.fromPs.isDone():
-				// interaction OR peer is done
-				break toPipeline
-			default:
-				n, _, err := ms.input.Read(buf)
-				if err != nil {
-					break toPipeline
-				}
-				ms.pipeline.PushRTP(ms.kind, buf[:n])
-				// for stats
-				go ms.updateInputBits(n)
-				// time
-
-				// plot rtp timestamp
-				// r := &rtp.Packet{}
-				// if err := r.Unmarshal(buf[:n]); err == nil {
-				// 	if ms.baseRTPTimestampIn == 0 {
-				// 		ms.baseRTPTimestampIn = r.Timestamp
-				// 	} else {
-				// 		elapsedRTP := (r.Timestamp - ms.baseRTPTimestampIn) * 1000 / ms.input.Codec().ClockRate
-				// 		sinceStart := time.Since(i.startedAt).Milliseconds()
-				// 		ms.plot.AddRtpDiffIn(sinceStart - int64(elapsedRTP))
-				// 	}
-				// }
+			ms.fromPs.capture.write(ms.kind, false, buf[:n])
+			if audioLevelExtId > 0 {
+				ms.updateVoiceActivity(ms.voiceActivity, audioLevelExtId, buf[:n])
+			}
+			ms.processor.PushRTP(ms.kind, buf[:n])
+			for _, p := range ms.fromPs.runningReceiverProcessors() {
+				p.PushRTP(ms.kind, buf[:n])
 			}
+			// for stats
+			go ms.updateInputBits(n)
+			// time
+
+			// plot rtp timestamp
+			// r := &rtp.Packet{}
+			// if err := r.Unmarshal(buf[:n]); err == nil {
+			// 	if ms.baseRTPTimestampIn == 0 {
+			// 		ms.baseRTPTimestampIn = r.Timestamp
+			// 	} else {
+			// 		elapsedRTP := (r.Timestamp - ms.baseRTPTimestampIn) * 1000 / ms.input.Codec().ClockRate
+			// 		sinceStart := time.Since(i.startedAt).Milliseconds()
+			// 		ms.plot.AddRtpDiffIn(sinceStart - int64(elapsedRTP))
+			// 	}
+			// }
 		}
 	}
 }
@@ -285,7 +346,7 @@ func (ms *mixerSlice) updateTargetBitrates(targetBitrate int) {
 	ms.Lock()
 	ms.targetBitrate = targetBitrate
 	ms.Unlock()
-	ms.pipeline.SetEncodingBitrate(ms.kind, targetBitrate)
+	ms.processor.SetEncodingBitrate(ms.kind, targetBitrate)
 	// format and log
 	msg := fmt.Sprintf("%s_target_bitrate_updated", ms.kind)
 	ms.logInfo().Int("value", targetBitrate/1000).Str("unit", "kbit/s").Msg(msg)
@@ -322,7 +383,11 @@ func (ms *mixerSlice) loopReadRTCP() {
 
 			for _, packet := range packets {
 				if buf, err := packet.Marshal(); err == nil {
-					ms.pipeline.PushRTCP(ms.kind, buf)
+					ms.fromPs.capture.write(ms.kind, true, buf)
+					ms.processor.PushRTCP(ms.kind, buf)
+					for _, p := range ms.fromPs.runningReceiverProcessors() {
+						p.PushRTCP(ms.kind, buf)
+					}
 				}
 				ms.logTrace().Str("type", fmt.Sprintf("%T", packet)).Str("packet", fmt.Sprintf("%+v", packet)).Msg("received_rtcp_on_receiver")
 			}
@@ -344,9 +409,18 @@ func (ms *mixerSlice) loopEncoderController() {
 		case <-encoderTicker.C:
 			if len(ms.senderControllerIndex) > 0 {
 				rates := []int{}
-				for _, sc := range ms.senderControllerIndex {
+				for toUserId, sc := range ms.senderControllerIndex {
 					if ms.kind == "video" {
-						rates = append(rates, sc.optimalRate())
+						if _, dedicated := ms.fromPs.jp.ReceiverFx[toUserId]; dedicated {
+							// a dedicated encoder is only constrained by its receiver
+							if p, ok := ms.fromPs.runningReceiverProcessors()[toUserId]; ok {
+								if rate := sc.optimalRate(); rate > 0 {
+									p.SetEncodingBitrate(ms.kind, rate)
+								}
+							}
+						} else {
+							rates = append(rates, sc.optimalRate())
+						}
 					}
 				}
 				// DISABLED no need to encode more than inputToOutputMaxFactor times the inputBitrate
@@ -355,7 +429,7 @@ func (ms *mixerSlice) loopEncoderController() {
 				// END DISABLED
 				newPotentialRate := minInt(rates)
 
-				if ms.pipeline != nil && newPotentialRate > 0 {
+				if ms.processor != nil && newPotentialRate > 0 {
 					ms.updateTargetBitrates(newPotentialRate)
 
 					// DISABLED throttling update
@@ -390,7 +464,7 @@ func (ms *mixerSlice) loopBufferPlots() {
 					names = []string{"video_rtp_src", "video_queue_bef_drymux", "video_queue_bef_drymux", "video_queue_bef_fx", "video_queue_aft_fx", "video_queue_bef_dec", "video_queue_aft_dec", "video_queue_bef_wetmux", "video_queue_bef_sink"}
 				}
 				for _, n := range names {
-					l := ms.pipeline.GetCurrentLevelTime(n) / 1000000 // ns -> ms
+					l := ms.processor.GetCurrentLevelTime(n) / 1000000 // ns -> ms
 					ms.plot.AddCurrentLevelTime(n, l)
 					ms.logInfo().Str("name", n).Uint64("value", l).Msg("poll_current_level_time")
 				}
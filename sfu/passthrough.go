@@ -0,0 +1,234 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/rs/zerolog"
+)
+
+// passthroughProcessor is the MediaProcessor of the bypass recording mode: RTP input
+// is copied as-is to output tracks, with no fx nor encoding. The dry streams are
+// recorded without GStreamer (see passthrough_writers.go): Opus to Ogg, VP8 to IVF and
+// H264 to Annex-B
+type passthroughProcessor struct {
+	mu           sync.Mutex
+	jp           types.JoinPayload
+	receiverId   string
+	plir         types.PLIRequester
+	audioOutput  types.TrackWriter
+	videoOutput  types.TrackWriter
+	stoppedCount int
+	startedAt    time.Time
+	startedCh    chan struct{}
+	doneCh       chan struct{}
+	// recording
+	dataFolder      string
+	iRandomId       string
+	connectionCount int
+	writers         map[string]media.Writer       // per kind
+	files           map[string]string             // per kind
+	streams         map[string]types.StreamTiming // per file
+	logger          zerolog.Logger
+}
+
+func newPassthroughProcessor(jp types.JoinPayload, receiverId string, plir types.PLIRequester, i *interaction, connectionCount int) MediaProcessor {
+	logger := i.logger.With().
+		Str("context", "passthrough").
+		Str("user", jp.UserId).
+		Logger()
+	if len(receiverId) > 0 {
+		logger = logger.With().Str("toUser", receiverId).Logger()
+	}
+	return &passthroughProcessor{
+		jp:              jp,
+		receiverId:      receiverId,
+		plir:            plir,
+		startedCh:       make(chan struct{}),
+		doneCh:          make(chan struct{}),
+		dataFolder:      i.DataFolder(),
+		iRandomId:       i.randomId,
+		connectionCount: connectionCount,
+		writers:         make(map[string]media.Writer),
+		files:           make(map[string]string),
+		streams:         make(map[string]types.StreamTiming),
+		logger:          logger,
+	}
+}
+
+func (p *passthroughProcessor) output(kind string) types.TrackWriter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if kind == "audio" {
+		return p.audioOutput
+	}
+	return p.videoOutput
+}
+
+func (p *passthroughProcessor) BindTrackAutoStart(kind string, t types.TrackWriter) {
+	p.mu.Lock()
+	if kind == "audio" {
+		p.audioOutput = t
+	} else {
+		p.videoOutput = t
+	}
+	ready := p.audioOutput != nil && (p.jp.AudioOnly || p.videoOutput != nil)
+	if ready {
+		p.startedAt = time.Now()
+		p.startRecording()
+	}
+	p.mu.Unlock()
+
+	if ready {
+		p.logger.Info().Msg("passthrough_started")
+		close(p.startedCh)
+	}
+}
+
+// same naming as the split recording mode of GStreamer pipelines
+func (p *passthroughProcessor) startRecording() {
+	if env.NoRecording || len(p.receiverId) > 0 {
+		return
+	}
+	recordingPrefix := p.dataFolder + "/recordings/" + types.FilePrefix(p.jp, p.iRandomId, p.connectionCount, p.receiverId) + "-"
+
+	kinds := []string{"audio"}
+	if !p.jp.AudioOnly {
+		kinds = append(kinds, "video")
+	}
+	for _, kind := range kinds {
+		var w media.Writer
+		var err error
+		file := recordingPrefix + kind + "-dry."
+		switch {
+		case kind == "audio":
+			file += "ogg"
+			w, err = newOggRecordingWriter(file)
+		case p.jp.VideoFormat == "VP8":
+			file += "ivf"
+			w, err = newIVFRecordingWriter(file, p.jp.Width, p.jp.Height)
+		default:
+			file += "h264"
+			w, err = newAnnexBRecordingWriter(file)
+		}
+		if err != nil {
+			p.logger.Error().Str("file", file).Err(err).Msg("passthrough_recording_failed")
+			continue
+		}
+		p.writers[kind] = w
+		p.files[kind] = file
+	}
+}
+
+func (p *passthroughProcessor) record(kind string, buffer []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w, ok := p.writers[kind]
+	if !ok {
+		return
+	}
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(buffer); err != nil {
+		return
+	}
+	if err := w.WriteRTP(packet); err != nil {
+		p.logger.Error().Str("file", p.files[kind]).Err(err).Msg("passthrough_recording_failed")
+		w.Close()
+		delete(p.writers, kind)
+		return
+	}
+	file := p.files[kind]
+	if _, ok := p.streams[file]; !ok {
+		// packets are written on reception, there is no pipeline clock
+		p.streams[file] = types.StreamTiming{Kind: kind, CapturedAt: time.Now()}
+	}
+}
+
+func (p *passthroughProcessor) Started() chan struct{} {
+	return p.startedCh
+}
+
+func (p *passthroughProcessor) Done() chan struct{} {
+	return p.doneCh
+}
+
+func (p *passthroughProcessor) PushRTP(kind string, buffer []byte) {
+	if output := p.output(kind); output != nil {
+		output.Write(buffer)
+	}
+	p.record(kind, buffer)
+}
+
+// RTCP from senders is handled by pion interceptors
+func (p *passthroughProcessor) PushRTCP(kind string, buffer []byte) {}
+
+// there is no encoder, the key frame is requested to the sender
+func (p *passthroughProcessor) SendPLI() {
+	p.plir.PLIRequest("passthrough")
+}
+
+func (p *passthroughProcessor) SetEncodingBitrate(kind string, value int) {}
+
+func (p *passthroughProcessor) SetFxPropFloat(name string, prop string, value float32) {}
+
+func (p *passthroughProcessor) GetFxPropFloat(name string, prop string) float32 {
+	return 0
+}
+
+func (p *passthroughProcessor) SetFxPolyProp(name string, prop string, kind string, value string) {}
+
+func (p *passthroughProcessor) GetCurrentLevelTime(name string) uint64 {
+	return 0
+}
+
+func (p *passthroughProcessor) Files() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	files := []string{}
+	for _, kind := range []string{"audio", "video"} {
+		if file, ok := p.files[kind]; ok {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+func (p *passthroughProcessor) SyncInfo() types.SyncInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info := types.SyncInfo{StartedAt: p.startedAt, Streams: make(map[string][]types.StreamTiming)}
+	for file, s := range p.streams {
+		info.Streams[file] = []types.StreamTiming{s}
+	}
+	return info
+}
+
+func (p *passthroughProcessor) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	kindCount := 2
+	if p.jp.AudioOnly {
+		kindCount = 1
+	}
+	p.stoppedCount++
+	if p.stoppedCount == kindCount {
+		// finalizes recordings (headers are updated on close)
+		for kind, w := range p.writers {
+			if err := w.Close(); err != nil {
+				p.logger.Error().Str("file", p.files[kind]).Err(err).Msg("passthrough_recording_failed")
+			}
+		}
+		p.writers = make(map[string]media.Writer)
+		p.logger.Info().Msg("passthrough_stopped")
+		close(p.doneCh)
+	}
+}
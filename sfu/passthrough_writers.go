@@ -0,0 +1,213 @@
+package sfu
+
+import (
+	"encoding/binary"
+	"os"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+)
+
+// Recordings of the bypass mode: RTP packets are reordered and assembled into samples
+// (Opus frames, VP8 frames or H264 access units) by a jitter buffer, then written
+
+const (
+	// samples whose packets are still missing are dropped after this delay or this
+	// number of packets
+	recordingJitterDelay    = 500 * time.Millisecond
+	recordingJitterMaxLate  = 256
+	ivfFileHeaderSize       = 32
+	ivfFrameHeaderSize      = 12
+	ivfTimebaseDenominator  = 90000 // RTP clock rate of video
+	vp8KeyFrameStartCodeEnd = 6
+)
+
+type sampleWriter interface {
+	WriteSample(s *media.Sample) error
+	Close() error
+}
+
+// recordingWriter pushes RTP packets to a jitter buffer and writes the resulting samples
+type recordingWriter struct {
+	builder *samplebuilder.SampleBuilder
+	writer  sampleWriter
+}
+
+func newRecordingWriter(depacketizer rtp.Depacketizer, clockRate uint32, writer sampleWriter) *recordingWriter {
+	return &recordingWriter{
+		builder: samplebuilder.New(recordingJitterMaxLate, depacketizer, clockRate, samplebuilder.WithMaxTimeDelay(recordingJitterDelay)),
+		writer:  writer,
+	}
+}
+
+func (w *recordingWriter) WriteRTP(packet *rtp.Packet) error {
+	w.builder.Push(packet)
+	for s := w.builder.Pop(); s != nil; s = w.builder.Pop() {
+		if err := w.writer.WriteSample(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// samples still in the jitter buffer (at most the last one if no packet is missing)
+// are not written
+func (w *recordingWriter) Close() error {
+	return w.writer.Close()
+}
+
+// Opus samples are written as single packets (one frame per packet), the Ogg
+// granule position being derived from their RTP timestamps
+type oggSampleWriter struct {
+	ogg *oggwriter.OggWriter
+}
+
+func newOggRecordingWriter(file string) (*recordingWriter, error) {
+	ogg, err := oggwriter.New(file, 48000, 2)
+	if err != nil {
+		return nil, err
+	}
+	return newRecordingWriter(&codecs.OpusPacket{}, 48000, &oggSampleWriter{ogg}), nil
+}
+
+func (w *oggSampleWriter) WriteSample(s *media.Sample) error {
+	return w.ogg.WriteRTP(&rtp.Packet{Header: rtp.Header{Timestamp: s.PacketTimestamp}, Payload: s.Data})
+}
+
+func (w *oggSampleWriter) Close() error {
+	return w.ogg.Close()
+}
+
+// ivfSampleWriter writes VP8 frames with a 1/90000 timebase, their PTS being their
+// RTP timestamps relative to the first key frame, so that the file keeps the actual
+// frame timing. Dimensions are read from the first key frame. Frames before the
+// first key frame are dropped since they can't be decoded
+type ivfSampleWriter struct {
+	file          *os.File
+	width         uint16
+	height        uint16
+	frameCount    uint32
+	firstWritten  bool
+	lastTimestamp uint32
+	pts           int64
+}
+
+func newIVFRecordingWriter(file string, width, height int) (*recordingWriter, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	w := &ivfSampleWriter{file: f, width: uint16(width), height: uint16(height)}
+	if _, err := f.Write(w.header()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return newRecordingWriter(&codecs.VP8Packet{}, ivfTimebaseDenominator, w), nil
+}
+
+func (w *ivfSampleWriter) header() []byte {
+	h := make([]byte, ivfFileHeaderSize)
+	copy(h[0:], "DKIF")
+	binary.LittleEndian.PutUint16(h[4:], 0) // version
+	binary.LittleEndian.PutUint16(h[6:], ivfFileHeaderSize)
+	copy(h[8:], "VP80")
+	binary.LittleEndian.PutUint16(h[12:], w.width)
+	binary.LittleEndian.PutUint16(h[14:], w.height)
+	binary.LittleEndian.PutUint32(h[16:], ivfTimebaseDenominator)
+	binary.LittleEndian.PutUint32(h[20:], 1) // timebase numerator
+	binary.LittleEndian.PutUint32(h[24:], w.frameCount)
+	return h
+}
+
+// returns the dimensions of a VP8 key frame, ok being false for inter frames
+func vp8KeyFrameSize(frame []byte) (width, height uint16, ok bool) {
+	// the lowest bit of the frame tag is 0 for key frames
+	if len(frame) < vp8KeyFrameStartCodeEnd+4 || frame[0]&0x01 != 0 {
+		return
+	}
+	if frame[3] != 0x9d || frame[4] != 0x01 || frame[5] != 0x2a {
+		return
+	}
+	width = binary.LittleEndian.Uint16(frame[6:]) & 0x3fff
+	height = binary.LittleEndian.Uint16(frame[8:]) & 0x3fff
+	return width, height, true
+}
+
+func (w *ivfSampleWriter) WriteSample(s *media.Sample) error {
+	if !w.firstWritten {
+		width, height, ok := vp8KeyFrameSize(s.Data)
+		if !ok {
+			return nil
+		}
+		w.width, w.height = width, height
+		w.firstWritten = true
+	} else {
+		// signed difference handles timestamp wraparound
+		w.pts += int64(int32(s.PacketTimestamp - w.lastTimestamp))
+	}
+	w.lastTimestamp = s.PacketTimestamp
+
+	h := make([]byte, ivfFrameHeaderSize)
+	binary.LittleEndian.PutUint32(h[0:], uint32(len(s.Data)))
+	binary.LittleEndian.PutUint64(h[4:], uint64(w.pts))
+	if _, err := w.file.Write(append(h, s.Data...)); err != nil {
+		return err
+	}
+	w.frameCount++
+	return nil
+}
+
+// updates dimensions and frame count in the file header
+func (w *ivfSampleWriter) Close() error {
+	if _, err := w.file.WriteAt(w.header(), 0); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// annexBSampleWriter writes H264 access units (Annex-B byte stream, as output by the
+// depacketizer), starting with the first one containing an IDR slice or parameter sets
+type annexBSampleWriter struct {
+	file        *os.File
+	hasKeyFrame bool
+}
+
+func newAnnexBRecordingWriter(file string) (*recordingWriter, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	return newRecordingWriter(&codecs.H264Packet{}, 90000, &annexBSampleWriter{file: f}), nil
+}
+
+// looks for IDR (5) or SPS (7) NAL units after start codes
+func isH264KeyFrame(au []byte) bool {
+	for i := 0; i+3 < len(au); i++ {
+		if au[i] == 0 && au[i+1] == 0 && au[i+2] == 1 {
+			if naluType := au[i+3] & 0x1f; naluType == 5 || naluType == 7 {
+				return true
+			}
+			i += 2
+		}
+	}
+	return false
+}
+
+func (w *annexBSampleWriter) WriteSample(s *media.Sample) error {
+	if !w.hasKeyFrame {
+		if w.hasKeyFrame = isH264KeyFrame(s.Data); !w.hasKeyFrame {
+			return nil
+		}
+	}
+	_, err := w.file.Write(s.Data)
+	return err
+}
+
+func (w *annexBSampleWriter) Close() error {
+	return w.file.Close()
+}
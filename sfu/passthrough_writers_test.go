@@ -0,0 +1,95 @@
+package sfu
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+// one packet per frame, with a VP8 payload descriptor starting a partition
+func newVP8Packet(seq uint16, timestamp uint32, frame []byte) *rtp.Packet {
+	return &rtp.Packet{
+		Header:  rtp.Header{Version: 2, Marker: true, SequenceNumber: seq, Timestamp: timestamp},
+		Payload: append([]byte{0x10}, frame...),
+	}
+}
+
+func TestIVFRecordingWriter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "video.ivf")
+	w, err := newIVFRecordingWriter(file, 640, 480)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 320x240 key frame
+	keyFrame := []byte{0x00, 0x00, 0x00, 0x9d, 0x01, 0x2a, 0x40, 0x01, 0xf0, 0x00, 0xaa}
+	interFrame := []byte{0x01, 0xbb, 0xbb}
+	packets := []*rtp.Packet{
+		newVP8Packet(9, 4294964296, interFrame), // before the first key frame
+		newVP8Packet(10, 4294967296-1500, keyFrame),
+		newVP8Packet(12, 4500, interFrame), // received before the previous one, after wraparound
+		newVP8Packet(11, 1500, interFrame),
+		newVP8Packet(13, 7500, interFrame), // completes the previous frame
+	}
+	for _, p := range packets {
+		if err := w.WriteRTP(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(file)
+	if len(data) < ivfFileHeaderSize || string(data[0:4]) != "DKIF" || string(data[8:12]) != "VP80" {
+		t.Fatalf("invalid header: %v", data)
+	}
+	width, height := binary.LittleEndian.Uint16(data[12:]), binary.LittleEndian.Uint16(data[14:])
+	if width != 320 || height != 240 {
+		t.Errorf("got %vx%v, expected dimensions of the key frame", width, height)
+	}
+	if rate, scale := binary.LittleEndian.Uint32(data[16:]), binary.LittleEndian.Uint32(data[20:]); rate != 90000 || scale != 1 {
+		t.Errorf("got timebase %v/%v", scale, rate)
+	}
+	if count := binary.LittleEndian.Uint32(data[24:]); count != 3 {
+		t.Errorf("got %v frames, expected 3", count)
+	}
+
+	expected := []struct {
+		pts  uint64
+		size int
+	}{{0, len(keyFrame)}, {3000, len(interFrame)}, {6000, len(interFrame)}}
+	offset := ivfFileHeaderSize
+	for index, e := range expected {
+		if len(data) < offset+ivfFrameHeaderSize {
+			t.Fatalf("frame %v is missing", index)
+		}
+		size := int(binary.LittleEndian.Uint32(data[offset:]))
+		pts := binary.LittleEndian.Uint64(data[offset+4:])
+		if size != e.size || pts != e.pts {
+			t.Errorf("frame %v: got size %v and pts %v, expected %v and %v", index, size, pts, e.size, e.pts)
+		}
+		offset += ivfFrameHeaderSize + size
+	}
+}
+
+func TestIsH264KeyFrame(t *testing.T) {
+	tests := []struct {
+		name string
+		au   []byte
+		want bool
+	}{
+		{"sps and idr", []byte{0, 0, 0, 1, 0x67, 0x42, 0, 0, 1, 0x65, 0x88}, true},
+		{"idr", []byte{0, 0, 1, 0x65, 0x88}, true},
+		{"non-idr slice", []byte{0, 0, 0, 1, 0x41, 0x9a}, false},
+		{"empty", []byte{}, false},
+	}
+	for _, tt := range tests {
+		if got := isH264KeyFrame(tt.au); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
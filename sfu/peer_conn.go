@@ -32,6 +32,7 @@ type peerConn struct {
 	lastPLI        time.Time
 	pliMinInterval time.Duration
 	ccEstimator    cc.BandwidthEstimator
+	impairer       *engine.Impairer
 }
 
 func (pc *peerConn) logError() *zerolog.Event {
@@ -52,10 +53,10 @@ func (pc *peerConn) PLIRequest(cause string) {
 	pc.managedPLIRequest(cause)
 }
 
-func newPionPeerConn(i *interaction) (ppc *webrtc.PeerConnection, ccEstimator cc.BandwidthEstimator, err error) {
+func newPionPeerConn(i *interaction, impairer *engine.Impairer) (ppc *webrtc.PeerConnection, ccEstimator cc.BandwidthEstimator, err error) {
 	// create RTC API
 	estimatorCh := make(chan cc.BandwidthEstimator, 1)
-	api, err := engine.NewWebRTCAPI(estimatorCh, i.logger)
+	api, err := engine.NewWebRTCAPI(estimatorCh, impairer, i.logger)
 	if err != nil {
 		return
 	}
@@ -99,7 +100,8 @@ func (pc *peerConn) prepareInTracks(jp types.JoinPayload) (err error) {
 }
 
 func newPeerConn(jp types.JoinPayload, i *interaction) (pc *peerConn, err error) {
-	ppc, ccEstimator, err := newPionPeerConn(i)
+	impairer := engine.NewImpairer()
+	ppc, ccEstimator, err := newPionPeerConn(i, impairer)
 	if err != nil {
 		// pc is not created for now so we use the interaction logger
 		i.logger.Error().Err(err).Str("user", jp.UserId)
@@ -109,7 +111,7 @@ func newPeerConn(jp types.JoinPayload, i *interaction) (pc *peerConn, err error)
 	// initial lastPLI far enough in the past
 	lastPLI := time.Now().Add(-2 * initialPLIMinInterval)
 
-	pc = &peerConn{sync.Mutex{}, ppc, jp.UserId, i, lastPLI, initialPLIMinInterval, ccEstimator}
+	pc = &peerConn{sync.Mutex{}, ppc, jp.UserId, i, lastPLI, initialPLIMinInterval, ccEstimator, impairer}
 
 	// after an initial delay, change the minimum PLI interval
 	go func() {
@@ -220,6 +222,7 @@ func (pc *peerConn) managedPLIRequest(cause string) {
 	for _, receiver := range pc.GetReceivers() {
 		track := receiver.Track()
 		if track != nil && track.Kind().String() == "video" {
+			pliRequestsTotal.Inc(cause)
 			go pc.sendPLIRequest(track, cause)
 			// // throttling currently disabled
 			// durationSinceLastPLI := time.Since(pc.lastPLI)
@@ -6,10 +6,10 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ducksouplab/ducksoup/env"
-	"github.com/ducksouplab/ducksoup/gst"
 	"github.com/ducksouplab/ducksoup/iceservers"
 	"github.com/ducksouplab/ducksoup/sequencing"
 	"github.com/ducksouplab/ducksoup/types"
@@ -38,9 +38,18 @@ type peerServer struct {
 	videoSlice      *mixerSlice
 	closed          bool
 	doneCh          chan struct{}
+	connectionCount int
 	// processing
-	pipeline          *gst.Pipeline
-	interpolatorIndex map[string]*sequencing.LinearInterpolator
+	processor MediaProcessor
+	// running receiver processors per receiver user id, see receiver_processors.go
+	receiverProcessors atomic.Pointer[map[string]MediaProcessor]
+	receiversMu        sync.Mutex
+	connectedReceivers map[string]bool
+	boundSlices        map[string]*mixerSlice // per kind
+	interpolatorIndex  map[string]*sequencing.LinearInterpolator
+	capture            *rtpCapture // nil unless JoinPayload#CaptureRTP
+	avOffset           float32     // in ms, see desync.go
+	mirrorDelay        float32     // in ms, see mirror.go
 }
 
 func newPeerServer(
@@ -49,20 +58,27 @@ func newPeerServer(
 	pc *peerConn,
 	ws *wsConn) *peerServer {
 
-	pipeline := gst.NewPipeline(jp, pc, i.DataFolder(), i.randomId, i.joinedCountForUser(jp.UserId), i.logger)
+	connectionCount := i.joinedCountForUser(jp.UserId)
+	processor := newMediaProcessor(jp, "", pc, i, connectionCount)
 
 	ps := &peerServer{
-		userId:            jp.UserId,
-		interactionName:   i.name,
-		streamId:          uuid.New().String(),
-		jp:                jp,
-		i:                 i,
-		pc:                pc,
-		ws:                ws,
-		closed:            false,
-		doneCh:            make(chan struct{}),
-		pipeline:          pipeline,
-		interpolatorIndex: make(map[string]*sequencing.LinearInterpolator),
+		userId:             jp.UserId,
+		interactionName:    i.name,
+		streamId:           uuid.New().String(),
+		jp:                 jp,
+		i:                  i,
+		pc:                 pc,
+		ws:                 ws,
+		closed:             false,
+		doneCh:             make(chan struct{}),
+		connectionCount:    connectionCount,
+		processor:          processor,
+		connectedReceivers: make(map[string]bool),
+		boundSlices:        make(map[string]*mixerSlice),
+		capture:            newRTPCapture(jp, i, connectionCount),
+		interpolatorIndex:  make(map[string]*sequencing.LinearInterpolator),
+		avOffset:           float32(clampAVOffset(jp.AVOffset)),
+		mirrorDelay:        float32(mirrorDelayFor(i, jp)),
 	}
 
 	// connect for further communication
@@ -100,11 +116,14 @@ func (ps *peerServer) logDebug() *zerolog.Event {
 }
 
 func (ps *peerServer) setMixerSlice(kind string, ms *mixerSlice) {
+	ps.Lock()
 	if kind == "audio" {
 		ps.audioSlice = ms
 	} else if kind == "video" {
 		ps.videoSlice = ms
 	}
+	ps.Unlock()
+	ps.applyOutputDelays()
 }
 
 func (ps *peerServer) cleanOutTracks() {
@@ -160,13 +179,17 @@ func (ps *peerServer) prepareOutTracks() bool {
 			// don't send own tracks, except when interaction size is 1 (interaction then acts as a mirror)
 			ps.logInfo().Str("user", userId).Str("from", fromId).Str("track", trackId).Msg("add_own_track_to_pc_skipped")
 		} else {
-			sender, err := pc.AddTrack(s.output)
+			sender, err := pc.AddTrack(s.outputFor(userId))
 			if err != nil {
 				ps.logError().Str("context", "signaling").Err(err).Str("user", userId).Str("from", fromId).Str("track", trackId).Msg("add_out_track_to_pc_failed")
 				return false
 			} else {
 				ps.logInfo().Str("user", userId).Str("from", fromId).Str("track", trackId).Msg("out_track_added_to_pc")
 			}
+			if encodings := sender.GetParameters().Encodings; len(encodings) > 0 {
+				pc.impairer.Bind(uint32(encodings[0].SSRC), fromId)
+				pc.impairer.Set(ps.i.impairmentOf(fromId, userId))
+			}
 			s.addSender(pc, sender)
 		}
 	}
@@ -254,6 +277,7 @@ func (ps *peerServer) close(cause string) {
 		// clean up bound components
 		go ps.pc.Close() // TODO fix/check -> may block
 		ps.ws.Close()
+		ps.capture.stopAll()
 
 		ps.logInfo().Str("context", "peer").Str("cause", cause).Msg("peer_server_ended")
 	}
@@ -267,28 +291,34 @@ func (ps *peerServer) controlFx(payload controlPayload) {
 		Str("from", payload.fromUserId).
 		Str("name", payload.Name).
 		Str("property", payload.Property).
+		Str("toUser", payload.ReceiverId).
 		Float32("value", payload.Value).
 		Int("duration", payload.Duration).
 		Msg("client_fx_control")
 
-	interpolatorId := payload.Name + payload.Property
+	processor, ok := ps.processorFor(payload.ReceiverId)
+	if !ok {
+		// the receiver is not connected
+		return
+	}
+	interpolatorId := payload.ReceiverId + payload.Name + payload.Property
 	ps.Lock()
 	interpolator := ps.interpolatorIndex[interpolatorId]
 	if interpolator != nil {
-		// an interpolation is already running for this pipeline, effect and property
+		// an interpolation is already running for this processor, effect and property
 		interpolator.Stop()
 	}
 
 	duration := payload.Duration
 	if duration == 0 {
-		ps.pipeline.SetFxPropFloat(payload.Name, payload.Property, payload.Value)
+		processor.SetFxPropFloat(payload.Name, payload.Property, payload.Value)
 		ps.Unlock()
 		return
 	} else {
 		if duration > maxInterpolatorDuration {
 			duration = maxInterpolatorDuration
 		}
-		oldValue := ps.pipeline.GetFxPropFloat(payload.Name, payload.Property)
+		oldValue := processor.GetFxPropFloat(payload.Name, payload.Property)
 		newInterpolator := sequencing.NewLinearInterpolator(oldValue, payload.Value, duration, defaultInterpolatorStep)
 		ps.interpolatorIndex[interpolatorId] = newInterpolator
 		ps.Unlock()
@@ -305,7 +335,7 @@ func (ps *peerServer) controlFx(payload controlPayload) {
 				return
 			case currentValue, more := <-newInterpolator.C:
 				if more {
-					ps.pipeline.SetFxPropFloat(payload.Name, payload.Property, currentValue)
+					processor.SetFxPropFloat(payload.Name, payload.Property, currentValue)
 				} else {
 					return
 				}
@@ -323,7 +353,14 @@ func (ps *peerServer) loop() {
 			ps.close("interaction_aborted")
 			return
 		case <-ps.i.isDone():
-			ps.ws.sendWithPayload("files", ps.i.files()) // peer could have left (ws closed) but interaction is still running
+			// wait for recordings to be verified, within a time limit
+			select {
+			case <-ps.i.isManifestReady():
+				ps.ws.sendWithPayload("manifest", ps.i.getManifest())
+			case <-time.After(ManifestWaitInSeconds * time.Second):
+				ps.logError().Str("context", "recording").Msg("manifest_wait_timeout")
+			}
+			ps.ws.sendWithPayload("files", ps.i.fileReports()) // peer could have left (ws closed) but interaction is still running
 			ps.ws.send("end")
 			ps.close("interaction_ended")
 			return
@@ -401,18 +438,54 @@ func (ps *peerServer) loop() {
 					go ps.controlFx(payload)
 				}
 			}
+		case "client_av_offset":
+			payload := avOffsetPayload{}
+			if err := json.Unmarshal([]byte(m.Payload), &payload); err != nil {
+				ps.logError().Str("context", "peer").Err(err).Msg("unmarshal_client_av_offset_failed")
+			} else {
+				payload.fromUserId = ps.userId
+				if targetPs, ok := ps.i.peerServerIndex[payload.UserId]; ok { // control other ps in same interaction
+					go targetPs.controlAVOffset(payload)
+				} else { // default case: control self ps
+					go ps.controlAVOffset(payload)
+				}
+			}
+		case "client_mirror_delay":
+			payload := mirrorDelayPayload{}
+			if err := json.Unmarshal([]byte(m.Payload), &payload); err != nil {
+				ps.logError().Str("context", "peer").Err(err).Msg("unmarshal_client_mirror_delay_failed")
+			} else {
+				go ps.controlMirrorDelay(payload)
+			}
+		case "client_confederate":
+			payload := confederatePayload{}
+			if err := json.Unmarshal([]byte(m.Payload), &payload); err != nil {
+				ps.logError().Str("context", "peer").Err(err).Msg("unmarshal_client_confederate_failed")
+			} else {
+				go ps.i.controlConfederate(payload, ps.userId)
+			}
+		case "client_impair":
+			payload := types.Impairment{}
+			if err := json.Unmarshal([]byte(m.Payload), &payload); err != nil {
+				ps.logError().Str("context", "peer").Err(err).Msg("unmarshal_client_impair_failed")
+			} else {
+				ps.i.setImpairment(payload, ps.userId)
+			}
 		case "client_polycontrol":
 			payload := polyControlPayload{}
 			if err := json.Unmarshal([]byte(m.Payload), &payload); err != nil {
 				ps.logError().Str("context", "peer").Err(err).Msg("unmarshal_client_polycontrol_failed")
 			} else {
 				go func() {
-					ps.pipeline.SetFxPolyProp(payload.Name, payload.Property, payload.Kind, payload.Value)
+					if processor, ok := ps.processorFor(payload.ReceiverId); ok {
+						processor.SetFxPolyProp(payload.Name, payload.Property, payload.Kind, payload.Value)
+					}
 					ps.logInfo().
 						Str("context", "track").
 						Str("name", payload.Name).
 						Str("property", payload.Property).
 						Str("kind", payload.Kind).
+						Str("toUser", payload.ReceiverId).
 						Str("value", payload.Value).
 						Msg("client_fx_control")
 				}()
@@ -488,6 +561,7 @@ func RunPeerServer(origin string, unsafeConn ws.IGorilla) {
 		interactionName := joinPayload.InteractionName
 
 		i, msg, err := interactionStoreSingleton.join(joinPayload)
+		countJoin(msg, err)
 		if err != nil {
 			// joinInteraction err is meaningful to client
 			ws.send(fmt.Sprintf("error-%s", err))
@@ -0,0 +1,312 @@
+package sfu
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/pion/webrtc/v3/pkg/media/rtpdump"
+)
+
+// signaling and media usually take less than a second on loopback, but mixer
+// signaling may be retried after 2 seconds
+const e2eTimeout = 10 * time.Second
+
+func TestMain(m *testing.M) {
+	// tests don't depend on GStreamer (and may be built without cgo)
+	defaultMediaProcessorFactory = newFakeProcessor
+	backend = fakeMediaBackend{}
+	// interactions write to DataRoot (relative to the working directory): tests run in
+	// a temporary folder (configuration files have been loaded by init functions)
+	dir, err := os.MkdirTemp("", "ducksoup-sfu-")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// end-to-end tests with pion clients, see testPeer
+func TestPeerServer(t *testing.T) {
+
+	t.Run("Join and receive others tracks", func(t *testing.T) {
+		t.Parallel()
+		name := uniqueName()
+		p1 := newTestPeer(t, newBypassJoinPayload(name, "user-1", 2, 30)).join()
+		p2 := newTestPeer(t, newBypassJoinPayload(name, "user-2", 2, 30)).join()
+
+		joined := struct {
+			Context string `json:"context"`
+		}{}
+		json.Unmarshal(p1.waitFor("joined", e2eTimeout).Payload, &joined)
+		if joined.Context != "new_interaction" {
+			t.Errorf("user-1 joined with context %q", joined.Context)
+		}
+		json.Unmarshal(p2.waitFor("joined", e2eTimeout).Payload, &joined)
+		if joined.Context != "existing-interaction" {
+			t.Errorf("user-2 joined with context %q", joined.Context)
+		}
+		p1.waitFor("start", e2eTimeout)
+		p2.waitFor("start", e2eTimeout)
+		// audio and video from the other peer, not their own
+		p1.waitForTracks(2, e2eTimeout)
+		p2.waitForTracks(2, e2eTimeout)
+		if p1.receivedTrackCount() != 2 || p2.receivedTrackCount() != 2 {
+			t.Errorf("unexpected track counts: %v and %v", p1.receivedTrackCount(), p2.receivedTrackCount())
+		}
+	})
+
+	t.Run("Mirror own tracks", func(t *testing.T) {
+		t.Parallel()
+		name := uniqueName()
+		p := newTestPeer(t, newBypassJoinPayload(name, "user-1", 1, 30)).join()
+
+		p.waitFor("start", e2eTimeout)
+		p.waitForTracks(2, e2eTimeout)
+	})
+
+	t.Run("Reject peers when full", func(t *testing.T) {
+		t.Parallel()
+		name := uniqueName()
+		p1 := newTestPeer(t, newBypassJoinPayload(name, "user-1", 2, 30)).join()
+		p1.waitFor("joined", e2eTimeout)
+		p2 := newTestPeer(t, newBypassJoinPayload(name, "user-2", 2, 30)).join()
+		p2.waitFor("joined", e2eTimeout)
+
+		p3 := newTestPeer(t, newBypassJoinPayload(name, "user-3", 2, 30)).join()
+		p3.waitFor("error-full", e2eTimeout)
+		if joinsTotal.Value("full") == 0 {
+			t.Error("full join not counted")
+		}
+	})
+
+	t.Run("Reject duplicates", func(t *testing.T) {
+		t.Parallel()
+		name := uniqueName()
+		p1 := newTestPeer(t, newBypassJoinPayload(name, "user-1", 2, 30)).join()
+		p1.waitFor("joined", e2eTimeout)
+
+		duplicate := newTestPeer(t, newBypassJoinPayload(name, "user-1", 2, 30)).join()
+		duplicate.waitFor("error-duplicate", e2eTimeout)
+		if joinsTotal.Value("duplicate") == 0 {
+			t.Error("duplicate join not counted")
+		}
+	})
+
+	t.Run("Refuse new interactions when draining", func(t *testing.T) {
+		// not parallel since draining is global
+		name := uniqueName()
+		p1 := newTestPeer(t, newBypassJoinPayload(name, "user-1", 2, 30)).join()
+		p1.waitFor("joined", e2eTimeout)
+
+		SetDraining(true)
+		defer SetDraining(false)
+		p2 := newTestPeer(t, newBypassJoinPayload(name, "user-2", 2, 30)).join()
+		p2.waitFor("joined", e2eTimeout)
+		p3 := newTestPeer(t, newBypassJoinPayload(uniqueName(), "user-1", 2, 30)).join()
+		p3.waitFor("error-draining", e2eTimeout)
+	})
+
+	t.Run("Accept reconnections", func(t *testing.T) {
+		t.Parallel()
+		name := uniqueName()
+		p1 := newTestPeer(t, newBypassJoinPayload(name, "user-1", 2, 30)).join()
+		p2 := newTestPeer(t, newBypassJoinPayload(name, "user-2", 2, 30)).join()
+		p1.waitFor("start", e2eTimeout)
+		p2.waitFor("start", e2eTimeout)
+		p1.waitForTracks(2, e2eTimeout)
+
+		p2.close()
+		p1.waitFor("other_left", e2eTimeout)
+
+		p2bis := newTestPeer(t, newBypassJoinPayload(name, "user-2", 2, 30)).join()
+		joined := struct {
+			Context string `json:"context"`
+		}{}
+		json.Unmarshal(p2bis.waitFor("joined", e2eTimeout).Payload, &joined)
+		if joined.Context != "reconnection" {
+			t.Errorf("user-2 joined again with context %q", joined.Context)
+		}
+		// start is sent again to the reconnected peer only
+		p2bis.waitFor("start", e2eTimeout)
+		p2bis.waitForTracks(2, e2eTimeout)
+		// new tracks from user-2 are signaled to user-1
+		p1.waitFor("other_joined", e2eTimeout)
+		p1.waitForTracks(4, e2eTimeout)
+	})
+
+	t.Run("Renegotiate on client request", func(t *testing.T) {
+		t.Parallel()
+		name := uniqueName()
+		p1 := newTestPeer(t, newBypassJoinPayload(name, "user-1", 2, 30)).join()
+		p2 := newTestPeer(t, newBypassJoinPayload(name, "user-2", 2, 30)).join()
+		p1.waitForTracks(2, e2eTimeout)
+		p2.waitForTracks(2, e2eTimeout)
+
+		p1.Lock()
+		offerCount := p1.offerCount
+		p1.Unlock()
+		p1.send("client_negotiation_needed", nil)
+		p1.waitForOffers(offerCount+1, e2eTimeout)
+
+		// media keeps flowing on the same tracks
+		p1.Lock()
+		p1.receivedIndex = make(map[string]bool)
+		p1.Unlock()
+		p1.waitForTracks(2, e2eTimeout)
+	})
+
+	t.Run("Control fx of processors", func(t *testing.T) {
+		t.Parallel()
+		name := uniqueName()
+		p1 := newTestPeer(t, newFakeJoinPayload(name, "user-1", 2, 30)).join()
+		p2 := newTestPeer(t, newFakeJoinPayload(name, "user-2", 2, 30)).join()
+		p1.waitForTracks(2, e2eTimeout)
+		p2.waitForTracks(2, e2eTimeout)
+
+		// user-1 controls the fx of user-2
+		p1.send("client_control", controlPayload{UserId: "user-2", Name: "pitch", Property: "pitch", Value: 1.5})
+		processor := fakeProcessorFor(t, name, "user-2")
+		deadline := time.Now().Add(e2eTimeout)
+		for processor.GetFxPropFloat("pitch", "pitch") != 1.5 {
+			if time.Now().After(deadline) {
+				t.Fatalf("fx property not set within %v", e2eTimeout)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+
+	t.Run("Run receiver processors while receivers are connected", func(t *testing.T) {
+		t.Parallel()
+		name := uniqueName()
+		jp := newFakeJoinPayload(name, "user-1", 2, 30)
+		// user-3 never joins
+		jp.ReceiverFx = map[string]types.ReceiverFx{"user-2": {}, "user-3": {}}
+		p1 := newTestPeer(t, jp).join()
+		p2 := newTestPeer(t, newFakeJoinPayload(name, "user-2", 2, 30)).join()
+		p1.waitForTracks(2, e2eTimeout)
+		p2.waitForTracks(2, e2eTimeout)
+
+		processor := fakeProcessorFor(t, name, "user-1>user-2")
+		select {
+		case <-processor.Started():
+		case <-time.After(e2eTimeout):
+			t.Fatalf("receiver processor not started within %v", e2eTimeout)
+		}
+		if _, ok := fakeProcessorIndex.Load(name + "/user-1>user-3"); ok {
+			t.Error("processor created for a receiver that has not joined")
+		}
+
+		p2.close()
+		select {
+		case <-processor.Done():
+		case <-time.After(e2eTimeout):
+			t.Fatalf("receiver processor not stopped within %v", e2eTimeout)
+		}
+	})
+
+	t.Run("Capture inbound RTP", func(t *testing.T) {
+		t.Parallel()
+		name := uniqueName()
+		jp := newBypassJoinPayload(name, "user-1", 1, 30)
+		jp.CaptureRTP = true
+		p := newTestPeer(t, jp).join()
+		p.waitForTracks(2, e2eTimeout)
+		time.Sleep(500 * time.Millisecond)
+		// files are flushed when the peer leaves
+		p.close()
+
+		infoFiles, _ := filepath.Glob(filepath.Join(DataRoot, "test", name, "captures", "*"+captureInfoSuffix))
+		if len(infoFiles) != 1 {
+			t.Fatalf("unexpected capture descriptions: %v", infoFiles)
+		}
+		data, _ := os.ReadFile(infoFiles[0])
+		info := types.CaptureInfo{}
+		json.Unmarshal(data, &info)
+		for _, kind := range []string{"audio", "video"} {
+			deadline := time.Now().Add(e2eTimeout)
+			for count := 0; count == 0; count = rtpPacketCount(filepath.Dir(infoFiles[0]) + "/" + info.Files[kind]) {
+				if time.Now().After(deadline) {
+					t.Fatalf("no %v packet captured within %v", kind, e2eTimeout)
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		}
+	})
+
+	t.Run("Abort when peers are missing", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("waits for AbortLimitInSeconds")
+		}
+		t.Parallel()
+		name := uniqueName()
+		p := newTestPeer(t, newBypassJoinPayload(name, "user-1", 2, 30)).join()
+
+		p.waitFor("joined", e2eTimeout)
+		p.waitFor("error-aborted", AbortLimitInSeconds*time.Second+e2eTimeout)
+	})
+
+	t.Run("End gracefully", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("may wait for ManifestWaitInSeconds")
+		}
+		t.Parallel()
+		name := uniqueName()
+		p1 := newTestPeer(t, newBypassJoinPayload(name, "user-1", 2, 1)).join()
+		p2 := newTestPeer(t, newBypassJoinPayload(name, "user-2", 2, 1)).join()
+		p1.waitFor("start", e2eTimeout)
+		p2.waitFor("start", e2eTimeout)
+
+		// files are sent after the manifest (or after a time limit)
+		for _, p := range []*testPeer{p1, p2} {
+			p.waitFor("ending", e2eTimeout)
+			files := map[string][]types.FileReport{}
+			json.Unmarshal(p.waitFor("files", ManifestWaitInSeconds*time.Second+e2eTimeout).Payload, &files)
+			p.waitFor("end", e2eTimeout)
+
+			// dry recordings written by passthroughProcessors, with their verification
+			for _, userId := range []string{"user-1", "user-2"} {
+				reports := files[userId]
+				if len(reports) != 2 || !strings.HasSuffix(reports[0].File, "-audio-dry.ogg") || !strings.HasSuffix(reports[1].File, "-video-dry.ivf") {
+					t.Fatalf("unexpected files for %v: %+v", userId, reports)
+				}
+				for _, r := range reports {
+					if info, err := os.Stat(r.File); err != nil || info.Size() == 0 || r.Size != info.Size() {
+						t.Errorf("%v is missing, empty or not verified", r.File)
+					}
+				}
+			}
+		}
+	})
+
+}
+
+// RTP (not RTCP) packets in a rtpdump file
+func rtpPacketCount(file string) (count int) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	r, _, err := rtpdump.NewReader(f)
+	if err != nil {
+		return
+	}
+	for {
+		packet, err := r.Next()
+		if err != nil {
+			return
+		}
+		if !packet.IsRTCP {
+			count++
+		}
+	}
+}
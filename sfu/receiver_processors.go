@@ -0,0 +1,95 @@
+package sfu
+
+// Receiver processors (see JoinPayload#ReceiverFx) process this user's stream for one
+// receiver only. They are created lazily, once the receiver is connected and tracks
+// from this user are processed, and stopped when the receiver leaves (or when tracks
+// end), so that no pipeline runs for receivers that never join or have left.
+
+// nil safe, returns the running receiver processors (the map must not be modified)
+func (ps *peerServer) runningReceiverProcessors() map[string]MediaProcessor {
+	if running := ps.receiverProcessors.Load(); running != nil {
+		return *running
+	}
+	return nil
+}
+
+// returns the processor of the stream sent to receiverId, defaults to the main processor,
+// ok is false when receiverId has its own processor but it is not running
+func (ps *peerServer) processorFor(receiverId string) (p MediaProcessor, ok bool) {
+	if _, dedicated := ps.jp.ReceiverFx[receiverId]; !dedicated {
+		return ps.processor, true
+	}
+	p, ok = ps.runningReceiverProcessors()[receiverId]
+	return
+}
+
+// called with the interaction locked: the update is done in the background
+func (ps *peerServer) setReceiverConnected(receiverId string, connected bool) {
+	if _, ok := ps.jp.ReceiverFx[receiverId]; !ok {
+		return
+	}
+	ps.receiversMu.Lock()
+	ps.connectedReceivers[receiverId] = connected
+	ps.receiversMu.Unlock()
+	go ps.updateReceiverProcessors()
+}
+
+// tracks of this kind may be sent to receiver processors
+func (ps *peerServer) bindReceiverSlice(ms *mixerSlice) {
+	if len(ps.jp.ReceiverFx) == 0 {
+		return
+	}
+	ps.receiversMu.Lock()
+	ps.boundSlices[ms.kind] = ms
+	ps.receiversMu.Unlock()
+	ps.updateReceiverProcessors()
+}
+
+func (ps *peerServer) unbindReceiverSlice(ms *mixerSlice) {
+	if len(ps.jp.ReceiverFx) == 0 {
+		return
+	}
+	ps.receiversMu.Lock()
+	if ps.boundSlices[ms.kind] == ms {
+		delete(ps.boundSlices, ms.kind)
+	}
+	ps.receiversMu.Unlock()
+	ps.updateReceiverProcessors()
+}
+
+// starts or stops receiver processors depending on receivers being connected and
+// slices being bound, may be called several times for the same state
+func (ps *peerServer) updateReceiverProcessors() {
+	ps.receiversMu.Lock()
+	defer ps.receiversMu.Unlock()
+
+	kindCount := 2
+	if ps.jp.AudioOnly {
+		kindCount = 1
+	}
+	tracksReady := len(ps.boundSlices) == kindCount
+	running := ps.runningReceiverProcessors()
+	next := make(map[string]MediaProcessor)
+
+	for receiverId := range ps.jp.ReceiverFx {
+		p, isRunning := running[receiverId]
+		wanted := tracksReady && ps.connectedReceivers[receiverId]
+		if wanted && isRunning {
+			next[receiverId] = p
+		} else if wanted {
+			p = newMediaProcessor(ps.jp, receiverId, ps.pc, ps.i, ps.connectionCount)
+			for kind, ms := range ps.boundSlices {
+				p.BindTrackAutoStart(kind, ms.receiverOutputFor(receiverId))
+			}
+			next[receiverId] = p
+			ps.logInfo().Str("context", "track").Str("toUser", receiverId).Msg("receiver_processor_started")
+		} else if isRunning {
+			// Stop is expected once per kind
+			for i := 0; i < kindCount; i++ {
+				p.Stop()
+			}
+			ps.logInfo().Str("context", "track").Str("toUser", receiverId).Msg("receiver_processor_stopped")
+		}
+	}
+	ps.receiverProcessors.Store(&next)
+}
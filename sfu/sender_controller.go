@@ -184,6 +184,7 @@ func (sc *senderController) estimateLoopReadRTCPOnVideo() {
 				case *rtcp.ReceiverReport:
 					for _, r := range rtcpPacket.Reports {
 						if r.SSRC == uint32(sc.ssrc) {
+							rtcpFractionLost.Observe(float64(r.FractionLost)/256, sc.kind)
 							sc.updateRateFromLoss(int(r.FractionLost))
 						}
 					}
@@ -214,7 +215,9 @@ func (sc *senderController) simpleLoopReadRTCPOnVideo() {
 				switch packet.(type) {
 				case *rtcp.PictureLossIndication:
 					sc.ms.fromPs.pc.managedPLIRequest("forward_from_receiving_peer")
-					sc.ms.pipeline.SendPLI()
+					if processor, ok := sc.ms.fromPs.processorFor(sc.toUserId); ok {
+						processor.SendPLI()
+					}
 					// case *rtcp.ReceiverEstimatedMaximumBitrate:
 					// disabled due to TWCC
 					// sc.updateRateFromREMB(uint64(rtcpPacket.Bitrate))
@@ -0,0 +1,212 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/vad"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// live voice activity detection, relying on the audio level (RFC 6464) set by
+// browsers on each audio RTP packet, so that it works whatever the recording mode
+
+type speakingPayload struct {
+	UserId     string `json:"userId"`
+	Speaking   bool   `json:"speaking"`
+	SinceStart int64  `json:"sinceStart"` // in ms
+}
+
+// speaking changes, queued to be sent in order
+type speakingEvent struct {
+	userId   string
+	speaking bool
+	at       time.Time
+}
+
+// sends events in the order they were pushed, from a single goroutine that is
+// started when events are pushed and ends when the queue is empty
+type speakingQueue struct {
+	sync.Mutex
+	events  []speakingEvent
+	sending bool
+	send    func(e speakingEvent)
+}
+
+func newSpeakingQueue(send func(e speakingEvent)) *speakingQueue {
+	return &speakingQueue{send: send}
+}
+
+func (q *speakingQueue) push(e speakingEvent) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.events = append(q.events, e)
+	if !q.sending {
+		q.sending = true
+		go q.loop()
+	}
+}
+
+func (q *speakingQueue) loop() {
+	for {
+		q.Lock()
+		if len(q.events) == 0 {
+			q.sending = false
+			q.Unlock()
+			return
+		}
+		e := q.events[0]
+		q.events = q.events[1:]
+		q.Unlock()
+
+		q.send(e)
+	}
+}
+
+type voiceActivity struct {
+	sync.Mutex
+	detector  *vad.Detector
+	createdAt time.Time
+	level     float64 // in dBov, from the last packet
+	speaking  bool
+	changedAt time.Time
+}
+
+func newVoiceActivity() *voiceActivity {
+	now := time.Now()
+	return &voiceActivity{
+		detector:  vad.NewDetector(config.SFU.VAD.Threshold, time.Duration(config.SFU.VAD.Hangover)*time.Millisecond),
+		createdAt: now,
+		level:     -127,
+		changedAt: now,
+	}
+}
+
+// returns the id of the negotiated audio level header extension, 0 if none
+func audioLevelExtensionId(receiver *webrtc.RTPReceiver) uint8 {
+	for _, e := range receiver.GetParameters().HeaderExtensions {
+		if e.URI == sdp.AudioLevelURI {
+			return uint8(e.ID)
+		}
+	}
+	return 0
+}
+
+func (ms *mixerSlice) updateVoiceActivity(va *voiceActivity, extId uint8, buf []byte) {
+	h := rtp.Header{}
+	if _, err := h.Unmarshal(buf); err != nil {
+		return
+	}
+	payload := h.GetExtension(extId)
+	if payload == nil {
+		return
+	}
+	ext := rtp.AudioLevelExtension{}
+	if err := ext.Unmarshal(payload); err != nil {
+		return
+	}
+	ms.i.updateVoiceActivity(ms.fromPs.userId, va, -float64(ext.Level))
+}
+
+// called once per audio slice: voice activity is kept across reconnections
+func (i *interaction) voiceActivityFor(userId string) *voiceActivity {
+	i.Lock()
+	defer i.Unlock()
+
+	va, ok := i.voiceActivityIndex[userId]
+	if !ok {
+		va = newVoiceActivity()
+		i.voiceActivityIndex[userId] = va
+	}
+	return va
+}
+
+func (i *interaction) updateVoiceActivity(userId string, va *voiceActivity, level float64) {
+	now := time.Now()
+
+	va.Lock()
+	va.level = level
+	speaking, changed := va.detector.Update(now.Sub(va.createdAt), level)
+	previousDuration := now.Sub(va.changedAt)
+	if changed {
+		va.speaking = speaking
+		va.changedAt = now
+	}
+	va.Unlock()
+
+	if changed {
+		i.speakingChanged(userId, speaking, now, previousDuration)
+	}
+}
+
+// should be called by another method that locked the interaction (mutex), when a user
+// leaves: speaking stops now (and the detector starts again if the user reconnects)
+func (i *interaction) unguardedStopSpeaking(userId string) {
+	va, ok := i.voiceActivityIndex[userId]
+	if !ok {
+		return
+	}
+	now := time.Now()
+
+	va.Lock()
+	wasSpeaking := va.speaking
+	previousDuration := now.Sub(va.changedAt)
+	va.detector = vad.NewDetector(va.detector.Threshold, va.detector.Hangover)
+	va.level = -127
+	if wasSpeaking {
+		va.speaking = false
+		va.changedAt = now
+	}
+	va.Unlock()
+
+	if wasSpeaking {
+		i.speakingChanged(userId, false, now, previousDuration)
+	}
+}
+
+// does not lock the interaction, since it may be called with the interaction locked
+func (i *interaction) speakingChanged(userId string, speaking bool, at time.Time, previousDuration time.Duration) {
+	if speaking {
+		i.logger.Info().Str("context", "interaction").Str("user", userId).Int64("value", previousDuration.Milliseconds()).Str("unit", "ms").Msg("speaking_started")
+	} else {
+		i.logger.Info().Str("context", "interaction").Str("user", userId).Int64("value", previousDuration.Milliseconds()).Str("unit", "ms").Msg("speaking_stopped")
+	}
+
+	if i.jp.SpeakingEvents {
+		i.speakingEvents.push(speakingEvent{userId, speaking, at})
+	}
+}
+
+// sends a speaking event to every connected user, one after the other
+func (i *interaction) sendSpeakingEvent(e speakingEvent) {
+	i.RLock()
+	payload := speakingPayload{e.userId, e.speaking, e.at.Sub(i.startedAt).Milliseconds()}
+	peers := make([]*peerServer, 0, len(i.peerServerIndex))
+	for _, ps := range i.peerServerIndex {
+		peers = append(peers, ps)
+	}
+	i.RUnlock()
+
+	for _, ps := range peers {
+		ps.ws.sendWithPayload("speaking", payload)
+	}
+}
+
+// last audio level (in dBov) and speaking state of a user, ok is false if no audio
+// level has been received from this user
+func (i *interaction) voiceActivityOf(userId string) (level float64, speaking, ok bool) {
+	i.RLock()
+	va, ok := i.voiceActivityIndex[userId]
+	i.RUnlock()
+	if !ok {
+		return
+	}
+
+	va.Lock()
+	defer va.Unlock()
+	return va.level, va.speaking, true
+}
@@ -0,0 +1,150 @@
+package sfu
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+func TestSpeakingQueue(t *testing.T) {
+	var mu sync.Mutex
+	var concurrent, maxConcurrent int32
+	sent := []string{}
+	done := make(chan struct{})
+	count := 50
+
+	q := newSpeakingQueue(func(e speakingEvent) {
+		current := atomic.AddInt32(&concurrent, 1)
+		if current > atomic.LoadInt32(&maxConcurrent) {
+			atomic.StoreInt32(&maxConcurrent, current)
+		}
+		time.Sleep(100 * time.Microsecond)
+		atomic.AddInt32(&concurrent, -1)
+
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, e.userId)
+		if len(sent) == count {
+			close(done)
+		}
+	})
+	for index := 0; index < count; index++ {
+		q.push(speakingEvent{userId: string(rune('A' + index))})
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("events not sent")
+	}
+	for index, userId := range sent {
+		if userId != string(rune('A'+index)) {
+			t.Fatalf("event %v sent at position %v", userId, index)
+		}
+	}
+	if maxConcurrent != 1 {
+		t.Errorf("events sent by %v goroutines at the same time", maxConcurrent)
+	}
+}
+
+// returns an interaction whose speaking events are forwarded to the returned channel
+func newSpeakingInteraction(t *testing.T, userId string) (*interaction, chan speakingEvent) {
+	jp := newFakeJoinPayload(uniqueName(), userId, 2, 30)
+	jp.SpeakingEvents = true
+	i, _, err := interactionStoreSingleton.join(jp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan speakingEvent, 10)
+	i.speakingEvents = newSpeakingQueue(func(e speakingEvent) { events <- e })
+	return i, events
+}
+
+func expectSpeakingEvent(t *testing.T, events chan speakingEvent, userId string, speaking bool) {
+	t.Helper()
+	select {
+	case e := <-events:
+		if e.userId != userId || e.speaking != speaking {
+			t.Errorf("got %+v, want speaking=%v for %v", e, speaking, userId)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no event received, want speaking=%v for %v", speaking, userId)
+	}
+}
+
+func expectNoSpeakingEvent(t *testing.T, events chan speakingEvent) {
+	t.Helper()
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestVoiceActivity(t *testing.T) {
+	t.Run("Speaking changes are sent", func(t *testing.T) {
+		i, events := newSpeakingInteraction(t, "user")
+		va := i.voiceActivityFor("user")
+		if i.voiceActivityFor("user") != va {
+			t.Error("voice activity not reused")
+		}
+
+		i.updateVoiceActivity("user", va, -20)
+		expectSpeakingEvent(t, events, "user", true)
+		// hangover
+		i.updateVoiceActivity("user", va, -90)
+		expectNoSpeakingEvent(t, events)
+
+		if level, speaking, ok := i.voiceActivityOf("user"); !ok || !speaking || level != -90 {
+			t.Errorf("got level=%v speaking=%v ok=%v", level, speaking, ok)
+		}
+	})
+
+	t.Run("Speaking stops when leaving", func(t *testing.T) {
+		i, events := newSpeakingInteraction(t, "user")
+		va := i.voiceActivityFor("user")
+		i.updateVoiceActivity("user", va, -20)
+		expectSpeakingEvent(t, events, "user", true)
+
+		i.disconnectUser(&peerServer{userId: "user"})
+		expectSpeakingEvent(t, events, "user", false)
+		if _, speaking, _ := i.voiceActivityOf("user"); speaking {
+			t.Error("still speaking after leaving")
+		}
+
+		// not speaking anymore: no event
+		i.Lock()
+		i.unguardedStopSpeaking("user")
+		i.Unlock()
+		expectNoSpeakingEvent(t, events)
+
+		// after reconnection, the detector starts from scratch
+		i.updateVoiceActivity("user", va, -20)
+		expectSpeakingEvent(t, events, "user", true)
+	})
+
+	t.Run("Levels are read from the RTP header extension", func(t *testing.T) {
+		i, events := newSpeakingInteraction(t, "user")
+		ms := &mixerSlice{fromPs: &peerServer{userId: "user"}, i: i}
+		va := i.voiceActivityFor("user")
+
+		level, _ := rtp.AudioLevelExtension{Level: 30, Voice: true}.Marshal()
+		packet := &rtp.Packet{Header: rtp.Header{Version: 2}, Payload: []byte{0}}
+		packet.SetExtension(3, level)
+		buf, _ := packet.Marshal()
+
+		// other extension id: ignored
+		ms.updateVoiceActivity(va, 4, buf)
+		if l, _, _ := i.voiceActivityOf("user"); l != -127 {
+			t.Errorf("level updated with another extension id: %v", l)
+		}
+		ms.updateVoiceActivity(va, 3, buf)
+		expectSpeakingEvent(t, events, "user", true)
+		if l, _, _ := i.voiceActivityOf("user"); l != -30 {
+			t.Errorf("got level %v, want -30", l)
+		}
+	})
+}
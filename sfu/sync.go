@@ -0,0 +1,109 @@
+package sfu
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+const syncSidecarSuffix = ".sync.json"
+
+type driftSample struct {
+	Kind       string  `json:"kind"`
+	SSRC       uint32  `json:"ssrc"`
+	SinceStart int64   `json:"sinceStart"` // in ms since interaction start
+	Offset     float64 `json:"offset"`     // in ms, server reception time minus sender NTP time
+	Drift      float64 `json:"drift"`      // in ms, offset change since the first report of this SSRC
+}
+
+// first buffer of a recorded stream, see types.StreamTiming
+type streamSync struct {
+	types.StreamTiming
+	CapturedOffset float64 `json:"capturedOffset"` // in ms since interaction start
+}
+
+// syncSidecar places a recording on the interaction clock, it is written next
+// to the recording with syncSidecarSuffix appended to its name
+type syncSidecar struct {
+	File                 string               `json:"file"`
+	InteractionStartedAt time.Time            `json:"interactionStartedAt"`
+	PipelineStartedAt    time.Time            `json:"pipelineStartedAt"`
+	FirstBufferAt        *time.Time           `json:"firstBufferAt,omitempty"`     // earliest capture of streams
+	FirstBufferOffset    *float64             `json:"firstBufferOffset,omitempty"` // in ms since interaction start
+	Streams              []streamSync         `json:"streams"`
+	SenderReports        []types.SenderReport `json:"senderReports"`
+	Drift                []driftSample        `json:"drift"`
+}
+
+func msSince(t, origin time.Time) float64 {
+	return float64(t.Sub(origin).Microseconds()) / 1000
+}
+
+func newDriftSamples(reports []types.SenderReport, origin time.Time) []driftSample {
+	samples := []driftSample{}
+	firstOffsets := make(map[uint32]float64)
+	for _, r := range reports {
+		offset := msSince(r.ReceivedAt, r.NTPTime)
+		first, ok := firstOffsets[r.SSRC]
+		if !ok {
+			first = offset
+			firstOffsets[r.SSRC] = offset
+		}
+		samples = append(samples, driftSample{
+			Kind:       r.Kind,
+			SSRC:       r.SSRC,
+			SinceStart: r.ReceivedAt.Sub(origin).Milliseconds(),
+			Offset:     offset,
+			Drift:      offset - first,
+		})
+	}
+	return samples
+}
+
+// writes a sync sidecar for each recording, once pipelines are done
+func (i *interaction) writeSyncSidecars() {
+	i.RLock()
+	origin := i.startedAt
+	if origin.IsZero() {
+		origin = i.createdAt
+	}
+	segments := []segment{}
+	for _, s := range i.segmentsIndex {
+		segments = append(segments, s...)
+	}
+	i.RUnlock()
+
+	for _, s := range segments {
+		info := s.processor.SyncInfo()
+		drift := newDriftSamples(info.SenderReports, origin)
+		for _, file := range s.files {
+			sidecar := syncSidecar{
+				File:                 filepath.Base(file),
+				InteractionStartedAt: origin,
+				PipelineStartedAt:    info.StartedAt,
+				SenderReports:        info.SenderReports,
+				Drift:                drift,
+			}
+			sidecar.Streams = []streamSync{}
+			for _, st := range info.Streams[file] {
+				sidecar.Streams = append(sidecar.Streams, streamSync{st, msSince(st.CapturedAt, origin)})
+				if sidecar.FirstBufferAt == nil || st.CapturedAt.Before(*sidecar.FirstBufferAt) {
+					capturedAt := st.CapturedAt
+					offset := msSince(capturedAt, origin)
+					sidecar.FirstBufferAt = &capturedAt
+					sidecar.FirstBufferOffset = &offset
+				}
+			}
+			formatted, err := json.MarshalIndent(sidecar, "", "  ")
+			if err == nil {
+				err = os.WriteFile(file+syncSidecarSuffix, append(formatted, '\n'), 0644)
+			}
+			if err != nil {
+				i.logger.Error().Str("context", "recording").Str("user", s.jp.UserId).Str("file", filepath.Base(file)).Err(err).Msg("sync_sidecar_write_failed")
+			}
+		}
+	}
+}
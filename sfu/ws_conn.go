@@ -19,8 +19,13 @@ const (
 	MaxParsedLength = 50
 )
 
+var fxIdentifierRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
 var recordingModes = []string{"forced", "free", "reenc", "split", "rtpbin_only", "none", "direct", "bypass"}
 
+// recording modes whose pipelines don't decode streams, and can't record them losslessly
+var noLosslessRecordingModes = []string{"rtpbin_only", "none", "direct", "bypass"}
+
 // Helper to make Gorilla Websockets threadsafe
 type wsConn struct {
 	sync.Mutex
@@ -44,20 +49,40 @@ type messageIn struct {
 }
 
 type controlPayload struct {
-	UserId   string  `json:"userId"`
-	Name     string  `json:"name"`
-	Property string  `json:"property"`
-	Value    float32 `json:"value"`
-	Duration int     `json:"duration"`
+	UserId     string  `json:"userId"`
+	ReceiverId string  `json:"receiverId"` // optional, see JoinPayload#ReceiverFx
+	Name       string  `json:"name"`
+	Property   string  `json:"property"`
+	Value      float32 `json:"value"`
+	Duration   int     `json:"duration"`
 	// not from unmarshalling
 	fromUserId string
 }
 
+type avOffsetPayload struct {
+	UserId   string `json:"userId"`
+	Offset   int    `json:"offset"`   // in ms, positive when audio is late
+	Duration int    `json:"duration"` // in ms, of the ramp from the current offset
+	// not from unmarshalling
+	fromUserId string
+}
+
+type mirrorDelayPayload struct {
+	Delay    int `json:"delay"`    // in ms
+	Duration int `json:"duration"` // in ms, of the ramp from the current delay
+}
+
+type confederatePayload struct {
+	UserId string `json:"userId"`
+	Action string `json:"action"` // "play", "pause" or "rewind"
+}
+
 type polyControlPayload struct {
-	Name     string `json:"name"`
-	Property string `json:"property"`
-	Kind     string `json:"kind"`
-	Value    string `json:"value"`
+	ReceiverId string `json:"receiverId"`
+	Name       string `json:"name"`
+	Property   string `json:"property"`
+	Kind       string `json:"kind"`
+	Value      string `json:"value"`
 }
 
 // remove special characters like / . *
@@ -70,6 +95,52 @@ func parseString(str string) string {
 	return clean
 }
 
+// keep only effect elements and properties with valid identifiers (which can't
+// break the pipeline description) and scalar property values
+func parseFx(fx types.Fx) (parsed types.Fx) {
+	for _, el := range fx {
+		if len(el.Raw) > 0 {
+			parsed = append(parsed, el)
+			continue
+		}
+		if !fxIdentifierRegexp.MatchString(el.Element) || (len(el.Name) > 0 && !fxIdentifierRegexp.MatchString(el.Name)) {
+			continue
+		}
+		props := make(map[string]any)
+		for prop, value := range el.Properties {
+			if !fxIdentifierRegexp.MatchString(prop) || prop == "name" {
+				continue
+			}
+			switch value.(type) {
+			case float64, bool, string:
+				props[prop] = value
+			}
+		}
+		el.Properties = props
+		parsed = append(parsed, el)
+	}
+	return
+}
+
+func parseReceiverFx(jp types.JoinPayload) map[string]types.ReceiverFx {
+	parsed := make(map[string]types.ReceiverFx)
+	if jp.RecordingMode == "bypass" {
+		// no processing at all
+		return parsed
+	}
+	for receiverId, rfx := range jp.ReceiverFx {
+		receiverId = parseString(receiverId)
+		if len(receiverId) == 0 {
+			continue
+		}
+		parsed[receiverId] = types.ReceiverFx{
+			AudioFx: parseFx(rfx.AudioFx),
+			VideoFx: parseFx(rfx.VideoFx),
+		}
+	}
+	return parsed
+}
+
 func parseVideoFormat(jp types.JoinPayload) (videoFormat string) {
 	videoFormat = jp.VideoFormat
 	if videoFormat != "VP8" && videoFormat != "H264" {
@@ -86,6 +157,24 @@ func parseRecordingMode(jp types.JoinPayload) string {
 	}
 }
 
+// lossless options are disabled (and a warning logged) when the recording mode can't honor them
+func (ws *wsConn) parseLossless(jp types.JoinPayload) (audio, video bool) {
+	audio, video = jp.LosslessAudio, jp.LosslessVideo
+	if !audio && !video {
+		return
+	}
+	noLossless := slices.Contains(noLosslessRecordingModes, jp.RecordingMode)
+	if jp.AudioOnly {
+		// rtpbin_only and direct modes are rendered with the audio only template
+		noLossless = jp.RecordingMode == "none" || jp.RecordingMode == "bypass"
+	}
+	if noLossless {
+		ws.logger.Warn().Str("user", jp.UserId).Str("value", jp.RecordingMode).Msg("lossless_recording_ignored")
+		return false, false
+	}
+	return
+}
+
 func parseWidth(jp types.JoinPayload) (width int) {
 	width = jp.Width
 	if width == 0 {
@@ -136,7 +225,9 @@ func (ws *wsConn) readJoin(origin string) (jp types.JoinPayload, err error) {
 	if err != nil {
 		// no need to ws.send an error if we can't read
 		return
-	} else if m.Kind != "join" {
+	}
+	countInboundMessage(m.Kind)
+	if m.Kind != "join" {
 		err = errors.New("wrong_join_payload_kind")
 		ws.rawSend("error-join")
 		return
@@ -153,6 +244,10 @@ func (ws *wsConn) readJoin(origin string) (jp types.JoinPayload, err error) {
 	jp.Width = parseWidth(jp)
 	jp.Height = parseHeight(jp)
 	jp.Framerate = parseFramerate(jp)
+	jp.AudioFx = parseFx(jp.AudioFx)
+	jp.VideoFx = parseFx(jp.VideoFx)
+	jp.ReceiverFx = parseReceiverFx(jp)
+	jp.LosslessAudio, jp.LosslessVideo = ws.parseLossless(jp)
 	// add property
 	jp.Origin = origin
 
@@ -184,7 +279,9 @@ func (ws *wsConn) connectPeerServer(ps *peerServer) {
 func (ws *wsConn) receive() (m messageIn, err error) {
 	err = ws.ReadJSON(&m)
 
-	if err != nil && websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+	if err == nil {
+		countInboundMessage(m.Kind)
+	} else if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
 		ws.ps.close("ws_read_error")
 		ws.logError().Err(err).Msg("read_json_failed")
 	}
@@ -196,6 +293,7 @@ func (ws *wsConn) send(text string) (err error) {
 	defer ws.Unlock()
 
 	m := messageOut{Kind: text}
+	countOutboundMessage(text)
 
 	if err = ws.WriteJSON(m); err != nil {
 		ws.ps.close("ws_write_error")
@@ -211,6 +309,7 @@ func (ws *wsConn) sendWithPayload(kind string, payload any) (err error) {
 		Kind:    kind,
 		Payload: payload,
 	}
+	countOutboundMessage(kind)
 
 	if err = ws.WriteJSON(m); err != nil {
 		ws.ps.close("ws_write_error")
@@ -225,6 +324,7 @@ func (ws *wsConn) rawSend(text string) (err error) {
 	defer ws.Unlock()
 
 	m := messageOut{Kind: text}
+	countOutboundMessage(text)
 	ws.WriteJSON(m)
 	return
 }
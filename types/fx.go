@@ -0,0 +1,65 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// FxElement describes one GStreamer element of an effect chain, for instance:
+// {"element": "pitch", "name": "fx", "properties": {"pitch": 0.8}}
+type FxElement struct {
+	Element    string         `json:"element"`
+	Name       string         `json:"name,omitempty"` // used to control the element with controlFx
+	Properties map[string]any `json:"properties,omitempty"`
+	// legacy format: raw GStreamer description like "pitch pitch=0.8 name=fx"
+	Raw string `json:"raw,omitempty"`
+}
+
+// ReceiverFx describes the effects applied to a stream sent to a given receiver,
+// instead of the sender's default audioFx and videoFx
+type ReceiverFx struct {
+	AudioFx Fx `json:"audioFx"`
+	VideoFx Fx `json:"videoFx"`
+}
+
+// Fx is an ordered chain of effects applied to one media kind
+type Fx []FxElement
+
+// UnmarshalJSON accepts either a list of effect objects or, for
+// backward compatibility, a raw GStreamer string
+func (fx *Fx) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err == nil {
+		if len(strings.TrimSpace(raw)) > 0 {
+			*fx = Fx{{Raw: raw}}
+		} else {
+			*fx = nil
+		}
+		return nil
+	}
+
+	var elements []FxElement
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return err
+	}
+	*fx = elements
+	return nil
+}
+
+// Coupling maps a live signal of a source user to an fx property of a target user,
+// see the "Coupling rules" section of README
+type Coupling struct {
+	Source     string `json:"source"`     // user id
+	Signal     string `json:"signal"`     // "level", "speaking" or "tracking"
+	Target     string `json:"target"`     // user id
+	ReceiverId string `json:"receiverId"` // optional, see JoinPayload#ReceiverFx
+	Name       string `json:"name"`       // fx name
+	Property   string `json:"property"`
+	// signal range mapped to [Min, Max] (defaults depend on Signal)
+	InputMin float64 `json:"inputMin"`
+	InputMax float64 `json:"inputMax"`
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	// time constant (in ms) of exponential smoothing, 0 to disable
+	Smoothing int `json:"smoothing"`
+}
@@ -0,0 +1,145 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// types shared by media processing (see the gst package) and its callers
+
+// FilePrefix names the recordings of a user connection (and of the stream sent to
+// receiverId if not empty), timestamped with the current time
+func FilePrefix(jp JoinPayload, iRandomId string, connectionCount int, receiverId string) string {
+	return "i-" + iRandomId +
+		"-a-" + time.Now().Format("20060102-150405.000") +
+		"-s-" + jp.Namespace +
+		"-n-" + jp.InteractionName +
+		"-u-" + jp.UserId +
+		"-c-" + fmt.Sprint(connectionCount) +
+		ReceiverSuffix(receiverId)
+}
+
+// ReceiverSuffix is appended to names of files related to the stream sent to receiverId
+func ReceiverSuffix(receiverId string) string {
+	if len(receiverId) > 0 {
+		return "-r-" + receiverId
+	}
+	return ""
+}
+
+// FileReport is the result of the verification of a recording
+type FileReport struct {
+	File     string         `json:"file"`
+	Valid    bool           `json:"valid"`
+	Error    string         `json:"error,omitempty"`
+	Size     int64          `json:"size"`
+	SHA256   string         `json:"sha256,omitempty"`
+	Duration int64          `json:"duration"` // in ms, from the earliest first PTS to the latest last PTS
+	Streams  []StreamReport `json:"streams"`
+}
+
+// StreamReport describes one (parsed but not decoded) stream of a recording
+type StreamReport struct {
+	Codec    string `json:"codec"` // caps name, for instance "video/x-h264"
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	FirstPTS int64  `json:"firstPts"` // in ns, -1 if no timestamped buffer
+	LastPTS  int64  `json:"lastPts"`  // in ns, end of the last buffer
+}
+
+// SyncInfo gathers what is needed to place recordings on a common clock
+type SyncInfo struct {
+	StartedAt     time.Time                 // pipeline start
+	Streams       map[string][]StreamTiming // per recording file
+	SenderReports []SenderReport
+}
+
+// StreamTiming locates the first buffer of a recorded stream when it reaches the muxer
+// (or the encoder of lossless files), before muxing and writing delays
+type StreamTiming struct {
+	Kind        string `json:"kind"`
+	PTS         int64  `json:"pts"`         // in ns
+	RunningTime int64  `json:"runningTime"` // in ns, PTS converted with the stream segment
+	BaseTime    int64  `json:"baseTime"`    // in ns, pipeline clock time when it started playing
+	ClockTime   int64  `json:"clockTime"`   // in ns, pipeline clock time when the buffer reached the muxer
+	// server wall clock of base time + running time
+	CapturedAt time.Time `json:"capturedAt"`
+	// sender wall clock of the captured media, derived from the RTCP SR mapping (if the
+	// sender has sent reports)
+	SenderCapturedAt *time.Time `json:"senderCapturedAt,omitempty"`
+}
+
+// SenderReport is the RTP/NTP mapping received from the sender (RTCP SR)
+type SenderReport struct {
+	Kind       string    `json:"kind"`
+	SSRC       uint32    `json:"ssrc"`
+	ReceivedAt time.Time `json:"receivedAt"` // server wall clock
+	NTPTime    time.Time `json:"ntpTime"`    // sender wall clock
+	RTPTime    uint32    `json:"rtpTime"`
+	ClockRate  int       `json:"clockRate"`
+}
+
+// FeatureOptions configures offline feature extraction
+type FeatureOptions struct {
+	VADThreshold float64 // dBFS
+	VADHangover  time.Duration
+}
+
+// FeatureReport sums up the features extracted from a recording
+type FeatureReport struct {
+	File     string `json:"file"`
+	AudioCSV string `json:"audioCsv,omitempty"`
+	VideoCSV string `json:"videoCsv,omitempty"`
+	// audio
+	VoiceRatio float64 `json:"voiceRatio,omitempty"` // part of the duration with voice activity
+	// video
+	Frames            int     `json:"frames,omitempty"`
+	DroppedFrames     int     `json:"droppedFrames,omitempty"` // estimated from gaps between frames
+	ResolutionChanges int     `json:"resolutionChanges,omitempty"`
+	Framerate         float64 `json:"framerate,omitempty"` // nominal, from the median frame interval
+}
+
+// CompositeInput is the recording of one participant, aligned on the others by Delay
+type CompositeInput struct {
+	AudioFile  string        // empty if no audio
+	VideoFile  string        // empty if no video, may be the same as AudioFile
+	AudioDelay time.Duration // filled with silence before the recording
+	VideoDelay time.Duration // filled with black frames before the recording
+}
+
+// Segment is a recording made during one connection of a user
+type Segment struct {
+	File     string
+	Gap      time.Duration // gap to be filled after this segment (before the next one)
+	HasAudio bool
+	HasVideo bool
+}
+
+// ControlEvent is a change of an fx property (as sent by clients with controlFx),
+// At being relative to the start of the recording
+type ControlEvent struct {
+	Name     string // fx name, as declared by the client
+	Property string
+	At       time.Duration
+	Duration time.Duration // interpolation duration, 0 for an instant change
+	Value    float64
+}
+
+// PlayerOptions describes the media file read by a player (see gst.Player) and the streams it outputs
+type PlayerOptions struct {
+	File        string
+	AudioOnly   bool
+	VideoFormat string // "VP8" or "H264"
+	Width       int
+	Height      int
+	Framerate   int
+	Loop        bool   // rewinds when the end of file is reached
+	Autoplay    bool   // plays when started, otherwise waits for Play
+	DataFolder  string // used by encoders needing a cache
+}
+
+// SampleWriter receives encoded samples: Opus frames, VP8 frames or H264 access units
+// (byte-stream)
+type SampleWriter interface {
+	WriteSample(buf []byte, duration time.Duration) error
+}
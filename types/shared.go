@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 type JoinPayload struct {
 	InteractionName string `json:"interactionName"`
 	UserId          string `json:"userId"`
@@ -9,14 +11,41 @@ type JoinPayload struct {
 	VideoFormat   string `json:"videoFormat"`
 	RecordingMode string `json:"recordingMode"`
 	Size          int    `json:"size"`
-	AudioFx       string `json:"audioFx"`
-	VideoFx       string `json:"videoFx"`
+	AudioFx       Fx     `json:"audioFx"`
+	VideoFx       Fx     `json:"videoFx"`
 	Width         int    `json:"width"`
 	Height        int    `json:"height"`
 	Framerate     int    `json:"framerate"`
 	GPU           bool   `json:"gpu"`
 	Overlay       bool   `json:"overlay"`
 	AudioOnly     bool   `json:"audioOnly"`
+	// also record (decoded) audio and video with lossless codecs, for analyses
+	LosslessAudio bool `json:"losslessAudio"`
+	LosslessVideo bool `json:"losslessVideo"`
+	// once the interaction has ended, renders all participants ("dry" or "wet" recordings) to a
+	// single video and a multichannel WAV (interaction level option, set by the first user)
+	Composite string `json:"composite"`
+	// once the interaction has ended, extracts audio features and video statistics from
+	// recordings (interaction level option, set by the first user)
+	Features bool `json:"features"`
+	// sends speaking events (from live voice activity detection) to all participants
+	// (interaction level option, set by the first user)
+	SpeakingEvents bool `json:"speakingEvents"`
+	// closed-loop effects driven by live signals (interaction level option, set by the first user)
+	Couplings []Coupling `json:"couplings"`
+	// network impairments between participants (interaction level option, set by the first user)
+	Impairments []Impairment `json:"impairments"`
+	// per receiver user id, processes this user's stream differently for the given receivers
+	ReceiverFx map[string]ReceiverFx `json:"receiverFx"`
+	// shifts audio against video in the live stream sent to others (in ms, positive
+	// when audio is late), recordings are not affected
+	AVOffset int `json:"avOffset"`
+	// delays the streams sent back to the user when size is 1 (mirror), in ms
+	MirrorDelay int `json:"mirrorDelay"`
+	// virtual participants joining the interaction when it is created
+	Confederates []Confederate `json:"confederates"`
+	// writes inbound RTP and RTCP packets to rtpdump files, to be replayed for debugging
+	CaptureRTP bool `json:"captureRTP"`
 	// Not from JSON
 	Origin string
 }
@@ -33,3 +62,37 @@ type Terminable interface {
 type PLIRequester interface {
 	PLIRequest(cause string)
 }
+
+// Confederate is a virtual participant publishing a pre-recorded media file, see the
+// "Confederates" section of README
+type Confederate struct {
+	UserId   string `json:"userId"`
+	File     string `json:"file"` // relative to DUCKSOUP_MEDIA_FOLDER
+	AudioFx  Fx     `json:"audioFx"`
+	VideoFx  Fx     `json:"videoFx"`
+	Loop     bool   `json:"loop"`
+	Autoplay bool   `json:"autoplay"` // plays as soon as connected, otherwise waits for a cue
+}
+
+// Impairment degrades the stream sent by From to To, see the "Network impairment" section of README
+type Impairment struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Delay  int     `json:"delay"`  // one-way, in ms
+	Jitter int     `json:"jitter"` // in ms, added to or subtracted from delay
+	Loss   float64 `json:"loss"`   // packet loss probability, from 0 to 1
+}
+
+func (i Impairment) IsZero() bool {
+	return i.Delay <= 0 && i.Jitter <= 0 && i.Loss <= 0
+}
+
+// CaptureInfo describes the raw RTP capture of a user connection (see JoinPayload#CaptureRTP),
+// it is written next to the rtpdump files and read by the replay command
+type CaptureInfo struct {
+	JoinPayload     JoinPayload       `json:"joinPayload"`
+	IRandomId       string            `json:"interactionRandomId"`
+	ConnectionCount int               `json:"connectionCount"`
+	StartedAt       time.Time         `json:"startedAt"`
+	Files           map[string]string `json:"files"` // rtpdump file names (in the same folder) per kind
+}
@@ -0,0 +1,32 @@
+// Package vad detects voice activity from audio levels
+package vad
+
+import "time"
+
+// Detector turns RMS levels (in dBFS) into speaking states: voice starts
+// as soon as the level reaches Threshold and stops once it has stayed below it for
+// Hangover, so that short pauses between words don't split speech
+type Detector struct {
+	Threshold float64
+	Hangover  time.Duration
+	speaking  bool
+	lastVoice time.Duration
+}
+
+func NewDetector(threshold float64, hangover time.Duration) *Detector {
+	return &Detector{Threshold: threshold, Hangover: hangover}
+}
+
+// Update processes the level measured at the given stream time, and returns the
+// speaking state and whether it has just changed
+func (v *Detector) Update(at time.Duration, rms float64) (speaking, changed bool) {
+	if rms >= v.Threshold {
+		v.lastVoice = at
+		changed = !v.speaking
+		v.speaking = true
+	} else if v.speaking && at-v.lastVoice >= v.Hangover {
+		changed = true
+		v.speaking = false
+	}
+	return v.speaking, changed
+}
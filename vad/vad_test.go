@@ -0,0 +1,30 @@
+package vad
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetector(t *testing.T) {
+	vad := NewDetector(-45, 300*time.Millisecond)
+
+	steps := []struct {
+		at       time.Duration
+		rms      float64
+		speaking bool
+		changed  bool
+	}{
+		{0, -60, false, false},
+		{10 * time.Millisecond, -30, true, true},
+		{20 * time.Millisecond, -30, true, false},
+		{200 * time.Millisecond, -60, true, false}, // within hangover
+		{320 * time.Millisecond, -60, false, true},
+		{330 * time.Millisecond, -60, false, false},
+	}
+	for _, s := range steps {
+		speaking, changed := vad.Update(s.at, s.rms)
+		if speaking != s.speaking || changed != s.changed {
+			t.Errorf("at %v got (%v, %v) but expected (%v, %v)", s.at, speaking, changed, s.speaking, s.changed)
+		}
+	}
+}